@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+)
+
+// publicRoutes can be called without credentials.
+var publicRoutes = map[string]bool{
+	"Index":    true,
+	"Healthz":  true,
+	"Readyz":   true,
+	"Metrics":  true,
+	"OpenAPI":  true,
+	"WebUI":    true,
+	"WebAsset": true,
+}
+
+// meteredRoutes spend LLM credit and count against the daily quota.
+var meteredRoutes = map[string]bool{
+	"ParseMove":     true,
+	"MakeMove":      true,
+	"GenHelp":       true,
+	"GenHelpStream": true,
+	"Hint":          true,
+	"CreateJob":     true,
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Subject string
+	Method  string
+	Quota   int
+}
+
+// principalFromContext returns the caller stored in ctx, if any.
+func principalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey).(Principal)
+	return p, ok
+}
+
+// parseAPIKeys parses a comma separated list of key:subject[:quota]
+// entries, indexed by the SHA-256 of the key.
+func parseAPIKeys(raw string, defaultQuota int) (map[[32]byte]Principal, error) {
+	keys := make(map[[32]byte]Principal)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid API key entry, want key:subject[:quota]")
+		}
+		p := Principal{Subject: parts[1], Method: "api_key", Quota: defaultQuota}
+		if len(parts) == 3 {
+			q, err := strconv.Atoi(parts[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quota for %s: %w", parts[1], err)
+			}
+			p.Quota = q
+		}
+		keys[sha256.Sum256([]byte(parts[0]))] = p
+	}
+	return keys, nil
+}
+
+// authEnabled reports whether any credentials are configured. With
+// none, the API stays open for local development.
+func (s *Server) authEnabled() bool {
+	return len(s.apiKeys) > 0 || s.cfg.JWTSecret != ""
+}
+
+// requestToken returns the credentials sent with r: an X-API-Key
+// header, an Authorization bearer token or, for WebSocket upgrades
+// where browsers cannot set headers, an access_token query parameter.
+func requestToken(r *http.Request) string {
+	if token := r.Header.Get("X-API-Key"); token != "" {
+		return token
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+// authenticate resolves the caller from the request token, which holds
+// either an API key or a JWT.
+func (s *Server) authenticate(r *http.Request) (Principal, error) {
+	token := requestToken(r)
+	if token == "" {
+		return Principal{}, errors.New("missing credentials")
+	}
+
+	if p, ok := s.apiKeys[sha256.Sum256([]byte(token))]; ok {
+		return p, nil
+	}
+
+	if s.cfg.JWTSecret == "" {
+		return Principal{}, errors.New("invalid API key")
+	}
+
+	var claims struct {
+		Quota int `json:"quota,omitempty"`
+		jwt.RegisteredClaims
+	}
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (any, error) {
+		return []byte(s.cfg.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+	if err != nil {
+		return Principal{}, fmt.Errorf("invalid token: %w", err)
+	}
+	if claims.Subject == "" {
+		return Principal{}, errors.New("invalid token: missing subject")
+	}
+
+	p := Principal{Subject: claims.Subject, Method: "jwt", Quota: s.cfg.DailyQuota}
+	if claims.Quota > 0 {
+		p.Quota = claims.Quota
+	}
+	return p, nil
+}
+
+// Auth receives a handler, wraps the passed handler with
+// authentication and, for metered routes, daily quota enforcement.
+// Requests a metered route rejects with a 4xx status are not counted.
+func (s *Server) Auth(inner http.Handler, name string) http.Handler {
+	if publicRoutes[name] || !s.authEnabled() {
+		return inner
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := s.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="chess-api"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey, p)
+		if !meteredRoutes[name] {
+			inner.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		// Charge up front so concurrent requests can't overrun the
+		// quota, then give the call back if the request was turned
+		// away as invalid.
+		err = s.chargeQuota(r.Context(), p, name, 1)
+		switch {
+		case errors.Is(err, errQuotaExceeded):
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		inner.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status >= 400 && rec.status < 500 {
+			if err := s.recordUsage(context.WithoutCancel(r.Context()), p.Subject, name, -1); err != nil {
+				slog.WarnContext(r.Context(), "refunding quota failed", "subject", p.Subject, "route", name, "error", err)
+			}
+		}
+	})
+}
+
+// errQuotaExceeded is returned when a caller has used up its daily quota.
+var errQuotaExceeded = errors.New("daily quota exceeded")
+
+// chargeQuota counts n calls to route against the caller's daily
+// quota. Callers are not charged when authentication is disabled.
+//
+// The check and the charge run in one transaction holding a lock on the
+// subject, so concurrent calls queue up rather than all reading the
+// same usage and going over the quota together.
+func (s *Server) chargeQuota(ctx context.Context, p Principal, route string, n int) error {
+	if p.Subject == "" || n <= 0 {
+		return nil
+	}
+	if p.Quota <= 0 {
+		return s.recordUsage(ctx, p.Subject, route, n)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", "api_usage:"+p.Subject)
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+	var used int
+	err = tx.QueryRowContext(ctx,
+		"SELECT coalesce(sum(count), 0) FROM api_usage WHERE subject = $1 AND day = current_date",
+		p.Subject).Scan(&used)
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+	if used+n > p.Quota {
+		return fmt.Errorf("%w: %d requests", errQuotaExceeded, p.Quota)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO api_usage (subject, day, route, count) VALUES ($1, current_date, $2, $3)
+		ON CONFLICT (subject, day, route) DO UPDATE SET count = api_usage.count + $3`,
+		p.Subject, route, n)
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+	return nil
+}
+
+// recordUsage counts n calls to route by subject today. A negative n
+// gives calls back.
+func (s *Server) recordUsage(ctx context.Context, subject, route string, n int) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO api_usage (subject, day, route, count) VALUES ($1, current_date, $2, $3)
+		ON CONFLICT (subject, day, route) DO UPDATE SET count = api_usage.count + $3`,
+		subject, route, n)
+	if err != nil {
+		return fmt.Errorf("ERROR: %w", err)
+	}
+	return nil
+}
+
+// Usage reports the caller's metered usage for today.
+func (s *Server) Usage(w http.ResponseWriter, r *http.Request) {
+	p, ok := principalFromContext(r.Context())
+	if !ok {
+		http.Error(w, "authentication is not configured", http.StatusNotFound)
+		return
+	}
+
+	rows, err := s.db.QueryContext(r.Context(),
+		"SELECT route, count FROM api_usage WHERE subject = $1 AND day = current_date",
+		p.Subject)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	resp := UsageResponse{
+		Subject: p.Subject,
+		Quota:   p.Quota,
+		ByRoute: make(map[string]int),
+	}
+	err = s.db.QueryRowContext(r.Context(), "SELECT to_char(current_date, 'YYYY-MM-DD')").Scan(&resp.Day)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var route string
+		var count int
+		if err := rows.Scan(&route, &count); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.ByRoute[route] = count
+		resp.Used += count
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if p.Quota > 0 {
+		resp.Remaining = max(p.Quota-resp.Used, 0)
+	}
+
+	// Return the response.
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
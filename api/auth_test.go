@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+// TestChargeQuotaConcurrent charges a subject from many goroutines at
+// once and checks that exactly its quota goes through. It needs a
+// Postgres database, from TEST_DB_CONN_STR.
+func TestChargeQuotaConcurrent(t *testing.T) {
+	conn := os.Getenv("TEST_DB_CONN_STR")
+	if conn == "" {
+		t.Skip("TEST_DB_CONN_STR not set")
+	}
+	db, err := sql.Open("postgres", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	s := &Server{db: db}
+	if err := s.migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	p := Principal{Subject: "test-" + newID(), Quota: 5}
+	t.Cleanup(func() { db.Exec("DELETE FROM api_usage WHERE subject = $1", p.Subject) })
+
+	var mu sync.Mutex
+	var charged, refused int
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.chargeQuota(ctx, p, "MakeMove", 1)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				charged++
+			case errors.Is(err, errQuotaExceeded):
+				refused++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if charged != p.Quota || refused != 20-p.Quota {
+		t.Errorf("charged %d and refused %d calls, want %d and %d", charged, refused, p.Quota, 20-p.Quota)
+	}
+
+	if err := s.chargeQuota(ctx, Principal{Subject: p.Subject, Quota: 10}, "CreateJob", 6); !errors.Is(err, errQuotaExceeded) {
+		t.Errorf("charging 6 calls with 5 left: got %v, want errQuotaExceeded", err)
+	}
+}
+
+// TestAuthRefundsRejected checks that a metered request the handler
+// rejects with a 4xx status doesn't count against the quota. It needs a
+// Postgres database, from TEST_DB_CONN_STR.
+func TestAuthRefundsRejected(t *testing.T) {
+	conn := os.Getenv("TEST_DB_CONN_STR")
+	if conn == "" {
+		t.Skip("TEST_DB_CONN_STR not set")
+	}
+	db, err := sql.Open("postgres", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	subject := "test-" + newID()
+	apiKeys, err := parseAPIKeys("key:"+subject, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{db: db, apiKeys: apiKeys}
+	if err := s.migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM api_usage WHERE subject = $1", subject) })
+
+	h := s.Auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("status") {
+		case "400":
+			http.Error(w, "invalid game", http.StatusBadRequest)
+		case "500":
+			http.Error(w, "the LLM failed", http.StatusInternalServerError)
+		}
+	}), "MakeMove")
+	for i, tt := range []struct {
+		status string
+		want   int
+	}{
+		{"400", http.StatusBadRequest},
+		{"400", http.StatusBadRequest},
+		{"400", http.StatusBadRequest},
+		{"", http.StatusOK},
+		{"500", http.StatusInternalServerError},
+		{"", http.StatusTooManyRequests},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/move?status="+tt.status, nil)
+		req.Header.Set("X-API-Key", "key")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("request %d: status %d, want %d", i, rec.Code, tt.want)
+		}
+	}
+
+	var used int
+	if err := db.QueryRow("SELECT coalesce(sum(count), 0) FROM api_usage WHERE subject = $1", subject).Scan(&used); err != nil {
+		t.Fatal(err)
+	}
+	if used != 2 {
+		t.Errorf("used %d calls, want 2: the 200 and the 500", used)
+	}
+}
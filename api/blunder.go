@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/engine"
+	"github.com/notnil/chess"
+)
+
+// blunderDepth is the depth of the shallow searches that check LLM moves.
+// It is enough to see a piece left hanging or a mate in one.
+const blunderDepth = 2
+
+// difficultyMargins are the blunder margins of the difficulty levels, in
+// centipawns. Zero lets any legal move through.
+var difficultyMargins = map[string]int{
+	chessapi.DifficultyEasy:   0,
+	chessapi.DifficultyMedium: 250,
+	chessapi.DifficultyHard:   80,
+}
+
+// blunderMargin returns the blunder margin of a difficulty level, or the
+// configured margin if difficulty is empty.
+func (s *Server) blunderMargin(difficulty string) (int, error) {
+	if difficulty == "" {
+		return s.cfg.BlunderMargin, nil
+	}
+	margin, ok := difficultyMargins[difficulty]
+	if !ok {
+		return 0, fmt.Errorf("unknown difficulty %q, want %s, %s or %s", difficulty,
+			chessapi.DifficultyEasy, chessapi.DifficultyMedium, chessapi.DifficultyHard)
+	}
+	return margin, nil
+}
+
+// blunderCheck rejects moves that lose more than a margin compared with
+// the engine's best move in a position.
+type blunderCheck struct {
+	s      *Server
+	pos    *chess.Position
+	margin int
+	best   *candidate
+}
+
+// check returns why m is a blunder, or "" if it is within the margin.
+// The engine's best move is searched for once, on the first check.
+func (bc *blunderCheck) check(ctx context.Context, m *chess.Move) (string, error) {
+	if bc.best == nil {
+		cands, err := bc.s.searchPosition(ctx, bc.pos, 1, blunderDepth)
+		if err != nil {
+			return "", err
+		}
+		bc.best = &cands[0]
+	}
+
+	// Score the move by the opponent's best reply.
+	next := bc.pos.Update(m)
+	var score int
+	var reply string
+	switch next.Status() {
+	case chess.Checkmate:
+		return "", nil
+	case chess.Stalemate:
+	default:
+		replies, err := bc.s.searchPosition(ctx, next, 1, blunderDepth-1)
+		if err != nil {
+			return "", err
+		}
+		score, reply = -replies[0].Score, replies[0].SAN
+	}
+
+	loss := bc.best.Score - score
+	if loss <= bc.margin {
+		return "", nil
+	}
+	move := chess.AlgebraicNotation{}.Encode(bc.pos, m)
+	switch {
+	case score <= -engine.MateScore+100:
+		return fmt.Sprintf("%s allows a mate after %s", move, reply), nil
+	case reply == "":
+		return fmt.Sprintf("%s throws away the win with a stalemate", move), nil
+	case bc.best.Score >= engine.MateScore-100:
+		return fmt.Sprintf("%s misses mate in %d with %s", move, (engine.MateScore-bc.best.Score+1)/2, bc.best.SAN), nil
+	}
+	return fmt.Sprintf("%s loses about %.1f pawns after %s", move, float64(loss)/100, reply), nil
+}
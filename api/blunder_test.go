@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlunderCheck(t *testing.T) {
+	s := &Server{cfg: Config{EngineDepth: 2, EngineMoveTime: 10 * time.Second}}
+	tests := []struct {
+		name   string
+		fen    string
+		move   string
+		margin int
+		want   string // part of the reason, or "" for no blunder
+	}{
+		{"within the margin", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", "e2e4", 80, ""},
+		{"queen left to a knight", "6k1/8/2n5/8/8/8/8/3QK3 w - - 0 1", "d1d4", 250, "Qd4 loses about"},
+		{"queen loss within a huge margin", "6k1/8/2n5/8/8/8/8/3QK3 w - - 0 1", "d1d4", 2000, ""},
+		{"allows a back rank mate", "3r2k1/5ppp/8/8/8/8/5PPP/R5K1 w - - 0 1", "a1a2", 250, "Ra2 allows a mate after Rd1#"},
+		{"stalemates a lost king", "k7/8/1K6/8/8/8/8/2Q5 w - - 0 1", "c1c7", 250, "Qc7 throws away the win with a stalemate"},
+		{"misses mate in one", "6k1/5ppp/8/8/8/8/8/3RK3 w - - 0 1", "e1e2", 250, "Ke2 misses mate in 1 with Rd8#"},
+		{"mates", "6k1/5ppp/8/8/8/8/8/3RK3 w - - 0 1", "d1d8", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos := position(t, tt.fen)
+			bc := blunderCheck{s: s, pos: pos, margin: tt.margin}
+			got, err := bc.check(context.Background(), findMove(pos, tt.move))
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.want == "" && got != "":
+				t.Errorf("rejected with %q, want no blunder", got)
+			case !strings.Contains(got, tt.want):
+				t.Errorf("reason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package chessapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is returned when the API responds with a non-2xx status.
+type Error struct {
+	StatusCode int
+	Message    string
+
+	// RetryAfter is set from the Retry-After header on 429 responses.
+	RetryAfter time.Duration
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("chessapi: status %d: %s", err.StatusCode, err.Message)
+}
+
+// Client talks to the chess API.
+type Client struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+// NewClient constructs a Client for the API served at baseURL.
+func NewClient(baseURL string, options ...func(cln *Client)) *Client {
+	cln := Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 3 * time.Minute},
+	}
+
+	for _, option := range options {
+		option(&cln)
+	}
+
+	return &cln
+}
+
+// WithHTTPClient sets the HTTP client used for requests. Note that /move
+// and /help chain several LLM calls and can take a while.
+func WithHTTPClient(http *http.Client) func(cln *Client) {
+	return func(cln *Client) {
+		cln.http = http
+	}
+}
+
+// WithAPIKey sends key as a bearer token on every request. It can be a
+// static API key or a JWT.
+func WithAPIKey(key string) func(cln *Client) {
+	return func(cln *Client) {
+		cln.apiKey = key
+	}
+}
+
+// ParseMove applies a natural language move to a game.
+func (cln *Client) ParseMove(ctx context.Context, req ParseMoveRequest) (ParseMoveResponse, error) {
+	var resp ParseMoveResponse
+	if err := cln.do(ctx, http.MethodPost, "/parse", req, &resp); err != nil {
+		return ParseMoveResponse{}, err
+	}
+	return resp, nil
+}
+
+// MakeMove asks the API to play the next black move.
+func (cln *Client) MakeMove(ctx context.Context, req MakeMoveRequest) (MakeMoveResponse, error) {
+	var resp MakeMoveResponse
+	if err := cln.do(ctx, http.MethodPost, "/move", req, &resp); err != nil {
+		return MakeMoveResponse{}, err
+	}
+	return resp, nil
+}
+
+// GenHelp asks the API for advice on a game.
+func (cln *Client) GenHelp(ctx context.Context, req GenHelpRequest) (GenHelpResponse, error) {
+	var resp GenHelpResponse
+	if err := cln.do(ctx, http.MethodPost, "/help", req, &resp); err != nil {
+		return GenHelpResponse{}, err
+	}
+	return resp, nil
+}
+
+// GenHelpStream asks the API for advice on a game, calling fn with each
+// event as it streams in. It returns the final response once the done
+// event arrives. Returning an error from fn stops the stream.
+func (cln *Client) GenHelpStream(ctx context.Context, req GenHelpRequest, fn func(event string, ev HelpStreamEvent) error) (GenHelpResponse, error) {
+	resp, err := cln.send(ctx, http.MethodPost, "/help/stream", req, "text/event-stream")
+	if err != nil {
+		return GenHelpResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var event string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			continue
+		case !strings.HasPrefix(line, "data:"):
+			continue
+		}
+
+		var ev HelpStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &ev); err != nil {
+			return GenHelpResponse{}, fmt.Errorf("chessapi: decoding %s event: %w", event, err)
+		}
+		if fn != nil {
+			if err := fn(event, ev); err != nil {
+				return GenHelpResponse{}, err
+			}
+		}
+
+		switch event {
+		case HelpEventDone:
+			return GenHelpResponse{Message: ev.Message, ReferenceInfo: ev.ReferenceInfo, Tactics: ev.Tactics, Tablebase: ev.Tablebase}, nil
+		case HelpEventError:
+			return GenHelpResponse{}, fmt.Errorf("chessapi: %s", ev.Error)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return GenHelpResponse{}, fmt.Errorf("chessapi: reading stream: %w", err)
+	}
+
+	return GenHelpResponse{}, errors.New("chessapi: stream ended before the answer")
+}
+
+// GameState describes a game without calling the LLM.
+func (cln *Client) GameState(ctx context.Context, req GameStateRequest) (GameStateResponse, error) {
+	var resp GameStateResponse
+	if err := cln.do(ctx, http.MethodPost, "/state", req, &resp); err != nil {
+		return GameStateResponse{}, err
+	}
+	return resp, nil
+}
+
+// Evaluate analyses the current position of a game without calling the
+// LLM.
+func (cln *Client) Evaluate(ctx context.Context, req EvaluateRequest) (EvaluateResponse, error) {
+	var resp EvaluateResponse
+	if err := cln.do(ctx, http.MethodPost, "/evaluate", req, &resp); err != nil {
+		return EvaluateResponse{}, err
+	}
+	return resp, nil
+}
+
+// Hint asks for a nudge towards a good move in a game.
+func (cln *Client) Hint(ctx context.Context, req HintRequest) (HintResponse, error) {
+	var resp HintResponse
+	if err := cln.do(ctx, http.MethodPost, "/hint", req, &resp); err != nil {
+		return HintResponse{}, err
+	}
+	return resp, nil
+}
+
+// Usage reports the caller's metered usage for today.
+func (cln *Client) Usage(ctx context.Context) (UsageResponse, error) {
+	var resp UsageResponse
+	if err := cln.do(ctx, http.MethodGet, "/usage", nil, &resp); err != nil {
+		return UsageResponse{}, err
+	}
+	return resp, nil
+}
+
+// Ready calls the readiness probe. A failing probe is returned as an
+// *Error with status 503.
+func (cln *Client) Ready(ctx context.Context) (HealthResponse, error) {
+	var resp HealthResponse
+	if err := cln.do(ctx, http.MethodGet, "/readyz", nil, &resp); err != nil {
+		return HealthResponse{}, err
+	}
+	return resp, nil
+}
+
+func (cln *Client) do(ctx context.Context, method string, path string, body any, v any) error {
+	resp, err := cln.send(ctx, method, path, body, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("chessapi: reading response: %w", err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("chessapi: response: %s, decoding error: %w", string(data), err)
+	}
+
+	return nil
+}
+
+// send makes a request, returning an *Error for non-2xx responses. The
+// caller closes the body of a successful response.
+func (cln *Client) send(ctx context.Context, method string, path string, body any, accept string) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("chessapi: encoding request: %w", err)
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, cln.baseURL+path, r)
+	if err != nil {
+		return nil, fmt.Errorf("chessapi: creating request: %w", err)
+	}
+	req.Header.Set("Accept", accept)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if cln.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cln.apiKey)
+	}
+
+	resp, err := cln.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("chessapi: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		apiErr := Error{
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(data)),
+		}
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			apiErr.RetryAfter = time.Duration(secs) * time.Second
+		}
+		return nil, &apiErr
+	}
+
+	return resp, nil
+}
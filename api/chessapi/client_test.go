@@ -0,0 +1,299 @@
+package chessapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// request is what the test server saw of a request.
+type request struct {
+	method, path, query   string
+	auth, accept, content string
+	body                  string
+}
+
+// testServer answers every request with reply and records it in got.
+func testServer(t *testing.T, got *request, reply func(w http.ResponseWriter)) *Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*got = request{
+			method:  r.Method,
+			path:    r.URL.EscapedPath(),
+			query:   r.URL.RawQuery,
+			auth:    r.Header.Get("Authorization"),
+			accept:  r.Header.Get("Accept"),
+			content: r.Header.Get("Content-Type"),
+			body:    string(body),
+		}
+		reply(w)
+	}))
+	t.Cleanup(srv.Close)
+	return NewClient(srv.URL+"/", WithAPIKey("key"))
+}
+
+func TestClientRequests(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name   string
+		call   func(cln *Client) (any, error)
+		method string
+		path   string
+		body   string // "" for no body
+		reply  string
+		want   any
+	}{
+		{"ParseMove", func(cln *Client) (any, error) {
+			return cln.ParseMove(ctx, ParseMoveRequest{Game: "*", Move: "pawn to e4", Coach: true})
+		}, http.MethodPost, "/parse", `{"game":"*","move":"pawn to e4","coach":true}`,
+			`{"move":"e2e4","game_updated":"1. e4 *"}`, ParseMoveResponse{Move: "e2e4", GameUpdated: "1. e4 *"}},
+		{"MakeMove", func(cln *Client) (any, error) {
+			return cln.MakeMove(ctx, MakeMoveRequest{Game: "1. e4 *", Difficulty: DifficultyHard})
+		}, http.MethodPost, "/move", `{"game":"1. e4 *","difficulty":"hard"}`,
+			`{"move":"e7e5","source":"book"}`, MakeMoveResponse{Move: "e7e5", Source: "book"}},
+		{"GenHelp", func(cln *Client) (any, error) {
+			return cln.GenHelp(ctx, GenHelpRequest{Game: "*"})
+		}, http.MethodPost, "/help", `{"game":"*"}`,
+			`{"message":"Take the centre."}`, GenHelpResponse{Message: "Take the centre."}},
+		{"GameState", func(cln *Client) (any, error) {
+			return cln.GameState(ctx, GameStateRequest{Game: "*"})
+		}, http.MethodPost, "/state", `{"game":"*"}`,
+			`{"turn":"white"}`, GameStateResponse{Turn: "white"}},
+		{"Evaluate", func(cln *Client) (any, error) {
+			return cln.Evaluate(ctx, EvaluateRequest{Game: "*"})
+		}, http.MethodPost, "/evaluate", `{"game":"*"}`,
+			`{"score":20}`, EvaluateResponse{Score: 20}},
+		{"Hint", func(cln *Client) (any, error) {
+			return cln.Hint(ctx, HintRequest{Game: "*", Level: HintLevelPiece})
+		}, http.MethodPost, "/hint", `{"game":"*","level":"piece"}`,
+			`{"level":"piece"}`, HintResponse{Level: HintLevelPiece}},
+		{"Usage", func(cln *Client) (any, error) {
+			return cln.Usage(ctx)
+		}, http.MethodGet, "/usage", "",
+			`{"subject":"alice"}`, UsageResponse{Subject: "alice"}},
+		{"Ready", func(cln *Client) (any, error) {
+			return cln.Ready(ctx)
+		}, http.MethodGet, "/readyz", "",
+			`{"status":"ok"}`, HealthResponse{Status: "ok"}},
+		{"CreateGame", func(cln *Client) (any, error) {
+			return cln.CreateGame(ctx, CreateGameRequest{Mode: ModeHuman})
+		}, http.MethodPost, "/games", `{"mode":"human"}`,
+			`{"id":"g1"}`, GameSessionResponse{ID: "g1"}},
+		{"GetGame", func(cln *Client) (any, error) {
+			return cln.GetGame(ctx, "a/b")
+		}, http.MethodGet, "/games/a%2Fb", "",
+			`{"id":"a/b"}`, GameSessionResponse{ID: "a/b"}},
+		{"CreateJob", func(cln *Client) (any, error) {
+			return cln.CreateJob(ctx, CreateJobRequest{Task: JobTaskHelp, Game: "*"})
+		}, http.MethodPost, "/jobs", `{"task":"help","game":"*"}`,
+			`{"id":"j1","task":"help","status":"queued","created_at":"2024-01-02T03:04:05Z"}`,
+			JobResponse{ID: "j1", Task: JobTaskHelp, Status: JobQueued, CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}},
+		{"GetJob", func(cln *Client) (any, error) {
+			return cln.GetJob(ctx, "j1")
+		}, http.MethodGet, "/jobs/j1", "",
+			`{"id":"j1","task":"help","status":"running","created_at":"2024-01-02T03:04:05Z"}`,
+			JobResponse{ID: "j1", Task: JobTaskHelp, Status: JobRunning, CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got request
+			cln := testServer(t, &got, func(w http.ResponseWriter) {
+				fmt.Fprint(w, tt.reply)
+			})
+			resp, err := tt.call(cln)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.method != tt.method || got.path != tt.path {
+				t.Errorf("request %s %s, want %s %s", got.method, got.path, tt.method, tt.path)
+			}
+			if got.auth != "Bearer key" || got.accept != "application/json" {
+				t.Errorf("Authorization %q, Accept %q", got.auth, got.accept)
+			}
+			if tt.body == "" {
+				if got.body != "" || got.content != "" {
+					t.Errorf("body %q with content type %q, want none", got.body, got.content)
+				}
+			} else if strings.TrimSpace(got.body) != tt.body || got.content != "application/json" {
+				t.Errorf("body %s with content type %q, want %s as JSON", got.body, got.content, tt.body)
+			}
+			if fmt.Sprintf("%+v", resp) != fmt.Sprintf("%+v", tt.want) {
+				t.Errorf("response %+v, want %+v", resp, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name  string
+		reply func(w http.ResponseWriter)
+		want  Error
+	}{
+		{"bad request", func(w http.ResponseWriter) {
+			http.Error(w, "invalid game", http.StatusBadRequest)
+		}, Error{StatusCode: http.StatusBadRequest, Message: "invalid game"}},
+		{"rate limited", func(w http.ResponseWriter) {
+			w.Header().Set("Retry-After", "7")
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		}, Error{StatusCode: http.StatusTooManyRequests, Message: "rate limit exceeded", RetryAfter: 7 * time.Second}},
+		{"not ready", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"status":"unavailable"}`)
+		}, Error{StatusCode: http.StatusServiceUnavailable, Message: `{"status":"unavailable"}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got request
+			_, err := testServer(t, &got, tt.reply).Ready(ctx)
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got %v, want an *Error", err)
+			}
+			if *apiErr != tt.want {
+				t.Errorf("got %+v, want %+v", *apiErr, tt.want)
+			}
+		})
+	}
+
+	// A 2xx response that isn't JSON is a decoding error, not an *Error.
+	var got request
+	_, err := testServer(t, &got, func(w http.ResponseWriter) {
+		fmt.Fprint(w, "<html>")
+	}).GameState(ctx, GameStateRequest{Game: "*"})
+	var apiErr *Error
+	if err == nil || errors.As(err, &apiErr) || !strings.Contains(err.Error(), "<html>") {
+		t.Errorf("got %v, want a decoding error quoting the body", err)
+	}
+}
+
+func TestClientGenHelpStream(t *testing.T) {
+	ctx := context.Background()
+	events := func(lines ...string) func(w http.ResponseWriter) {
+		return func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, line := range lines {
+				fmt.Fprint(w, line+"\n")
+			}
+		}
+	}
+
+	var got request
+	cln := testServer(t, &got, events(
+		": keep-alive",
+		"event: progress", `data: {"stage":"retrieving"}`, "",
+		"event: sources", `data: {"sources":[{"id":1,"chunk":"Knights before bishops."}]}`, "",
+		"event: token", `data: {"token":"Develop "}`, "",
+		"event: token", `data: {"token":"a knight."}`, "",
+		"event: done", `data: {"message":"Develop a knight.","reference_info":"Knights before bishops."}`, "",
+	))
+	var seen []string
+	resp, err := cln.GenHelpStream(ctx, GenHelpRequest{Game: "*"}, func(event string, ev HelpStreamEvent) error {
+		seen = append(seen, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.method != http.MethodPost || got.path != "/help/stream" || got.accept != "text/event-stream" || strings.TrimSpace(got.body) != `{"game":"*"}` {
+		t.Errorf("request %+v", got)
+	}
+	if want := "progress sources token token done"; strings.Join(seen, " ") != want {
+		t.Errorf("events %q, want %q", strings.Join(seen, " "), want)
+	}
+	if resp.Message != "Develop a knight." || resp.ReferenceInfo != "Knights before bishops." {
+		t.Errorf("response %+v", resp)
+	}
+
+	_, err = testServer(t, &got, events("event: error", `data: {"error":"llm unavailable"}`, "")).GenHelpStream(ctx, GenHelpRequest{Game: "*"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "llm unavailable") {
+		t.Errorf("error event: got %v", err)
+	}
+
+	_, err = testServer(t, &got, events("event: token", `data: {"token":"Dev"}`, "")).GenHelpStream(ctx, GenHelpRequest{Game: "*"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "ended") {
+		t.Errorf("cut-off stream: got %v", err)
+	}
+
+	stop := errors.New("stop")
+	_, err = testServer(t, &got, events("event: token", `data: {"token":"Dev"}`, "")).GenHelpStream(ctx, GenHelpRequest{Game: "*"}, func(string, HelpStreamEvent) error {
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("stopped stream: got %v, want %v", err, stop)
+	}
+}
+
+func TestClientWaitJob(t *testing.T) {
+	var polls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		status := JobRunning
+		if polls == 3 {
+			status = JobSucceeded
+		}
+		json.NewEncoder(w).Encode(JobResponse{ID: "j1", Status: status})
+	}))
+	t.Cleanup(srv.Close)
+
+	job, err := NewClient(srv.URL).WaitJob(context.Background(), "j1", time.Millisecond)
+	if err != nil || job.Status != JobSucceeded || polls != 3 {
+		t.Errorf("got %+v, %v after %d polls, want succeeded after 3", job, err, polls)
+	}
+}
+
+func TestClientConnectGame(t *testing.T) {
+	var upgrader websocket.Upgrader
+	var got request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = request{path: r.URL.EscapedPath(), query: r.URL.RawQuery, auth: r.Header.Get("Authorization")}
+		if r.URL.Path != "/games/g1/ws" {
+			http.NotFound(w, r)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var cmd GameCommand
+		if conn.ReadJSON(&cmd) == nil {
+			conn.WriteJSON(GameEvent{Type: "echo", Move: cmd.Move})
+		}
+	}))
+	t.Cleanup(srv.Close)
+	cln := NewClient(srv.URL, WithAPIKey("key"))
+	ctx := context.Background()
+
+	gc, err := cln.ConnectGame(ctx, "g1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gc.Close()
+	if got.query != "role=spectator" || got.auth != "Bearer key" {
+		t.Errorf("query %q, Authorization %q", got.query, got.auth)
+	}
+	if err := gc.Send(GameCommand{Type: "move", Move: "e2e4"}); err != nil {
+		t.Fatal(err)
+	}
+	if ev, err := gc.Next(); err != nil || ev.Type != "echo" || ev.Move != "e2e4" {
+		t.Errorf("Next = %+v, %v", ev, err)
+	}
+
+	_, err = cln.ConnectGame(ctx, "missing", false)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown game: got %v, want a 404 *Error", err)
+	}
+}
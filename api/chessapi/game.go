@@ -0,0 +1,81 @@
+package chessapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// CreateGame starts a game session.
+func (cln *Client) CreateGame(ctx context.Context, req CreateGameRequest) (GameSessionResponse, error) {
+	var resp GameSessionResponse
+	if err := cln.do(ctx, http.MethodPost, "/games", req, &resp); err != nil {
+		return GameSessionResponse{}, err
+	}
+	return resp, nil
+}
+
+// GetGame returns a game session.
+func (cln *Client) GetGame(ctx context.Context, id string) (GameSessionResponse, error) {
+	var resp GameSessionResponse
+	if err := cln.do(ctx, http.MethodGet, "/games/"+url.PathEscape(id), nil, &resp); err != nil {
+		return GameSessionResponse{}, err
+	}
+	return resp, nil
+}
+
+// GameConn is a WebSocket connection to a game session.
+type GameConn struct {
+	conn *websocket.Conn
+}
+
+// ConnectGame opens the WebSocket of a game session. Spectators only
+// receive events.
+func (cln *Client) ConnectGame(ctx context.Context, id string, spectator bool) (*GameConn, error) {
+	u, err := url.Parse(cln.baseURL + "/games/" + url.PathEscape(id) + "/ws")
+	if err != nil {
+		return nil, fmt.Errorf("chessapi: %w", err)
+	}
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	if spectator {
+		u.RawQuery = "role=spectator"
+	}
+
+	header := http.Header{}
+	if cln.apiKey != "" {
+		header.Set("Authorization", "Bearer "+cln.apiKey)
+	}
+
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			return nil, &Error{StatusCode: resp.StatusCode, Message: resp.Status}
+		}
+		return nil, fmt.Errorf("chessapi: %w", err)
+	}
+
+	return &GameConn{conn: conn}, nil
+}
+
+// Next blocks until the next event of the session.
+func (gc *GameConn) Next() (GameEvent, error) {
+	var ev GameEvent
+	if err := gc.conn.ReadJSON(&ev); err != nil {
+		return GameEvent{}, err
+	}
+	return ev, nil
+}
+
+// Send sends a command to the session.
+func (gc *GameConn) Send(cmd GameCommand) error {
+	return gc.conn.WriteJSON(cmd)
+}
+
+// Close closes the connection.
+func (gc *GameConn) Close() error {
+	return gc.conn.Close()
+}
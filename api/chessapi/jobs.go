@@ -0,0 +1,49 @@
+package chessapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// CreateJob queues a long-running task.
+func (cln *Client) CreateJob(ctx context.Context, req CreateJobRequest) (JobResponse, error) {
+	var resp JobResponse
+	if err := cln.do(ctx, http.MethodPost, "/jobs", req, &resp); err != nil {
+		return JobResponse{}, err
+	}
+	return resp, nil
+}
+
+// GetJob returns the status, and once finished the result, of a job.
+func (cln *Client) GetJob(ctx context.Context, id string) (JobResponse, error) {
+	var resp JobResponse
+	if err := cln.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, &resp); err != nil {
+		return JobResponse{}, err
+	}
+	return resp, nil
+}
+
+// WaitJob polls a job every interval until it has finished or ctx is
+// done.
+func (cln *Client) WaitJob(ctx context.Context, id string, interval time.Duration) (JobResponse, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		job, err := cln.GetJob(ctx, id)
+		if err != nil {
+			return JobResponse{}, err
+		}
+		if job.Status == JobSucceeded || job.Status == JobFailed {
+			return job, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
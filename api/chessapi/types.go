@@ -0,0 +1,478 @@
+// Package chessapi holds the request and response types of the chess
+// API along with a typed Go client for it. The API server uses these
+// same types, so the client and the server cannot drift apart.
+package chessapi
+
+import "time"
+
+// ParseMoveRequest asks the API to apply a natural language move.
+// Coach asks for the move to be classified against the engine's best,
+// which takes two engine searches and, for poor moves, an LLM call
+// counted against the caller's quota. GameID names the game the move's
+// quality is stored under; leave it empty for the first move and send
+// back the one the API returns.
+type ParseMoveRequest struct {
+	Game   string `json:"game"`
+	Move   string `json:"move"`
+	Coach  bool   `json:"coach,omitempty"`
+	GameID string `json:"game_id,omitempty"`
+}
+
+// ParseMoveResponse holds the parsed move, the updated game and the
+// opening it follows, if any. With coaching, Quality classifies the
+// move just played and, for authenticated callers, Classifications
+// holds those of all the game's moves so far, in order. Both are left
+// out if the engine could not classify the move.
+type ParseMoveResponse struct {
+	Move            string        `json:"move"`
+	GameID          string        `json:"game_id"`
+	GameOriginal    string        `json:"game_original"`
+	GameUpdated     string        `json:"game_updated"`
+	Opening         *Opening      `json:"opening,omitempty"`
+	Quality         *MoveQuality  `json:"quality,omitempty"`
+	Classifications []MoveQuality `json:"classifications,omitempty"`
+}
+
+// Move quality classifications, from the best to the worst.
+const (
+	MoveQualityBest       = "best"
+	MoveQualityGood       = "good"
+	MoveQualityInaccuracy = "inaccuracy"
+	MoveQualityMistake    = "mistake"
+	MoveQualityBlunder    = "blunder"
+)
+
+// MoveQuality classifies a move by how much it loses compared with the
+// engine's best move. Ply counts the game's moves from 1, Loss is in
+// centipawns and Explanation, for inaccuracies and worse, says in a
+// sentence what the move missed.
+type MoveQuality struct {
+	Ply            int    `json:"ply"`
+	Move           string `json:"move"`
+	Classification string `json:"classification"`
+	BestMove       string `json:"best_move"`
+	Loss           int    `json:"loss"`
+	Explanation    string `json:"explanation,omitempty"`
+}
+
+// Opening is the latest named opening a game reached, by any move order:
+// its code in the Encyclopaedia of Chess Openings, e.g. "C50", its name
+// and the number of plies of its line.
+type Opening struct {
+	ECO   string `json:"eco"`
+	Name  string `json:"name"`
+	Plies int    `json:"plies"`
+}
+
+// MakeMoveRequest asks the API to play the next black move. Difficulty
+// is one of the Difficulty constants and defaults to the server's own
+// setting.
+type MakeMoveRequest struct {
+	Game       string `json:"game"`
+	Difficulty string `json:"difficulty,omitempty"`
+}
+
+// Difficulty levels of the moves made by the API. The harder the level,
+// the less an LLM move may lose compared with the engine's best move.
+const (
+	// DifficultyEasy plays whatever legal move the LLM comes up with.
+	DifficultyEasy = "easy"
+
+	// DifficultyMedium rejects moves that lose a piece or more.
+	DifficultyMedium = "medium"
+
+	// DifficultyHard rejects moves that lose about a pawn or more.
+	DifficultyHard = "hard"
+)
+
+// MakeMoveResponse holds the generated move, where it came from, the
+// updated game and the opening it follows, if any.
+type MakeMoveResponse struct {
+	Move         string   `json:"move"`
+	Source       string   `json:"source"`
+	GameOriginal string   `json:"game_original"`
+	GameUpdated  string   `json:"game_updated"`
+	Opening      *Opening `json:"opening,omitempty"`
+}
+
+// Sources of a move made by the API.
+const (
+	// MoveSourceLLM is a move generated by the LLM.
+	MoveSourceLLM = "llm"
+
+	// MoveSourceEngine is a move from the built-in engine, played when
+	// the LLM could not come up with a legal move.
+	MoveSourceEngine = "engine"
+
+	// MoveSourceBook is a move from the opening book, played instead of
+	// asking the LLM early in a game.
+	MoveSourceBook = "book"
+
+	// MoveSourceTablebase is a move from the endgame tablebase, played
+	// instead of asking the LLM once few enough pieces are left.
+	MoveSourceTablebase = "tablebase"
+)
+
+// GenHelpRequest asks the API for advice on the current game.
+type GenHelpRequest struct {
+	Game string `json:"game"`
+}
+
+// GenHelpResponse holds the advice and the reference text it used.
+type GenHelpResponse struct {
+	Message       string     `json:"message"`
+	ReferenceInfo string     `json:"reference_info"`
+	Tactics       []Tactic   `json:"tactics"`
+	Tablebase     *Tablebase `json:"tablebase,omitempty"`
+}
+
+// Stages of the help pipeline reported by /help/stream.
+const (
+	HelpStageRender   = "render_board"
+	HelpStageAnalyze  = "analyze_position"
+	HelpStageDescribe = "describe_game"
+	HelpStageRetrieve = "retrieve"
+	HelpStageAnswer   = "answer"
+)
+
+// Names of the server-sent events written by /help/stream. A stream is
+// progress events, one sources event, token events and then done, or
+// error at any point.
+const (
+	HelpEventProgress = "progress"
+	HelpEventSources  = "sources"
+	HelpEventToken    = "token"
+	HelpEventDone     = "done"
+	HelpEventError    = "error"
+)
+
+// HelpSource is a piece of reference text retrieved for a help request.
+type HelpSource struct {
+	ID       int     `json:"id"`
+	Chunk    string  `json:"chunk"`
+	Distance float64 `json:"distance"`
+}
+
+// HelpStreamEvent is the data of a /help/stream event. Which fields are
+// set depends on the event name.
+type HelpStreamEvent struct {
+	Stage         string       `json:"stage,omitempty"`
+	Sources       []HelpSource `json:"sources,omitempty"`
+	Token         string       `json:"token,omitempty"`
+	Message       string       `json:"message,omitempty"`
+	ReferenceInfo string       `json:"reference_info,omitempty"`
+	Tactics       []Tactic     `json:"tactics,omitempty"`
+	Tablebase     *Tablebase   `json:"tablebase,omitempty"`
+	Error         string       `json:"error,omitempty"`
+}
+
+// CheckResult is the outcome of a single readiness check.
+type CheckResult struct {
+	Status  string `json:"status"`
+	Detail  string `json:"detail,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Latency string `json:"latency"`
+}
+
+// HealthResponse is returned by the liveness and readiness probes.
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// UsageResponse reports a caller's metered usage for today.
+type UsageResponse struct {
+	Subject   string         `json:"subject"`
+	Day       string         `json:"day"`
+	Quota     int            `json:"quota"`
+	Used      int            `json:"used"`
+	Remaining int            `json:"remaining"`
+	ByRoute   map[string]int `json:"by_route"`
+}
+
+// GameStateRequest asks for the state of a game without calling the LLM.
+type GameStateRequest struct {
+	Game string `json:"game"`
+}
+
+// GameStateResponse describes the current position of a game. Moves
+// are in algebraic notation; LegalMoves and LastMove are in UCI
+// notation (e.g. e2e4, e7e8q).
+type GameStateResponse struct {
+	FEN        string   `json:"fen"`
+	Turn       string   `json:"turn"`
+	Outcome    string   `json:"outcome"`
+	Method     string   `json:"method,omitempty"`
+	Check      bool     `json:"check"`
+	Moves      []string `json:"moves"`
+	LegalMoves []string `json:"legal_moves"`
+	LastMove   string   `json:"last_move,omitempty"`
+	Opening    *Opening `json:"opening,omitempty"`
+}
+
+// EvaluateRequest asks for an analysis of the current position of a game.
+type EvaluateRequest struct {
+	Game string `json:"game"`
+}
+
+// EvaluateResponse analyses the current position of a game. Score is
+// the engine's evaluation in centipawns from white's point of view; Mate,
+// if set, is the number of moves to a forced mate, negative when black
+// mates. BestLine is in algebraic notation. Facts sum up the analysis in
+// sentences, as given to the LLM.
+type EvaluateResponse struct {
+	FEN       string         `json:"fen"`
+	Turn      string         `json:"turn"`
+	Opening   *Opening       `json:"opening,omitempty"`
+	Score     int            `json:"score"`
+	Mate      int            `json:"mate,omitempty"`
+	BestMove  string         `json:"best_move,omitempty"`
+	BestLine  []string       `json:"best_line,omitempty"`
+	White     SideEvaluation `json:"white"`
+	Black     SideEvaluation `json:"black"`
+	Tactics   []Tactic       `json:"tactics"`
+	Tablebase *Tablebase     `json:"tablebase,omitempty"`
+	Facts     []string       `json:"facts"`
+}
+
+// Tablebase is the theoretical result of an endgame position, from an
+// endgame tablebase. Result is for the side to move: win, cursed_win,
+// draw, blessed_loss or loss, where cursed wins and blessed losses are
+// drawn by the 50-move rule. DTZ counts the plies to the next capture
+// or pawn move with best play, positive when winning, and BestMove is
+// the move to keep the result. They are only set when the tablebase has
+// distance tables for the position.
+type Tablebase struct {
+	Result   string `json:"result"`
+	DTZ      int    `json:"dtz,omitempty"`
+	BestMove string `json:"best_move,omitempty"`
+}
+
+// SideEvaluation describes one side of a position. Material is in
+// centipawns and Mobility counts the moves of the side's pieces, pawns
+// and king aside.
+type SideEvaluation struct {
+	Material int            `json:"material"`
+	Mobility int            `json:"mobility"`
+	King     KingSafety     `json:"king"`
+	Pawns    PawnStructure  `json:"pawns"`
+	Hanging  []PieceOnBoard `json:"hanging"`
+}
+
+// KingSafety describes the shelter of a king: the pawns in front of it,
+// the files next to it without pawns of its side and the squares around
+// it attacked by the opponent.
+type KingSafety struct {
+	Square      string `json:"square"`
+	InCheck     bool   `json:"in_check"`
+	Shield      int    `json:"shield"`
+	OpenFiles   int    `json:"open_files"`
+	ZoneAttacks int    `json:"zone_attacks"`
+}
+
+// PawnStructure lists the squares of a side's isolated, doubled and
+// passed pawns.
+type PawnStructure struct {
+	Isolated []string `json:"isolated"`
+	Doubled  []string `json:"doubled"`
+	Passed   []string `json:"passed"`
+}
+
+// PieceOnBoard is a piece, e.g. a white knight, on a square.
+type PieceOnBoard struct {
+	Color  string `json:"color"`
+	Piece  string `json:"piece"`
+	Square string `json:"square"`
+}
+
+// Tactic is a tactical motif in favour of Side: "fork", "pin", "skewer"
+// or "discovered_attack". Piece attacks Targets, except in a discovered
+// attack, where moving Piece lets the first target attack the second.
+// Move is set for a motif Side can play next, rather than one on the
+// board already.
+type Tactic struct {
+	Motif       string         `json:"motif"`
+	Side        string         `json:"side"`
+	Piece       PieceOnBoard   `json:"piece"`
+	Targets     []PieceOnBoard `json:"targets"`
+	Move        string         `json:"move,omitempty"`
+	Description string         `json:"description"`
+}
+
+// HintRequest asks for a nudge towards a good move in the current game.
+// Level is how much to give away: HintLevelPiece, the default, points
+// at the piece to move, HintLevelSquare at the square it goes to and
+// HintLevelMove gives the whole move.
+type HintRequest struct {
+	Game  string `json:"game"`
+	Level string `json:"level,omitempty"`
+}
+
+// Hint levels, from the least given away to the most.
+const (
+	HintLevelPiece  = "piece"
+	HintLevelSquare = "square"
+	HintLevelMove   = "move"
+)
+
+// HintResponse is a hint for the side to move, holding no more than its
+// level gives away: Piece at HintLevelPiece, Square at HintLevelSquare
+// and all three at HintLevelMove. Rationale is a sentence on why to look
+// there. Image is an SVG of the board from the side to move's view,
+// marking the piece or square, or with an arrow for the move.
+type HintResponse struct {
+	Level     string        `json:"level"`
+	Piece     *PieceOnBoard `json:"piece,omitempty"`
+	Square    string        `json:"square,omitempty"`
+	Move      string        `json:"move,omitempty"`
+	Rationale string        `json:"rationale"`
+	Image     string        `json:"image"`
+}
+
+// Game session modes.
+const (
+	ModeAI    = "ai"
+	ModeHuman = "human"
+)
+
+// CreateGameRequest starts a game session, optionally from a PGN. Mode
+// is ModeAI (the default) to play white against the LLM or ModeHuman
+// for two players. Commentary has the LLM comment on every move.
+type CreateGameRequest struct {
+	Game       string `json:"game,omitempty"`
+	Mode       string `json:"mode,omitempty"`
+	Commentary bool   `json:"commentary,omitempty"`
+}
+
+// GameSessionResponse describes a game session. Seats lists the colors
+// that have a connected player.
+type GameSessionResponse struct {
+	ID         string            `json:"id"`
+	Mode       string            `json:"mode"`
+	Commentary bool              `json:"commentary"`
+	Game       string            `json:"game"`
+	State      GameStateResponse `json:"state"`
+	Seats      []string          `json:"seats"`
+	Watchers   int               `json:"watchers"`
+}
+
+// Types of GameEvent pushed to WebSocket clients of a game session.
+// Commentary streams as commentary_delta events, each holding the next
+// piece of Message, followed by a commentary event with the full text.
+const (
+	EventState           = "state"
+	EventUserMove        = "user_move"
+	EventAIThinking      = "ai_thinking"
+	EventAIMove          = "ai_move"
+	EventCheck           = "check"
+	EventCheckmate       = "checkmate"
+	EventGameOver        = "game_over"
+	EventCoaching        = "coaching"
+	EventCommentary      = "commentary"
+	EventCommentaryDelta = "commentary_delta"
+	EventError           = "error"
+)
+
+// GameEvent is pushed to the WebSocket clients of a game session.
+// Color is the side that made Move and, for ai_move events, Source is
+// where the move came from.
+type GameEvent struct {
+	Type          string             `json:"type"`
+	Move          string             `json:"move,omitempty"`
+	Color         string             `json:"color,omitempty"`
+	Source        string             `json:"source,omitempty"`
+	Game          string             `json:"game,omitempty"`
+	State         *GameStateResponse `json:"state,omitempty"`
+	Message       string             `json:"message,omitempty"`
+	ReferenceInfo string             `json:"reference_info,omitempty"`
+	Error         string             `json:"error,omitempty"`
+}
+
+// Types of GameCommand sent by WebSocket clients.
+const (
+	CommandMove = "move"
+	CommandHelp = "help"
+)
+
+// GameCommand is sent by a player over a game session's WebSocket.
+// Move is natural language or UCI notation.
+type GameCommand struct {
+	Type string `json:"type"`
+	Move string `json:"move,omitempty"`
+}
+
+// Tasks a job can run.
+const (
+	JobTaskHelp         = "help"
+	JobTaskAnnotateGame = "annotate-game"
+	JobTaskBatchAnalyze = "batch-analyze"
+)
+
+// Job statuses. Queued and running jobs are still in progress.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// CreateJobRequest queues a long-running task. The help and
+// annotate-game tasks take Game; batch-analyze takes Games.
+type CreateJobRequest struct {
+	Task  string   `json:"task"`
+	Game  string   `json:"game,omitempty"`
+	Games []string `json:"games,omitempty"`
+}
+
+// MoveAnnotation is the commentary on one move of an annotated game.
+type MoveAnnotation struct {
+	Ply     int    `json:"ply"`
+	Move    string `json:"move"`
+	Color   string `json:"color"`
+	Comment string `json:"comment"`
+}
+
+// AnnotateGameResult is the result of an annotate-game job. Game is the
+// movetext with each comment in braces after its move.
+type AnnotateGameResult struct {
+	Game  string           `json:"game"`
+	Moves []MoveAnnotation `json:"moves"`
+}
+
+// GameAnalysis is the analysis of one game of a batch. Error is set
+// instead of Description if the game could not be analyzed.
+type GameAnalysis struct {
+	Index       int      `json:"index"`
+	Outcome     string   `json:"outcome"`
+	Method      string   `json:"method,omitempty"`
+	Plies       int      `json:"plies"`
+	Opening     *Opening `json:"opening,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// BatchAnalyzeResult is the result of a batch-analyze job, in the order
+// the games were given.
+type BatchAnalyzeResult struct {
+	Games []GameAnalysis `json:"games"`
+}
+
+// JobResult holds the result of a succeeded job under its task.
+type JobResult struct {
+	Help         *GenHelpResponse    `json:"help,omitempty"`
+	AnnotateGame *AnnotateGameResult `json:"annotate_game,omitempty"`
+	BatchAnalyze *BatchAnalyzeResult `json:"batch_analyze,omitempty"`
+}
+
+// JobResponse describes a job. Result is set once it has succeeded and
+// Error once it has failed.
+type JobResponse struct {
+	ID         string     `json:"id"`
+	Task       string     `json:"task"`
+	Status     string     `json:"status"`
+	Result     *JobResult `json:"result,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
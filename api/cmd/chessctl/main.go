@@ -0,0 +1,173 @@
+// Command chessctl plays and tests the chess API from the terminal.
+//
+// Usage:
+//
+//	chessctl [flags] play [-pgn file] [-save file] [-difficulty level] [-coach]
+//	chessctl [flags] help -pgn file
+//	chessctl [flags] eval -pgn file
+//	chessctl [flags] hint -pgn file [-level level] [-svg file]
+//	chessctl [flags] script file...
+//
+// The API URL and key default to the CHESS_API_URL and CHESS_API_KEY
+// env vars.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/termchess"
+)
+
+func main() {
+	url := flag.String("url", envOr("CHESS_API_URL", "http://localhost:8080"), "base URL of the chess API")
+	key := flag.String("key", os.Getenv("CHESS_API_KEY"), "API key or JWT for the chess API")
+	plain := flag.Bool("plain", false, "draw the board without ANSI colors or Unicode pieces")
+	timeout := flag.Duration("timeout", 3*time.Minute, "timeout for each API call")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cln := chessapi.NewClient(*url, chessapi.WithAPIKey(*key))
+	opts := termchess.Options{ANSI: !*plain, Letters: *plain}
+
+	var err error
+	switch cmd, args := flag.Arg(0), flag.Args()[1:]; cmd {
+	case "play":
+		err = runPlay(cln, opts, *timeout, args)
+	case "help":
+		err = runHelp(cln, *timeout, args)
+	case "eval":
+		err = runEval(cln, *timeout, args)
+	case "hint":
+		err = runHint(cln, *timeout, args)
+	case "script":
+		err = runScripts(cln, opts, *timeout, args)
+	default:
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "chessctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage:
+  chessctl [flags] play [-pgn file] [-save file] [-difficulty level] [-coach]
+                                                   play white against the LLM
+  chessctl [flags] help -pgn file                  ask for advice on a game
+  chessctl [flags] eval -pgn file                  analyse a game's position
+  chessctl [flags] hint -pgn file [-level level] [-svg file]
+                                                   hint at the best move
+  chessctl [flags] script file...                  run scripted sessions
+
+Flags:`)
+	flag.PrintDefaults()
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// runHelp prints advice for the game in a PGN file.
+func runHelp(cln *chessapi.Client, timeout time.Duration, args []string) error {
+	fs := flag.NewFlagSet("help", flag.ExitOnError)
+	pgnFile := fs.String("pgn", "", "PGN file with the game")
+	fs.Parse(args)
+
+	game, err := loadPGN(*pgnFile)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := cln.GenHelp(ctx, chessapi.GenHelpRequest{Game: termchess.Movetext(game)})
+	if err != nil {
+		return err
+	}
+	fmt.Println(resp.Message)
+	if tb := resp.Tablebase; tb != nil {
+		fmt.Printf("\nTablebase: %s", tb.Result)
+		if tb.BestMove != "" {
+			fmt.Printf(", best move %s (DTZ %d)", tb.BestMove, tb.DTZ)
+		}
+		fmt.Println()
+	}
+	if len(resp.Tactics) > 0 {
+		fmt.Println("\nTactics:")
+		for _, t := range resp.Tactics {
+			fmt.Println("-", t.Description)
+		}
+	}
+	return nil
+}
+
+// runEval prints the analysis of the position in a PGN file.
+func runEval(cln *chessapi.Client, timeout time.Duration, args []string) error {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	pgnFile := fs.String("pgn", "", "PGN file with the game")
+	fs.Parse(args)
+
+	game, err := loadPGN(*pgnFile)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := cln.Evaluate(ctx, chessapi.EvaluateRequest{Game: termchess.Movetext(game)})
+	if err != nil {
+		return err
+	}
+	if resp.BestMove != "" {
+		fmt.Printf("Best line: %s\n", strings.Join(resp.BestLine, " "))
+	}
+	for _, fact := range resp.Facts {
+		fmt.Println("-", fact)
+	}
+	return nil
+}
+
+// runHint prints a hint for the side to move in a PGN file, saving the
+// board image if asked to.
+func runHint(cln *chessapi.Client, timeout time.Duration, args []string) error {
+	fs := flag.NewFlagSet("hint", flag.ExitOnError)
+	pgnFile := fs.String("pgn", "", "PGN file with the game")
+	level := fs.String("level", chessapi.HintLevelPiece, "how much to give away: piece, square or move")
+	svgFile := fs.String("svg", "", "file to save the board image to")
+	fs.Parse(args)
+
+	game, err := loadPGN(*pgnFile)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := cln.Hint(ctx, chessapi.HintRequest{Game: termchess.Movetext(game), Level: *level})
+	if err != nil {
+		return err
+	}
+	fmt.Println(resp.Rationale)
+	if *svgFile != "" {
+		return os.WriteFile(*svgFile, []byte(resp.Image), 0o644)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/termchess"
+	"github.com/notnil/chess"
+)
+
+// session is a game in progress against the API.
+type session struct {
+	cln        *chessapi.Client
+	timeout    time.Duration
+	difficulty string
+	coach      bool
+	game       *chess.Game
+	gameID     string
+}
+
+// parse applies a natural language move for white.
+func (s *session) parse(text string) (chessapi.ParseMoveResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	resp, err := s.cln.ParseMove(ctx, chessapi.ParseMoveRequest{
+		Game:   termchess.Movetext(s.game),
+		Move:   text,
+		Coach:  s.coach,
+		GameID: s.gameID,
+	})
+	if err != nil {
+		return chessapi.ParseMoveResponse{}, err
+	}
+	s.gameID = resp.GameID
+	return resp, s.update(resp.GameUpdated)
+}
+
+// ai asks the LLM for the next black move.
+func (s *session) ai() (chessapi.MakeMoveResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	resp, err := s.cln.MakeMove(ctx, chessapi.MakeMoveRequest{
+		Game:       termchess.Movetext(s.game),
+		Difficulty: s.difficulty,
+	})
+	if err != nil {
+		return chessapi.MakeMoveResponse{}, err
+	}
+	return resp, s.update(resp.GameUpdated)
+}
+
+// help asks for advice on the current game.
+func (s *session) help() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	resp, err := s.cln.GenHelp(ctx, chessapi.GenHelpRequest{Game: termchess.Movetext(s.game)})
+	if err != nil {
+		return "", err
+	}
+	return resp.Message, nil
+}
+
+// update replaces the game with the one returned by the API.
+func (s *session) update(pgn string) error {
+	game, err := termchess.ParseGame(pgn)
+	if err != nil {
+		return fmt.Errorf("parsing game from API: %w", err)
+	}
+	s.game = game
+	return nil
+}
+
+// save writes the game to a PGN file.
+func (s *session) save(path string) error {
+	return os.WriteFile(path, []byte(strings.TrimSpace(s.game.String())+"\n"), 0o644)
+}
+
+// loadPGN reads a game from a PGN file, or starts a new one if path
+// is empty.
+func loadPGN(path string) (*chess.Game, error) {
+	if path == "" {
+		return chess.NewGame(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return termchess.ParseGame(string(data))
+}
+
+const playHelp = `Type a move in plain English ("pawn to e4", "knight takes c6") or:
+  help          ask the coach for advice
+  save <file>   save the game as PGN
+  pgn           print the game as PGN
+  quit          leave the game`
+
+// runPlay plays an interactive game as white.
+func runPlay(cln *chessapi.Client, opts termchess.Options, timeout time.Duration, args []string) error {
+	fs := flag.NewFlagSet("play", flag.ExitOnError)
+	pgnFile := fs.String("pgn", "", "PGN file to resume a game from")
+	saveFile := fs.String("save", "", "PGN file to save the game to after every move")
+	difficulty := fs.String("difficulty", "", "how strongly LLaMA 3 plays: easy, medium or hard (default: the server's setting)")
+	coach := fs.Bool("coach", false, "rate each move against the engine's best")
+	fs.Parse(args)
+
+	game, err := loadPGN(*pgnFile)
+	if err != nil {
+		return err
+	}
+	s := session{cln: cln, timeout: timeout, difficulty: *difficulty, coach: *coach, game: game}
+
+	fmt.Println(playHelp)
+	in := bufio.NewScanner(os.Stdin)
+	for {
+		opts.Highlight = termchess.LastMoveSquares(s.game)
+		fmt.Println()
+		fmt.Print(termchess.Draw(s.game.Position().Board(), opts))
+		fmt.Println(termchess.Status(s.game))
+		if s.game.Outcome() != chess.NoOutcome {
+			return nil
+		}
+
+		// Let the LLM move if it's black's turn, e.g. after loading a game.
+		if s.game.Position().Turn() == chess.Black {
+			fmt.Println("LLaMA 3 is thinking...")
+			resp, err := s.ai()
+			if err != nil {
+				return fmt.Errorf("LLaMA 3 got stumped and gave up: %w", err)
+			}
+			fmt.Println(termchess.Mover(resp.Source), "played", resp.Move)
+			if err := autosave(&s, *saveFile); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fmt.Print("> ")
+		if !in.Scan() {
+			if err := in.Err(); err != nil && !errors.Is(err, io.EOF) {
+				return err
+			}
+			return nil
+		}
+		line := strings.TrimSpace(in.Text())
+		cmd, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToLower(cmd) {
+		case "":
+			continue
+		case "quit", "exit":
+			return autosave(&s, *saveFile)
+		case "pgn":
+			fmt.Println(termchess.Movetext(s.game))
+		case "save":
+			if arg == "" {
+				arg = *saveFile
+			}
+			if err := s.save(arg); err != nil {
+				fmt.Println("Could not save:", err)
+				continue
+			}
+			fmt.Println("Saved to", arg)
+		case "help":
+			fmt.Println("Thinking about your position...")
+			advice, err := s.help()
+			if err != nil {
+				fmt.Println("Could not get help:", err)
+				continue
+			}
+			fmt.Println(advice)
+		default:
+			resp, err := s.parse(line)
+			if err != nil {
+				fmt.Println("Could not make that move:", err)
+				continue
+			}
+			fmt.Println("You played", resp.Move)
+			if q := resp.Quality; q != nil {
+				printQuality(*q)
+			}
+			if err := autosave(&s, *saveFile); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// printQuality prints the coach's classification of a move.
+func printQuality(q chessapi.MoveQuality) {
+	switch q.Classification {
+	case chessapi.MoveQualityBest:
+		fmt.Printf("Coach: %s is the best move.\n", q.Move)
+	case chessapi.MoveQualityGood:
+		fmt.Printf("Coach: %s is good, %s was a little better.\n", q.Move, q.BestMove)
+	default:
+		fmt.Printf("Coach: %s is %s %s, %s was better.", q.Move, article(q.Classification), q.Classification, q.BestMove)
+		if q.Explanation != "" {
+			fmt.Print(" ", q.Explanation)
+		}
+		fmt.Println()
+	}
+}
+
+// article returns the indefinite article for word.
+func article(word string) string {
+	if strings.ContainsRune("aeiou", rune(word[0])) {
+		return "an"
+	}
+	return "a"
+}
+
+// autosave saves the game if a save file is configured.
+func autosave(s *session, path string) error {
+	if path == "" {
+		return nil
+	}
+	return s.save(path)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/termchess"
+	"github.com/notnil/chess"
+)
+
+// A script is a text file with one command per line, for regression
+// testing the API. Blank lines and lines starting with # are ignored.
+//
+//	load <file>              start from the game in a PGN file
+//	new                      start a new game
+//	move <text>              apply a natural language move for white
+//	ai                       let the LLM play black
+//	help                     print advice for the current game
+//	expect-move <san>        the last move played was <san>
+//	expect-error move <text> the move is rejected by the API
+//	expect-fen <fen>         the position matches <fen>
+//	expect-outcome <result>  the result is *, 1-0, 0-1 or 1/2-1/2
+//	save <file>              save the game as PGN
+//	print                    draw the board
+
+// runScripts runs every script and fails if any of them failed.
+func runScripts(cln *chessapi.Client, opts termchess.Options, timeout time.Duration, paths []string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("script: no script files given")
+	}
+
+	var failed int
+	for _, path := range paths {
+		start := time.Now()
+		if err := runScript(cln, opts, timeout, path); err != nil {
+			fmt.Printf("FAIL\t%s\t%v\n\t%v\n", path, time.Since(start).Round(time.Millisecond), err)
+			failed++
+			continue
+		}
+		fmt.Printf("ok\t%s\t%v\n", path, time.Since(start).Round(time.Millisecond))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d scripts failed", failed, len(paths))
+	}
+	return nil
+}
+
+// runScript runs a single script, stopping at the first failure.
+func runScript(cln *chessapi.Client, opts termchess.Options, timeout time.Duration, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := session{cln: cln, timeout: timeout, game: chess.NewGame()}
+
+	sc := bufio.NewScanner(f)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+
+		if err := runScriptLine(&s, opts, cmd, arg); err != nil {
+			return fmt.Errorf("line %d: %s: %w", lineNo, line, err)
+		}
+	}
+	return sc.Err()
+}
+
+// runScriptLine runs one script command.
+func runScriptLine(s *session, opts termchess.Options, cmd, arg string) error {
+	switch cmd {
+	case "new":
+		s.game, s.gameID = chess.NewGame(), ""
+
+	case "load":
+		game, err := loadPGN(arg)
+		if err != nil {
+			return err
+		}
+		s.game, s.gameID = game, ""
+
+	case "move":
+		if _, err := s.parse(arg); err != nil {
+			return err
+		}
+
+	case "ai":
+		if _, err := s.ai(); err != nil {
+			return err
+		}
+
+	case "help":
+		advice, err := s.help()
+		if err != nil {
+			return err
+		}
+		fmt.Println(advice)
+
+	case "expect-error":
+		verb, text, _ := strings.Cut(arg, " ")
+		if verb != "move" {
+			return fmt.Errorf("expect-error only supports move")
+		}
+		before := termchess.Movetext(s.game)
+		if _, err := s.parse(text); err == nil {
+			return fmt.Errorf("expected %q to be rejected, game is now %s", text, termchess.Movetext(s.game))
+		}
+		if after := termchess.Movetext(s.game); after != before {
+			return fmt.Errorf("game changed after rejected move: %s", after)
+		}
+
+	case "expect-move":
+		moves := s.game.Moves()
+		if len(moves) == 0 {
+			return fmt.Errorf("no moves played")
+		}
+		last := chess.AlgebraicNotation{}.Encode(s.game.Positions()[len(moves)-1], moves[len(moves)-1])
+		if last != arg {
+			return fmt.Errorf("last move is %s, want %s", last, arg)
+		}
+
+	case "expect-fen":
+		if got := s.game.FEN(); got != arg {
+			return fmt.Errorf("position is %s, want %s", got, arg)
+		}
+
+	case "expect-outcome":
+		if got := string(s.game.Outcome()); got != arg {
+			return fmt.Errorf("outcome is %s, want %s", got, arg)
+		}
+
+	case "save":
+		return s.save(arg)
+
+	case "print":
+		opts.Highlight = termchess.LastMoveSquares(s.game)
+		fmt.Print(termchess.Draw(s.game.Position().Board(), opts))
+
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	return nil
+}
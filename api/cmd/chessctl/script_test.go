@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/termchess"
+	"github.com/notnil/chess"
+)
+
+// fakeAPI serves /parse, understanding the moves in parsed, and /move,
+// playing the first of replies that is legal.
+func fakeAPI(t *testing.T, parsed map[string]string, replies []string) *chessapi.Client {
+	t.Helper()
+	play := func(w http.ResponseWriter, pgn string, moves ...string) (*chess.Game, string, bool) {
+		game, err := termchess.ParseGame(pgn)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, "", false
+		}
+		for _, m := range moves {
+			if game.MoveStr(m) == nil {
+				return game, m, true
+			}
+		}
+		http.Error(w, "no legal move", http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/parse":
+			var req chessapi.ParseMoveRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			game, move, ok := play(w, req.Game, parsed[req.Move])
+			if !ok {
+				return
+			}
+			json.NewEncoder(w).Encode(chessapi.ParseMoveResponse{Move: move, GameOriginal: req.Game, GameUpdated: termchess.Movetext(game)})
+		case "/move":
+			var req chessapi.MakeMoveRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			game, move, ok := play(w, req.Game, replies...)
+			if !ok {
+				return
+			}
+			json.NewEncoder(w).Encode(chessapi.MakeMoveResponse{Move: move, Source: chessapi.MoveSourceLLM, GameOriginal: req.Game, GameUpdated: termchess.Movetext(game)})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return chessapi.NewClient(srv.URL)
+}
+
+func TestRunScript(t *testing.T) {
+	cln := fakeAPI(t,
+		map[string]string{"pawn to e4": "e4", "knight to f3": "Nf3", "queen to h8": "Qh8"},
+		[]string{"e5", "Nc6"},
+	)
+	opts := termchess.Options{Letters: true}
+
+	if err := runScript(cln, opts, time.Minute, "testdata/opening.txt"); err != nil {
+		t.Errorf("testdata/opening.txt: %v", err)
+	}
+
+	// Failed expectations name the line.
+	dir := t.TempDir()
+	for name, tt := range map[string]struct {
+		script string
+		want   string
+	}{
+		"wrong move":       {"new\nmove pawn to e4\nexpect-move d4\n", "line 3: expect-move d4: last move is e4, want d4"},
+		"accepted move":    {"# a comment\n\nexpect-error move pawn to e4\n", "line 3"},
+		"wrong outcome":    {"expect-outcome 1-0\n", "outcome is *, want 1-0"},
+		"unknown command":  {"castle\n", `unknown command "castle"`},
+		"rejected by API":  {"move queen to h8\n", "status 400"},
+		"wrong fen":        {"expect-fen 8/8/8/8/8/8/8/8 w - - 0 1\n", "line 1"},
+		"expect-error use": {"expect-error ai\n", "only supports move"},
+	} {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "-")+".txt")
+		if err := os.WriteFile(path, []byte(tt.script), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		err := runScript(cln, opts, time.Minute, path)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", name, err, tt.want)
+		}
+	}
+}
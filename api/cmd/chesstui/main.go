@@ -0,0 +1,58 @@
+// Command chesstui is a full-screen terminal UI for playing the LLM
+// and getting coaching from the chess API.
+//
+// Usage:
+//
+//	chesstui [-url url] [-key key] [-pgn file] [-coach]
+//
+// The API URL and key default to the CHESS_API_URL and CHESS_API_KEY
+// env vars.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/termchess"
+	"github.com/notnil/chess"
+)
+
+func main() {
+	url := flag.String("url", envOr("CHESS_API_URL", "http://localhost:8080"), "base URL of the chess API")
+	key := flag.String("key", os.Getenv("CHESS_API_KEY"), "API key or JWT for the chess API")
+	pgnFile := flag.String("pgn", "", "PGN file to resume a game from")
+	timeout := flag.Duration("timeout", 3*time.Minute, "timeout for each API call")
+	coach := flag.Bool("coach", false, "rate each move against the engine's best")
+	flag.Parse()
+
+	game := chess.NewGame()
+	if *pgnFile != "" {
+		data, err := os.ReadFile(*pgnFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "chesstui:", err)
+			os.Exit(1)
+		}
+		if game, err = termchess.ParseGame(string(data)); err != nil {
+			fmt.Fprintln(os.Stderr, "chesstui:", err)
+			os.Exit(1)
+		}
+	}
+
+	cln := chessapi.NewClient(*url, chessapi.WithAPIKey(*key))
+	p := tea.NewProgram(newModel(cln, *timeout, game, *coach), tea.WithAltScreen())
+	if _, err := p.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "chesstui:", err)
+		os.Exit(1)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
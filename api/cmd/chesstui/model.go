@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/termchess"
+	"github.com/notnil/chess"
+)
+
+var (
+	titleStyle  = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("212"))
+	panelStyle  = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).Padding(0, 1)
+	mutedStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	errorStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	sourceStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("109")).Italic(true)
+)
+
+// Messages returned by the API commands.
+type (
+	moveMsg struct {
+		who     string
+		move    string
+		game    string
+		gameID  string
+		quality *chessapi.MoveQuality
+		err     error
+	}
+	helpMsg struct {
+		resp chessapi.GenHelpResponse
+		err  error
+	}
+
+	// helpEventMsg is an event streamed while the coach works. The
+	// next message of the stream is read from events.
+	helpEventMsg struct {
+		event  string
+		ev     chessapi.HelpStreamEvent
+		events <-chan tea.Msg
+	}
+)
+
+// helpStages describe the help pipeline's stages in the footer.
+var helpStages = map[string]string{
+	chessapi.HelpStageRender:   "Drawing the board...",
+	chessapi.HelpStageAnalyze:  "Analysing the position...",
+	chessapi.HelpStageDescribe: "The coach is studying your game...",
+	chessapi.HelpStageRetrieve: "Looking through the reference books...",
+	chessapi.HelpStageAnswer:   "The coach is writing...",
+}
+
+// model is the bubbletea model of the game screen.
+type model struct {
+	cln     *chessapi.Client
+	timeout time.Duration
+	game    *chess.Game
+
+	// coach asks the server to rate the user's moves.
+	coach bool
+
+	// gameID names the game on the server, which keeps the coach's
+	// classifications of the user's moves under it. The server picks
+	// one for the first move of a game.
+	gameID string
+
+	input   textinput.Model
+	advice  viewport.Model
+	spinner spinner.Model
+
+	busy   string
+	status string
+	err    error
+	help   *chessapi.GenHelpResponse
+	draft  string
+
+	width, height int
+}
+
+func newModel(cln *chessapi.Client, timeout time.Duration, game *chess.Game, coach bool) model {
+	in := textinput.New()
+	in.Placeholder = "pawn to e4"
+	in.Prompt = "Your move › "
+	in.CharLimit = 120
+	in.Focus()
+
+	sp := spinner.New()
+	sp.Spinner = spinner.Dot
+
+	m := model{
+		cln:     cln,
+		timeout: timeout,
+		game:    game,
+		coach:   coach,
+		input:   in,
+		advice:  viewport.New(40, 20),
+		spinner: sp,
+	}
+	m.advice.SetContent(m.adviceContent())
+	return m
+}
+
+func (m model) Init() tea.Cmd {
+	if m.game.Outcome() == chess.NoOutcome && m.game.Position().Turn() == chess.Black {
+		return tea.Batch(textinput.Blink, m.start("LLaMA 3 is thinking...", m.makeMove()))
+	}
+	return textinput.Blink
+}
+
+// start marks the model busy and runs cmd alongside the spinner.
+func (m *model) start(busy string, cmd tea.Cmd) tea.Cmd {
+	m.busy = busy
+	m.err = nil
+	return tea.Batch(cmd, m.spinner.Tick)
+}
+
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.advice.Width = max(msg.Width-62, 30)
+		m.advice.Height = max(msg.Height-10, 10)
+		m.advice.SetContent(m.adviceContent())
+
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyCtrlC, tea.KeyEsc:
+			return m, tea.Quit
+		case tea.KeyPgUp, tea.KeyPgDown:
+			var cmd tea.Cmd
+			m.advice, cmd = m.advice.Update(msg)
+			return m, cmd
+		case tea.KeyEnter:
+			if m.busy != "" {
+				return m, nil
+			}
+			text := strings.TrimSpace(m.input.Value())
+			m.input.Reset()
+			return m, m.submit(text)
+		}
+
+	case moveMsg:
+		m.busy = ""
+		if msg.err != nil {
+			m.err = msg.err
+			return m, nil
+		}
+		game, err := termchess.ParseGame(msg.game)
+		if err != nil {
+			m.err = err
+			return m, nil
+		}
+		m.game = game
+		m.status = fmt.Sprintf("%s played %s", msg.who, msg.move)
+		if msg.gameID != "" {
+			m.gameID = msg.gameID
+		}
+		if msg.quality != nil {
+			m.status += " · " + qualityText(*msg.quality)
+		}
+
+		// Let the LLM reply after the user's move.
+		if msg.who == "You" && game.Outcome() == chess.NoOutcome {
+			return m, m.start("LLaMA 3 is thinking...", m.makeMove())
+		}
+
+	case helpEventMsg:
+		switch msg.event {
+		case chessapi.HelpEventProgress:
+			if busy, ok := helpStages[msg.ev.Stage]; ok {
+				m.busy = busy
+			}
+		case chessapi.HelpEventToken:
+			m.draft += msg.ev.Token
+			m.advice.SetContent(m.adviceContent())
+			m.advice.GotoBottom()
+		}
+		return m, waitHelp(msg.events)
+
+	case helpMsg:
+		m.busy = ""
+		m.draft = ""
+		if msg.err != nil {
+			m.err = msg.err
+			return m, nil
+		}
+		m.status = "The coach has some advice"
+		m.help = &msg.resp
+		m.advice.SetContent(m.adviceContent())
+		m.advice.GotoTop()
+
+	case spinner.TickMsg:
+		if m.busy == "" {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	cmds = append(cmds, cmd)
+	return m, tea.Batch(cmds...)
+}
+
+// submit handles a line typed in the input box.
+func (m *model) submit(text string) tea.Cmd {
+	switch strings.ToLower(text) {
+	case "":
+		return nil
+	case "quit", "exit":
+		return tea.Quit
+	case "new":
+		m.game = chess.NewGame()
+		m.gameID = ""
+		m.status = "New game"
+		m.err = nil
+		return nil
+	case "help":
+		m.help = nil
+		m.advice.SetContent(mutedStyle.Render("Asking the coach..."))
+		return m.start("The coach is looking at your game...", m.genHelp())
+	}
+	if m.game.Outcome() != chess.NoOutcome {
+		m.status = "The game is over. Type \"new\" to play again."
+		return nil
+	}
+	return m.start("Parsing your move...", m.parseMove(text))
+}
+
+func (m model) parseMove(text string) tea.Cmd {
+	game := termchess.Movetext(m.game)
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		defer cancel()
+		resp, err := m.cln.ParseMove(ctx, chessapi.ParseMoveRequest{Game: game, Move: text, Coach: m.coach, GameID: m.gameID})
+		return moveMsg{who: "You", move: resp.Move, game: resp.GameUpdated, gameID: resp.GameID, quality: resp.Quality, err: err}
+	}
+}
+
+// qualityText gives the coach's verdict on a move for the footer.
+func qualityText(q chessapi.MoveQuality) string {
+	switch q.Classification {
+	case chessapi.MoveQualityBest:
+		return "Coach: the best move"
+	case chessapi.MoveQualityGood:
+		return fmt.Sprintf("Coach: good, %s was a little better", q.BestMove)
+	}
+	text := fmt.Sprintf("Coach: %s, %s was better", q.Classification, q.BestMove)
+	if q.Explanation != "" {
+		text += ". " + q.Explanation
+	}
+	return text
+}
+
+func (m model) makeMove() tea.Cmd {
+	game := termchess.Movetext(m.game)
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		defer cancel()
+		resp, err := m.cln.MakeMove(ctx, chessapi.MakeMoveRequest{Game: game})
+		if err != nil {
+			err = fmt.Errorf("LLaMA 3 got stumped and gave up: %w", err)
+		}
+		return moveMsg{who: termchess.Mover(resp.Source), move: resp.Move, game: resp.GameUpdated, err: err}
+	}
+}
+
+// genHelp streams the coach's advice, delivering each event as a
+// helpEventMsg and finishing with a helpMsg.
+func (m model) genHelp() tea.Cmd {
+	game := termchess.Movetext(m.game)
+	return func() tea.Msg {
+		events := make(chan tea.Msg)
+		go func() {
+			defer close(events)
+			ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+			defer cancel()
+			resp, err := m.cln.GenHelpStream(ctx, chessapi.GenHelpRequest{Game: game}, func(event string, ev chessapi.HelpStreamEvent) error {
+				events <- helpEventMsg{event: event, ev: ev, events: events}
+				return nil
+			})
+			events <- helpMsg{resp: resp, err: err}
+		}()
+		return <-events
+	}
+}
+
+// waitHelp reads the next message of a help stream.
+func waitHelp(events <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-events
+	}
+}
+
+// renderAdvice formats the coach's advice with its source excerpt.
+func renderAdvice(resp chessapi.GenHelpResponse, width int) string {
+	wrap := lipgloss.NewStyle().Width(width)
+	var sb strings.Builder
+	sb.WriteString(wrap.Render(resp.Message))
+	if ref := strings.TrimSpace(resp.ReferenceInfo); ref != "" {
+		sb.WriteString("\n\n" + titleStyle.Render("Source") + "\n")
+		sb.WriteString(sourceStyle.Width(width).Render(excerpt(ref, 600)))
+	}
+	return sb.String()
+}
+
+// excerpt shortens text to about n bytes on a word boundary.
+func excerpt(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= n {
+		return text
+	}
+	if i := strings.LastIndex(text[:n], " "); i > 0 {
+		n = i
+	}
+	return text[:n] + " …"
+}
+
+// adviceContent renders the latest advice for the coach panel.
+func (m model) adviceContent() string {
+	if m.draft != "" {
+		return lipgloss.NewStyle().Width(m.advice.Width).Render(m.draft)
+	}
+	if m.help == nil {
+		return mutedStyle.Render("Type \"help\" for advice from the coach.")
+	}
+	return renderAdvice(*m.help, m.advice.Width)
+}
+
+// moveList renders the moves as numbered pairs, keeping the last rows.
+func (m model) moveList(rows int) string {
+	moves := m.game.Moves()
+	positions := m.game.Positions()
+	var lines []string
+	for i := 0; i < len(moves); i += 2 {
+		line := fmt.Sprintf("%3d. %-7s", i/2+1, chess.AlgebraicNotation{}.Encode(positions[i], moves[i]))
+		if i+1 < len(moves) {
+			line += chess.AlgebraicNotation{}.Encode(positions[i+1], moves[i+1])
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) > rows {
+		lines = lines[len(lines)-rows:]
+	}
+	if len(lines) == 0 {
+		return mutedStyle.Render("No moves yet")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (m model) View() string {
+	board := termchess.Draw(m.game.Position().Board(), termchess.Options{
+		ANSI:      true,
+		Highlight: termchess.LastMoveSquares(m.game),
+	})
+	left := panelStyle.Render(
+		titleStyle.Render("LLaMA 3 Chess") + "\n\n" +
+			board + "\n" +
+			termchess.Status(m.game),
+	)
+	moves := panelStyle.Width(20).Height(lipgloss.Height(left) - 2).Render(
+		titleStyle.Render("Moves") + "\n\n" + m.moveList(lipgloss.Height(left)-6),
+	)
+	advice := panelStyle.Render(
+		titleStyle.Render("Coach") + mutedStyle.Render("  (pgup/pgdn)") + "\n\n" + m.advice.View(),
+	)
+	top := lipgloss.JoinHorizontal(lipgloss.Top, left, moves, advice)
+
+	var footer string
+	switch {
+	case m.busy != "":
+		footer = m.spinner.View() + " " + m.busy
+	case m.err != nil:
+		footer = errorStyle.Render(m.err.Error())
+	default:
+		footer = mutedStyle.Render(m.status)
+	}
+	help := mutedStyle.Render(`Commands: "help", "new", "quit" · esc to exit`)
+
+	return lipgloss.JoinVertical(lipgloss.Left, top, m.input.View(), footer, help)
+}
@@ -2,14 +2,12 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
-	"log"
-	"os"
 	"time"
 
-	_ "github.com/lib/pq"
 	"github.com/predictionguard/go-client"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // VectorizedChunk is a struct that holds a vectorized chunk.
@@ -18,21 +16,19 @@ type VectorizedChunk struct {
 	Chunk    string    `json:"chunk"`
 	Vector   []float64 `json:"vector"`
 	Metadata string    `json:"metadata"`
+	Distance float64   `json:"distance,omitempty"`
 }
 
 // VectorizedChunks is a slice of vectorized chunks.
 type VectorizedChunks []VectorizedChunk
 
-func embed(imageFile string, text string) (*VectorizedChunk, error) {
+func (s *Server) embed(ctx context.Context, imageFile string, text string) (_ *VectorizedChunk, err error) {
+	ctx, span := tracer.Start(ctx, "embedding", trace.WithAttributes(
+		attribute.Bool("embedding.has_image", imageFile != ""),
+	))
+	defer func() { endSpan(span, err) }()
 
-	logger := func(ctx context.Context, msg string, v ...any) {
-		s := fmt.Sprintf("msg: %s", msg)
-		log.Println(s)
-	}
-
-	cln := client.New(logger, host, apiKey)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	var image client.ImageFile
@@ -53,7 +49,9 @@ func embed(imageFile string, text string) (*VectorizedChunk, error) {
 		input[0].Image = image
 	}
 
-	resp, err := cln.Embedding(ctx, input)
+	start := time.Now()
+	resp, err := s.llm.Embedding(ctx, input)
+	embeddingDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
 		return nil, fmt.Errorf("ERROR: %w", err)
 	}
@@ -64,10 +62,10 @@ func embed(imageFile string, text string) (*VectorizedChunk, error) {
 	}, nil
 }
 
-func vectorDBSearch(image, query string) (*VectorizedChunks, error) {
+func (s *Server) vectorDBSearch(ctx context.Context, image, query string) (_ *VectorizedChunks, err error) {
 
 	// Embed the query.
-	chunk, err := embed(image, query)
+	chunk, err := s.embed(ctx, image, query)
 	if err != nil {
 		return nil, fmt.Errorf("ERROR: %w", err)
 	}
@@ -80,34 +78,42 @@ func vectorDBSearch(image, query string) (*VectorizedChunks, error) {
 	}
 	vectorStr += "]"
 
-	// Get the DB connection string from env var.
-	connStr := os.Getenv("DB_CONN_STR")
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		return nil, fmt.Errorf("ERROR: %w", err)
-	}
-	defer db.Close()
-
 	// Query the database for the nearest neighbors.
-	rows, err := db.Query("SELECT id, chunk FROM items ORDER BY embedding <=> $1 LIMIT 5", vectorStr)
+	ctx, span := tracer.Start(ctx, "vector_search")
+	start := time.Now()
+	defer func() {
+		vectorSearchDuration.Observe(time.Since(start).Seconds())
+		endSpan(span, err)
+	}()
+	rows, err := s.db.QueryContext(ctx, "SELECT id, chunk, embedding <=> $1 AS distance FROM items ORDER BY distance LIMIT 5", vectorStr)
 	if err != nil {
 		return nil, fmt.Errorf("ERROR: %w", err)
 	}
 	defer rows.Close()
 
 	var vectorizedChunks VectorizedChunks
+	var ids []int
+	var distances []float64
 	for rows.Next() {
 		var id int
 		var chunk string
-		err := rows.Scan(&id, &chunk)
+		var distance float64
+		err := rows.Scan(&id, &chunk, &distance)
 		if err != nil {
 			return nil, fmt.Errorf("ERROR: %w", err)
 		}
 		vectorizedChunks = append(vectorizedChunks, VectorizedChunk{
-			Id:    id,
-			Chunk: chunk,
+			Id:       id,
+			Chunk:    chunk,
+			Distance: distance,
 		})
+		ids = append(ids, id)
+		distances = append(distances, distance)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ERROR: %w", err)
 	}
+	span.SetAttributes(attrChunkIDs.IntSlice(ids), attrChunkScores.Float64Slice(distances))
 
 	return &vectorizedChunks, nil
 }
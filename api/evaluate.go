@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/analysis"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/engine"
+	"github.com/notnil/chess"
+)
+
+// mateThreshold separates mate scores from ordinary evaluations.
+const mateThreshold = engine.MateScore - 1000
+
+// isMate reports whether an engine score is a forced mate for either
+// side.
+func isMate(score int) bool {
+	return score > mateThreshold || score < -mateThreshold
+}
+
+// Evaluate analyses the current position of a game without calling the
+// LLM.
+func (s *Server) Evaluate(w http.ResponseWriter, r *http.Request) {
+
+	// Parse the body into a value of EvaluateRequest.
+	var req EvaluateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse the game.
+	pgnReader := bytes.NewReader([]byte(req.Game))
+	pgn, err := chess.PGN(pgnReader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	game := chess.NewGame(pgn)
+
+	// Prep the response.
+	resp, err := s.evaluate(r.Context(), game)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return the response.
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// evaluate analyses the current position of game. If the engine fails,
+// the response still holds the static analysis along with the error.
+func (s *Server) evaluate(ctx context.Context, game *chess.Game) (EvaluateResponse, error) {
+	pos := game.Position()
+	report := analysis.Analyze(pos)
+	resp := EvaluateResponse{
+		FEN:   pos.String(),
+		Turn:  pos.Turn().Name(),
+		White: sideEvaluation(report.White),
+		Black: sideEvaluation(report.Black),
+		Facts: report.Facts(),
+	}
+	if resp.Opening = classifyOpening(game); resp.Opening != nil {
+		resp.Facts = append([]string{openingFact(resp.Opening)}, resp.Facts...)
+	}
+	resp.Tactics = []chessapi.Tactic{}
+	for _, t := range analysis.Tactics(pos) {
+		resp.Tactics = append(resp.Tactics, tactic(t))
+		resp.Facts = append(resp.Facts, t.String())
+	}
+
+	// The engine has nothing to say about a finished game.
+	if game.Outcome() != chess.NoOutcome {
+		resp.Facts = append(resp.Facts, fmt.Sprintf("The game is over: %s by %s.", game.Outcome(), game.Method()))
+		return resp, nil
+	}
+
+	// The tablebase knows the result of endgames outright.
+	if resp.Tablebase = s.probeTablebase(ctx, pos); resp.Tablebase != nil {
+		resp.Facts = append(resp.Facts, tablebaseFact(pos.Turn(), resp.Tablebase))
+	}
+
+	cands, err := s.searchPosition(ctx, pos, 1, 0)
+	if err != nil {
+		return resp, err
+	}
+	best := cands[0]
+	resp.Score, resp.Mate = whiteScore(pos.Turn(), best.Score)
+	resp.BestMove = best.SAN
+	resp.BestLine = best.PV
+	resp.Facts = append(resp.Facts, engineFact(resp, pos.Turn()))
+
+	return resp, nil
+}
+
+// whiteScore converts an engine score for the side to move to white's
+// point of view, splitting off mates as moves to mate.
+func whiteScore(turn chess.Color, score int) (cp, mate int) {
+	if turn == chess.Black {
+		score = -score
+	}
+	switch {
+	case score > mateThreshold:
+		return score, (engine.MateScore - score + 1) / 2
+	case score < -mateThreshold:
+		return score, -(engine.MateScore + score + 1) / 2
+	}
+	return score, 0
+}
+
+// engineFact sums up the engine's view of the position in a sentence.
+func engineFact(resp EvaluateResponse, turn chess.Color) string {
+	switch {
+	case resp.Mate > 0:
+		return fmt.Sprintf("The engine sees a forced mate in %d for White; the best move for %s is %s.", resp.Mate, turn.Name(), resp.BestMove)
+	case resp.Mate < 0:
+		return fmt.Sprintf("The engine sees a forced mate in %d for Black; the best move for %s is %s.", -resp.Mate, turn.Name(), resp.BestMove)
+	case resp.Score > 50:
+		return fmt.Sprintf("The engine rates the position %+.2f, better for White; the best move for %s is %s.", float64(resp.Score)/100, turn.Name(), resp.BestMove)
+	case resp.Score < -50:
+		return fmt.Sprintf("The engine rates the position %+.2f, better for Black; the best move for %s is %s.", float64(resp.Score)/100, turn.Name(), resp.BestMove)
+	}
+	return fmt.Sprintf("The engine rates the position %+.2f, about equal; the best move for %s is %s.", float64(resp.Score)/100, turn.Name(), resp.BestMove)
+}
+
+// sideEvaluation converts one side of an analysis to its wire format.
+func sideEvaluation(side analysis.Side) chessapi.SideEvaluation {
+	squares := func(sqs []chess.Square) []string {
+		out := make([]string, len(sqs))
+		for i, sq := range sqs {
+			out[i] = sq.String()
+		}
+		return out
+	}
+
+	eval := chessapi.SideEvaluation{
+		Material: side.Material,
+		Mobility: side.Mobility,
+		King: chessapi.KingSafety{
+			Square:      side.King.Square.String(),
+			InCheck:     side.King.InCheck,
+			Shield:      side.King.Shield,
+			OpenFiles:   side.King.OpenFiles,
+			ZoneAttacks: side.King.ZoneAttacks,
+		},
+		Pawns: chessapi.PawnStructure{
+			Isolated: squares(side.Pawns.Isolated),
+			Doubled:  squares(side.Pawns.Doubled),
+			Passed:   squares(side.Pawns.Passed),
+		},
+		Hanging: []chessapi.PieceOnBoard{},
+	}
+	for _, p := range side.Hanging {
+		eval.Hanging = append(eval.Hanging, pieceOnBoard(p))
+	}
+	return eval
+}
+
+// tactic converts a tactic to its wire format.
+func tactic(t analysis.Tactic) chessapi.Tactic {
+	out := chessapi.Tactic{
+		Motif:       t.Motif,
+		Side:        t.Side.Name(),
+		Piece:       pieceOnBoard(t.Piece),
+		Targets:     make([]chessapi.PieceOnBoard, len(t.Targets)),
+		Move:        t.Move,
+		Description: t.String(),
+	}
+	for i, p := range t.Targets {
+		out.Targets[i] = pieceOnBoard(p)
+	}
+	return out
+}
+
+// pieceOnBoard converts a piece on a square to its wire format.
+func pieceOnBoard(p analysis.Piece) chessapi.PieceOnBoard {
+	return chessapi.PieceOnBoard{
+		Color:  p.Piece.Color().Name(),
+		Piece:  analysis.PieceName(p.Piece.Type()),
+		Square: p.Square.String(),
+	}
+}
+
+// analyzePosition evaluates the current position of game for a prompt.
+// An engine failure only costs the engine's score and fact.
+func (s *Server) analyzePosition(ctx context.Context, game *chess.Game) EvaluateResponse {
+	resp, err := s.evaluate(ctx, game)
+	if err != nil {
+		slog.WarnContext(ctx, "engine evaluation failed", "error", err)
+	}
+	return resp
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/engine"
+	"github.com/notnil/chess"
+)
+
+func TestWhiteScore(t *testing.T) {
+	const mate = engine.MateScore
+	tests := []struct {
+		name     string
+		turn     chess.Color
+		score    int
+		cp, mate int
+	}{
+		{"white better, white to move", chess.White, 35, 35, 0},
+		{"white better, black to move", chess.Black, -35, 35, 0},
+		{"black better, black to move", chess.Black, 120, -120, 0},
+		{"white mates in 1", chess.White, mate - 1, mate - 1, 1},
+		{"white mates in 2", chess.White, mate - 3, mate - 3, 2},
+		{"black mates in 1", chess.Black, mate - 1, -(mate - 1), -1},
+		{"white is mated in 1", chess.White, -(mate - 2), -(mate - 2), -1},
+		{"black is mated in 1", chess.Black, -(mate - 2), mate - 2, 1},
+		{"black is mated in 3", chess.Black, -(mate - 6), mate - 6, 3},
+		{"just short of a mate score", chess.White, mateThreshold, mateThreshold, 0},
+		{"longest mate", chess.White, mateThreshold + 1, mateThreshold + 1, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp, mate := whiteScore(tt.turn, tt.score)
+			if cp != tt.cp || mate != tt.mate {
+				t.Errorf("whiteScore(%s, %d) = %d, %d, want %d, %d", tt.turn.Name(), tt.score, cp, mate, tt.cp, tt.mate)
+			}
+		})
+	}
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image/color"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
@@ -13,26 +15,16 @@ import (
 	"strings"
 	"time"
 
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
 	"github.com/notnil/chess"
 	"github.com/notnil/chess/image"
 )
 
 // Index is the handler for the root URL.
-func Index(w http.ResponseWriter, r *http.Request) {
+func (s *Server) Index(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "The API is healthy!\n")
 }
 
-type ParseMoveRequest struct {
-	Game string `json:"game"`
-	Move string `json:"move"`
-}
-
-type ParseMoveResponse struct {
-	Move         string `json:"move"`
-	GameOriginal string `json:"game_original"`
-	GameUpdated  string `json:"game_updated"`
-}
-
 func formatBoard(game *chess.Game) string {
 
 	pieceMap := map[string]string{
@@ -65,8 +57,53 @@ func formatBoard(game *chess.Game) string {
 	return pieceList
 }
 
-// ParseMove parses natural language moves.
-func ParseMove(w http.ResponseWriter, r *http.Request) {
+// uciToSAN converts a legal move in UCI notation to algebraic notation.
+func uciToSAN(game *chess.Game, move string) (string, bool) {
+	pos := game.Position()
+	m, err := chess.UCINotation{}.Decode(pos, strings.ToLower(move))
+	if err != nil {
+		return "", false
+	}
+	for _, valid := range pos.ValidMoves() {
+		if valid.S1() == m.S1() && valid.S2() == m.S2() && valid.Promo() == m.Promo() {
+			return chess.AlgebraicNotation{}.Encode(pos, valid), true
+		}
+	}
+	return "", false
+}
+
+// applyUserMove parses a natural language move and applies it to game,
+// returning the move in algebraic notation.
+func (s *Server) applyUserMove(ctx context.Context, game *chess.Game, text string) (string, error) {
+
+	// Moves already in UCI notation (e.g. e2e4 from a board click) don't
+	// need the LLM. Everything else is parsed with an LLM.
+	move, ok := uciToSAN(game, strings.TrimSpace(text))
+	if !ok {
+		pieceList := formatBoard(game)
+		var err error
+		color := strings.ToLower(game.Position().Turn().Name())
+		move, err = s.parseMoveWithLLM(ctx, text, pieceList, color)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Move the piece.
+	if err := game.MoveStr(move); err != nil {
+		return "", err
+	}
+
+	return move, nil
+}
+
+// maxGameIDLen is the longest game ID a client may name its game by.
+const maxGameIDLen = 64
+
+// ParseMove parses natural language moves and, if asked to coach,
+// classifies the move against the engine's best, storing the
+// classification with the game.
+func (s *Server) ParseMove(w http.ResponseWriter, r *http.Request) {
 
 	// Parse the body into a value of ParseMoveRequest.
 	var req ParseMoveRequest
@@ -75,6 +112,10 @@ func ParseMove(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if len(req.GameID) > maxGameIDLen {
+		http.Error(w, fmt.Sprintf("game_id is longer than %d characters", maxGameIDLen), http.StatusBadRequest)
+		return
+	}
 
 	// Parse the game.
 	pgnReader := bytes.NewReader([]byte(req.Game))
@@ -85,25 +126,26 @@ func ParseMove(w http.ResponseWriter, r *http.Request) {
 	}
 	game := chess.NewGame(pgn)
 
-	// Parse the move with an LLM.
-	pieceList := formatBoard(game)
-	move, err := parseMoveWithLLM(req.Move, pieceList)
+	// Parse and apply the move.
+	move, err := s.applyUserMove(r.Context(), game, req.Move)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Move the piece.
-	if err = game.MoveStr(move); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	// Prepare the response.
+	// Prepare the response, coaching the player on the move if asked.
 	resp := ParseMoveResponse{
 		Move:         move,
+		GameID:       req.GameID,
 		GameOriginal: req.Game,
 		GameUpdated:  strings.TrimPrefix(game.String(), "\n"),
+		Opening:      classifyOpening(game),
+	}
+	if resp.GameID == "" {
+		resp.GameID = newID()
+	}
+	if req.Coach {
+		s.coach(r.Context(), game, req.Game, &resp)
 	}
 
 	// Return the response.
@@ -115,21 +157,12 @@ func ParseMove(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-type MakeMoveRequest struct {
-	Game string `json:"game"`
-}
-
-type MakeMoveResponse struct {
-	Move         string `json:"move"`
-	GameOriginal string `json:"game_original"`
-	GameUpdated  string `json:"game_updated"`
-}
+// GameState describes a game without calling the LLM, for clients
+// that draw the board themselves.
+func (s *Server) GameState(w http.ResponseWriter, r *http.Request) {
 
-// MakeMove take a game and uses an LLM to make a move.
-func MakeMove(w http.ResponseWriter, r *http.Request) {
-
-	// Parse the body into a value of MakeMoveRequest.
-	var req MakeMoveRequest
+	// Parse the body into a value of GameStateRequest.
+	var req GameStateRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -144,29 +177,144 @@ func MakeMove(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	game := chess.NewGame(pgn)
+
+	// Prep the response.
+	resp := gameState(game)
+
+	// Return the response.
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// gameState summarizes the current position of game.
+func gameState(game *chess.Game) GameStateResponse {
+	pos := game.Position()
+	resp := GameStateResponse{
+		FEN:        game.FEN(),
+		Turn:       pos.Turn().Name(),
+		Outcome:    string(game.Outcome()),
+		Moves:      []string{},
+		LegalMoves: []string{},
+		Opening:    classifyOpening(game),
+	}
+	if game.Method() != chess.NoMethod {
+		resp.Method = game.Method().String()
+	}
+
+	moves := game.Moves()
+	positions := game.Positions()
+	for i, m := range moves {
+		resp.Moves = append(resp.Moves, chess.AlgebraicNotation{}.Encode(positions[i], m))
+	}
+	if len(moves) > 0 {
+		last := moves[len(moves)-1]
+		resp.LastMove = chess.UCINotation{}.Encode(positions[len(moves)-1], last)
+		resp.Check = last.HasTag(chess.Check)
+	}
+	if game.Outcome() == chess.NoOutcome {
+		for _, m := range pos.ValidMoves() {
+			resp.LegalMoves = append(resp.LegalMoves, chess.UCINotation{}.Encode(pos, m))
+		}
+	}
+
+	return resp
+}
+
+// applyAIMove makes the AI's move in game, returning the move and its
+// source. Early in a game the move comes from the opening book, if one
+// is configured and has the position, and late in a game from the
+// endgame tablebase, if it covers the position. Otherwise the move is
+// the LLM's unless it cannot produce a legal one within the blunder
+// margin, in which case the engine moves instead.
+func (s *Server) applyAIMove(ctx context.Context, game *chess.Game, margin int) (string, string, error) {
+	move, err := s.applyBookMove(ctx, game)
+	if err != nil {
+		return "", "", err
+	}
+	if move != "" {
+		return move, chessapi.MoveSourceBook, nil
+	}
+
+	move, err = s.applyTablebaseMove(ctx, game)
+	if err != nil {
+		return "", "", err
+	}
+	if move != "" {
+		return move, chessapi.MoveSourceTablebase, nil
+	}
+
+	move, err = s.applyLLMMove(ctx, game, margin)
+	if err == nil {
+		return move, chessapi.MoveSourceLLM, nil
+	}
+	if ctx.Err() != nil {
+		return "", "", err
+	}
+
+	slog.WarnContext(ctx, "falling back to the engine", "error", err)
+	move, err = s.applyEngineMove(ctx, game)
+	if err != nil {
+		return "", "", err
+	}
+	return move, chessapi.MoveSourceEngine, nil
+}
+
+// applyEngineMove searches for a move with the engine and applies it to
+// game.
+func (s *Server) applyEngineMove(ctx context.Context, game *chess.Game) (string, error) {
+	start := time.Now()
+	cands, err := s.searchPosition(ctx, game.Position(), 1, 0)
+	if err != nil {
+		return "", err
+	}
+
+	best := cands[0]
+	if err := game.Move(best.Move); err != nil {
+		return "", err
+	}
+	slog.InfoContext(ctx, "engine move",
+		"move", best.SAN,
+		"score", best.Score,
+		"pv", strings.Join(best.PV, " "),
+		"duration", time.Since(start),
+	)
+
+	return best.SAN, nil
+}
+
+// applyLLMMove asks the LLM for a move, retrying with the rejected moves
+// until it produces a legal one, and applies it to game. With a positive
+// margin, legal moves that lose more than margin centipawns compared with
+// the engine's best move are rejected as blunders too.
+func (s *Server) applyLLMMove(ctx context.Context, game *chess.Game, margin int) (string, error) {
 	//gameBoard := strings.TrimPrefix(game.Position().Board().Draw(), "\n")
 	gameBoard := formatBoard(game)
 	gamePGN := strings.TrimPrefix(game.String(), "\n")
 	gamePGN = strings.TrimSuffix(gamePGN, " *")
 
+	pos := game.Position()
+	blunders := blunderCheck{s: s, pos: pos, margin: margin}
 	invalidMoves := []string{}
+	rejected := []string{}
 	var move string
+	var err error
 	for i := 0; i < 10; i++ {
 
 		// Generate a move with an LLM.
-		move, err = generateMoveWithLLM(gameBoard, gamePGN, invalidMoves)
+		move, err = s.generateMoveWithLLM(ctx, gameBoard, gamePGN, invalidMoves, rejected)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			return "", err
 		}
 
-		// Move the piece.
-		if err = game.MoveStr(move); err != nil {
-			if i == 9 {
-				fmt.Println(err.Error())
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
+		// Check the move.
+		var m *chess.Move
+		m, err = chess.AlgebraicNotation{}.Decode(pos, move)
+		if err != nil {
+			moveResults.WithLabelValues("illegal").Inc()
 
 			// Add to invalid moves if the move isn't already in the list.
 			if !slices.Contains(invalidMoves, move) {
@@ -174,39 +322,44 @@ func MakeMove(w http.ResponseWriter, r *http.Request) {
 			}
 			continue
 		}
-		break
-	}
-
-	// Prep the response.
-	resp := MakeMoveResponse{
-		Move:         move,
-		GameOriginal: req.Game,
-		GameUpdated:  strings.TrimPrefix(game.String(), "\n"),
-	}
+		if margin > 0 {
+			reason, checkErr := blunders.check(ctx, m)
+			switch {
+			case checkErr != nil && ctx.Err() != nil:
+				return "", checkErr
+			case checkErr != nil:
+				slog.WarnContext(ctx, "blunder check failed", "move", move, "error", checkErr)
+			case reason != "":
+				moveResults.WithLabelValues("blunder").Inc()
+				slog.InfoContext(ctx, "rejected llm blunder", "move", move, "reason", reason, "margin", margin)
+				if !slices.Contains(rejected, reason) {
+					rejected = append(rejected, reason)
+				}
+				err = errors.New(reason)
+				continue
+			}
+		}
 
-	// Return the response.
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(resp)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		// Move the piece.
+		if err = game.Move(m); err != nil {
+			return "", err
+		}
+		moveResults.WithLabelValues("legal").Inc()
+		moveAttempts.Observe(float64(i + 1))
+		return move, nil
 	}
-}
-
-type GenHelpRequest struct {
-	Game string `json:"game"`
-}
 
-type GenHelpResponse struct {
-	Message       string `json:"message"`
-	ReferenceInfo string `json:"reference_info"`
+	moveAttempts.Observe(10)
+	moveGiveUps.Inc()
+	slog.WarnContext(ctx, "llm failed to produce an acceptable move", "attempts", 10, "invalid_moves", invalidMoves, "blunders", rejected, "error", err)
+	return "", err
 }
 
-// GenHelp generates help messages for a game.
-func GenHelp(w http.ResponseWriter, r *http.Request) {
+// MakeMove take a game and uses an LLM to make a move.
+func (s *Server) MakeMove(w http.ResponseWriter, r *http.Request) {
 
 	// Parse the body into a value of MakeMoveRequest.
-	var req GenHelpRequest
+	var req MakeMoveRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -222,41 +375,125 @@ func GenHelp(w http.ResponseWriter, r *http.Request) {
 	}
 	game := chess.NewGame(pgn)
 
+	// Work out how good the move must be.
+	margin, err := s.blunderMargin(req.Difficulty)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Generate and apply a move with an LLM, or the engine if need be.
+	move, source, err := s.applyAIMove(r.Context(), game, margin)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Prep the response.
+	resp := MakeMoveResponse{
+		Move:         move,
+		Source:       source,
+		GameOriginal: req.Game,
+		GameUpdated:  strings.TrimPrefix(game.String(), "\n"),
+		Opening:      classifyOpening(game),
+	}
+
+	// Return the response.
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// renderBoardImage writes the current board to an SVG file and converts
+// it to a JPG with ffmpeg, returning the JPG path.
+func renderBoardImage(ctx context.Context, game *chess.Game) (string, error) {
+
 	// Create a temporary string based on the unix time.
-	t := time.Now().Unix()
-	tempFilename := fmt.Sprintf("/tmp/%d.svg", t)
+	t := time.Now().UnixNano()
+	svgFilename := fmt.Sprintf("/tmp/%d.svg", t)
+	jpgFilename := fmt.Sprintf("/tmp/%d.jpg", t)
 
 	// Write the game to an SVG image.
-	f, err := os.Create(tempFilename)
+	_, span := tracer.Start(ctx, "help.render_svg")
+	f, err := os.Create(svgFilename)
 	if err != nil {
-		log.Fatal(err)
+		endSpan(span, err)
+		return "", fmt.Errorf("ERROR: %w", err)
 	}
-	defer f.Close()
 	yellow := color.RGBA{255, 255, 0, 1}
 	mark := image.MarkSquares(yellow, chess.D2, chess.D4)
-	if err := image.SVG(f, game.Position().Board(), mark); err != nil {
-		log.Fatal(err)
+	err = image.SVG(f, game.Position().Board(), mark)
+	f.Close()
+	endSpan(span, err)
+	if err != nil {
+		return "", fmt.Errorf("ERROR: %w", err)
 	}
 
 	// Convert the SVG file to a JPG image using ffmpeg.
-	cmd := exec.Command("ffmpeg", "-i", tempFilename, "-vf", "scale=800:-1", "-y", "/tmp/"+fmt.Sprintf("%d.jpg", t))
+	ctx, span = tracer.Start(ctx, "help.ffmpeg")
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", svgFilename, "-vf", "scale=800:-1", "-y", jpgFilename)
 	err = cmd.Run()
+	endSpan(span, err)
+	if err != nil {
+		return "", fmt.Errorf("ERROR: converting board image: %w", err)
+	}
+
+	return jpgFilename, nil
+}
+
+// helpObserver follows the help pipeline as it runs. Any field may be
+// nil; setting token streams the answer instead of waiting for all of it.
+type helpObserver struct {
+	stage   func(stage string)
+	sources func(chunks VectorizedChunks)
+	token   func(token string)
+}
+
+func (o helpObserver) enter(stage string) {
+	if o.stage != nil {
+		o.stage(stage)
+	}
+}
+
+// genHelp renders the board, describes the game, retrieves reference
+// info and generates advice for the game given as pgn.
+func (s *Server) genHelp(ctx context.Context, game *chess.Game, pgn string, obs helpObserver) (GenHelpResponse, error) {
+
+	// Render the board to a JPG image for the multimodal embedding.
+	obs.enter(chessapi.HelpStageRender)
+	imageFile, err := renderBoardImage(ctx, game)
 	if err != nil {
-		log.Fatal(err)
+		return GenHelpResponse{}, err
 	}
 
+	// Analyse the current position.
+	obs.enter(chessapi.HelpStageAnalyze)
+	eval := s.analyzePosition(ctx, game)
+	facts := eval.Facts
+
 	// Get a description of the game.
-	description, err := generateGameDescWithLLM(req.Game)
+	obs.enter(chessapi.HelpStageDescribe)
+	description, err := s.generateGameDescWithLLM(ctx, pgn, facts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return GenHelpResponse{}, err
 	}
 
-	// Embed and search for relevant reference info.
-	chunks, err := vectorDBSearch("/tmp/"+fmt.Sprintf("%d.jpg", t), description)
+	// Embed and search for relevant reference info, naming the opening
+	// so material on it ranks higher.
+	obs.enter(chessapi.HelpStageRetrieve)
+	query := description
+	if eval.Opening != nil {
+		query = openingFact(eval.Opening) + "\n\n" + description
+	}
+	chunks, err := s.vectorDBSearch(ctx, imageFile, query)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return GenHelpResponse{}, err
+	}
+	if obs.sources != nil {
+		obs.sources(*chunks)
 	}
 
 	// Grab the text from the first chunk in chunks.
@@ -268,16 +505,48 @@ func GenHelp(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Generate the response.
-	responseMessage, err := generateQAWithLLM(description, req.Game, referenceInfo)
+	obs.enter(chessapi.HelpStageAnswer)
+	responseMessage, err := s.generateQAWithLLM(ctx, description, pgn, referenceInfo, facts, obs.token)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return GenHelpResponse{}, err
 	}
 
 	// Prep the response.
 	resp := GenHelpResponse{
 		Message:       responseMessage,
 		ReferenceInfo: referenceInfo,
+		Tactics:       eval.Tactics,
+		Tablebase:     eval.Tablebase,
+	}
+
+	return resp, nil
+}
+
+// GenHelp generates help messages for a game.
+func (s *Server) GenHelp(w http.ResponseWriter, r *http.Request) {
+
+	// Parse the body into a value of MakeMoveRequest.
+	var req GenHelpRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse the game.
+	pgnReader := bytes.NewReader([]byte(req.Game))
+	pgn, err := chess.PGN(pgnReader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	game := chess.NewGame(pgn)
+
+	// Run the help pipeline.
+	resp, err := s.genHelp(r.Context(), game, req.Game, helpObserver{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	// Return the response.
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+// Healthz is the liveness probe. It only reports that the process is
+// up and serving requests.
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, HealthResponse{Status: statusOK})
+}
+
+// Readyz is the readiness probe. It checks every dependency the
+// handlers need and fails if any of them is unavailable.
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	checks := []struct {
+		name string
+		fn   func(context.Context) (string, error)
+	}{
+		{"db", s.checkDB},
+		{"pgvector", s.checkPGVector},
+		{"items", s.checkItems},
+		{"llm", s.checkLLM},
+		{"engine", s.checkEngine},
+	}
+
+	resp := HealthResponse{
+		Status: statusOK,
+		Checks: make(map[string]CheckResult, len(checks)),
+	}
+	for _, c := range checks {
+		start := time.Now()
+		detail, err := c.fn(ctx)
+		result := CheckResult{
+			Status:  statusOK,
+			Detail:  detail,
+			Latency: time.Since(start).String(),
+		}
+		if err != nil {
+			result.Status = statusFail
+			result.Error = err.Error()
+			resp.Status = statusFail
+		}
+		resp.Checks[c.name] = result
+	}
+
+	writeHealth(w, resp)
+}
+
+func (s *Server) checkDB(ctx context.Context) (string, error) {
+	if err := s.db.PingContext(ctx); err != nil {
+		return "", err
+	}
+	stats := s.db.Stats()
+	return fmt.Sprintf("open=%d in_use=%d idle=%d", stats.OpenConnections, stats.InUse, stats.Idle), nil
+}
+
+func (s *Server) checkPGVector(ctx context.Context) (string, error) {
+	var version string
+	err := s.db.QueryRowContext(ctx, "SELECT extversion FROM pg_extension WHERE extname = 'vector'").Scan(&version)
+	if err != nil {
+		return "", fmt.Errorf("vector extension not available: %w", err)
+	}
+	return "version=" + version, nil
+}
+
+func (s *Server) checkItems(ctx context.Context) (string, error) {
+	var count int
+	if err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM items").Scan(&count); err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "", fmt.Errorf("items table is empty")
+	}
+	return fmt.Sprintf("rows=%d", count), nil
+}
+
+func (s *Server) checkLLM(ctx context.Context) (string, error) {
+	if _, err := s.llm.HealthCheck(ctx); err != nil {
+		return "", err
+	}
+	return s.cfg.LLMHost, nil
+}
+
+func (s *Server) checkEngine(ctx context.Context) (string, error) {
+	if s.uci == nil {
+		return "built-in", nil
+	}
+	if err := s.uci.Ping(ctx); err != nil {
+		return "", err
+	}
+	return s.uci.Name, nil
+}
+
+// writeHealth encodes a health response, using 503 for failures so
+// orchestrators can act on the status code alone.
+func writeHealth(w http.ResponseWriter, resp HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Status != statusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
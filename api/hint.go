@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/analysis"
+	"github.com/notnil/chess"
+	"github.com/notnil/chess/image"
+)
+
+// Hint nudges the side to move towards the best move, giving away as
+// much as the requested level.
+func (s *Server) Hint(w http.ResponseWriter, r *http.Request) {
+
+	// Parse the body into a value of HintRequest.
+	var req HintRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch req.Level {
+	case "":
+		req.Level = chessapi.HintLevelPiece
+	case chessapi.HintLevelPiece, chessapi.HintLevelSquare, chessapi.HintLevelMove:
+	default:
+		http.Error(w, fmt.Sprintf("unknown hint level %q", req.Level), http.StatusBadRequest)
+		return
+	}
+
+	// Parse the game.
+	pgnReader := bytes.NewReader([]byte(req.Game))
+	pgn, err := chess.PGN(pgnReader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	game := chess.NewGame(pgn)
+	if game.Outcome() != chess.NoOutcome {
+		http.Error(w, "the game is over", http.StatusBadRequest)
+		return
+	}
+
+	// Prep the response.
+	resp, err := s.hint(r.Context(), game, req.Game, req.Level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return the response.
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// hint works out the best move in game, from the tablebase or the
+// engine, and has the LLM explain it at the given level.
+func (s *Server) hint(ctx context.Context, game *chess.Game, pgn string, level string) (HintResponse, error) {
+	pos := game.Position()
+	eval := s.analyzePosition(ctx, game)
+	san := eval.BestMove
+	if eval.Tablebase != nil && eval.Tablebase.BestMove != "" {
+		san = eval.Tablebase.BestMove
+	}
+	if san == "" {
+		return HintResponse{}, errors.New("no move to hint at, the engine failed")
+	}
+
+	// Only hint at a legal move.
+	move, err := chess.AlgebraicNotation{}.Decode(pos, san)
+	if err != nil {
+		return HintResponse{}, fmt.Errorf("hinting at %s: %w", san, err)
+	}
+	piece := analysis.Piece{Piece: pos.Board().Piece(move.S1()), Square: move.S1()}
+	name := fmt.Sprintf("%s on %s", analysis.PieceName(piece.Piece.Type()), piece.Square)
+
+	resp := HintResponse{Level: level}
+	var reveal, fallback string
+	var leaks []string
+	switch level {
+	case chessapi.HintLevelPiece:
+		p := pieceOnBoard(piece)
+		resp.Piece = &p
+		reveal = fmt.Sprintf("Point the player at their %s, but don't say where it goes or give the move.", name)
+		fallback = fmt.Sprintf("Take a closer look at your %s.", name)
+		leaks = []string{san, move.S2().String()}
+	case chessapi.HintLevelSquare:
+		resp.Square = move.S2().String()
+		reveal = fmt.Sprintf("Point the player at the %s square, but don't say which piece goes there or give the move.", move.S2())
+		fallback = fmt.Sprintf("Take a closer look at the %s square.", move.S2())
+		leaks = []string{san, move.S1().String()}
+	default:
+		p := pieceOnBoard(piece)
+		resp.Piece, resp.Square, resp.Move = &p, move.S2().String(), san
+		reveal = fmt.Sprintf("Name the move %s.", san)
+	}
+
+	rationale, err := s.generateHintWithLLM(ctx, pgn, eval.Facts, san, reveal)
+	if err != nil {
+		return HintResponse{}, err
+	}
+	resp.Rationale = firstSentence(rationale)
+
+	// The LLM doesn't always keep a secret.
+	for _, leak := range leaks {
+		if resp.Rationale == "" || strings.Contains(resp.Rationale, leak) {
+			resp.Rationale = fallback
+			break
+		}
+	}
+	if resp.Rationale == "" {
+		resp.Rationale = fmt.Sprintf("%s is the best move here.", san)
+	}
+
+	if resp.Image, err = hintImage(pos, level, move); err != nil {
+		return HintResponse{}, err
+	}
+
+	return resp, nil
+}
+
+// firstSentence returns the first sentence of text: up to the first
+// full stop, exclamation or question mark followed by a space or the
+// end, so that a move number such as "3.Bb5" doesn't cut it short.
+func firstSentence(text string) string {
+	text = strings.TrimSpace(text)
+	for i := 0; i < len(text); i++ {
+		if strings.IndexByte(".!?", text[i]) >= 0 && (i+1 == len(text) || unicode.IsSpace(rune(text[i+1]))) {
+			return text[:i+1]
+		}
+	}
+	return text
+}
+
+// Colors of the marks on hint images.
+var (
+	hintMark  = color.RGBA{255, 255, 0, 1}
+	hintArrow = "#15781b"
+)
+
+// hintImage draws the board from the side to move's view as an SVG,
+// marking the square of the piece to move or its destination, or for a
+// move level hint, drawing an arrow for the move.
+func hintImage(pos *chess.Position, level string, move *chess.Move) (string, error) {
+	var marks []chess.Square
+	switch level {
+	case chessapi.HintLevelPiece:
+		marks = append(marks, move.S1())
+	case chessapi.HintLevelSquare:
+		marks = append(marks, move.S2())
+	default:
+		marks = append(marks, move.S1(), move.S2())
+	}
+
+	var buf bytes.Buffer
+	err := image.SVG(&buf, pos.Board(), image.Perspective(pos.Turn()), image.MarkSquares(hintMark, marks...))
+	if err != nil {
+		return "", err
+	}
+	svg := buf.String()
+	if level != chessapi.HintLevelMove {
+		return svg, nil
+	}
+
+	// Add the arrow, from centre to centre of the squares.
+	x1, y1 := squareCentre(move.S1(), pos.Turn())
+	x2, y2 := squareCentre(move.S2(), pos.Turn())
+	arrow := fmt.Sprintf(`<defs><marker id="hint-head" markerWidth="3" markerHeight="3" refX="1.5" refY="1.5" orient="auto"><path d="M0,0 L3,1.5 L0,3 z" fill="%[1]s"/></marker></defs>
+<line x1="%[2]d" y1="%[3]d" x2="%[4]d" y2="%[5]d" stroke="%[1]s" stroke-width="9" stroke-opacity="0.8" stroke-linecap="round" marker-end="url(#hint-head)"/>
+`, hintArrow, x1, y1, x2, y2)
+	end := strings.LastIndex(svg, "</svg>")
+	if end < 0 {
+		return "", errors.New("rendering the hint image: no closing svg tag")
+	}
+	return svg[:end] + arrow + svg[end:], nil
+}
+
+// squareCentre returns the centre of sq in pixels on a board image drawn
+// from the view of perspective, whose squares are 45 pixels wide.
+func squareCentre(sq chess.Square, perspective chess.Color) (x, y int) {
+	const size = 45
+	col, row := int(sq.File()), 7-int(sq.Rank())
+	if perspective == chess.Black {
+		col, row = 7-col, 7-row
+	}
+	return col*size + size/2, row*size + size/2
+}
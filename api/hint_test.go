@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/notnil/chess"
+	"github.com/notnil/chess/image"
+)
+
+func TestFirstSentence(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"Your rook is strong. Use it.", "Your rook is strong."},
+		{"  Check the back rank! It's weak.  ", "Check the back rank!"},
+		{"Is the king safe? Look again.", "Is the king safe?"},
+		{"After 3.Bb5 the knight is pinned. Really.", "After 3.Bb5 the knight is pinned."},
+		{"No full stop", "No full stop"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := firstSentence(tt.text); got != tt.want {
+			t.Errorf("firstSentence(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSquareCentre(t *testing.T) {
+	tests := []struct {
+		sq          chess.Square
+		perspective chess.Color
+		x, y        int
+	}{
+		{chess.A1, chess.White, 22, 337},
+		{chess.H8, chess.White, 337, 22},
+		{chess.E4, chess.White, 202, 202},
+		{chess.A1, chess.Black, 337, 22},
+		{chess.H8, chess.Black, 22, 337},
+		{chess.E4, chess.Black, 157, 157},
+	}
+	// The marked square's rectangle in the board image is where the
+	// image library draws the square.
+	markRE := regexp.MustCompile(`<rect x="(\d+)" y="(\d+)" width="45" height="45" style="fill-opacity:0.2;`)
+	board := chess.NewGame().Position().Board()
+	for _, tt := range tests {
+		x, y := squareCentre(tt.sq, tt.perspective)
+		if x != tt.x || y != tt.y {
+			t.Errorf("squareCentre(%s, %s) = %d, %d, want %d, %d", tt.sq, tt.perspective, x, y, tt.x, tt.y)
+		}
+
+		var buf bytes.Buffer
+		if err := image.SVG(&buf, board, image.Perspective(tt.perspective), image.MarkSquares(hintMark, tt.sq)); err != nil {
+			t.Fatal(err)
+		}
+		m := markRE.FindStringSubmatch(buf.String())
+		if m == nil {
+			t.Fatalf("%s: no marked square in the image", tt.sq)
+		}
+		if m[1] != strconv.Itoa(x-22) || m[2] != strconv.Itoa(y-22) {
+			t.Errorf("squareCentre(%s, %s) = %d, %d, but the image draws the square at %s, %s", tt.sq, tt.perspective, x, y, m[1], m[2])
+		}
+	}
+}
+
+// TestHintRationale checks that a rationale giving away more than the
+// level asked for is replaced. The best move is Rd1-d8 mate.
+func TestHintRationale(t *testing.T) {
+	tests := []struct {
+		level string
+		reply string
+		want  string
+	}{
+		{chessapi.HintLevelPiece, "Your rook has a job to do on the back rank. Go.", "Your rook has a job to do on the back rank."},
+		{chessapi.HintLevelPiece, "Your rook can reach d8.", "Take a closer look at your rook on d1."},
+		{chessapi.HintLevelPiece, "Play Rd8# to win.", "Take a closer look at your rook on d1."},
+		{chessapi.HintLevelSquare, "The king has no escape from the back rank.", "The king has no escape from the back rank."},
+		{chessapi.HintLevelSquare, "The rook on d1 can end it.", "Take a closer look at the d8 square."},
+		{chessapi.HintLevelMove, "Rd8# mates at once.", "Rd8# mates at once."},
+		{chessapi.HintLevelMove, "", "Rd8# is the best move here."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level+" "+tt.reply, func(t *testing.T) {
+			s := &Server{
+				cfg: Config{EngineDepth: 2, EngineMoveTime: 10 * time.Second},
+				llm: fakeLLM(t, func(system, user string) (string, error) {
+					return tt.reply, nil
+				}),
+			}
+			opt, err := chess.FEN("6k1/5ppp/8/8/8/8/8/3RK3 w - - 0 1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := s.hint(context.Background(), chess.NewGame(opt), "*", tt.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Rationale != tt.want {
+				t.Errorf("rationale = %q, want %q", resp.Rationale, tt.want)
+			}
+			if arrow := strings.Contains(resp.Image, "hint-head"); arrow != (tt.level == chessapi.HintLevelMove) {
+				t.Errorf("image has an arrow: %v, at level %s", arrow, tt.level)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+// Package analysis computes verifiable facts about a chess position, such
+// as material, mobility, king safety, pawn structure and hanging pieces,
+// for prompts that would otherwise leave the LLM to guess them.
+package analysis
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/engine"
+	"github.com/notnil/chess"
+)
+
+// Report describes a position from both sides.
+type Report struct {
+	Turn  chess.Color
+	White Side
+	Black Side
+}
+
+// Side holds the facts about one side's pieces. Material is in
+// centipawns; Mobility counts the pseudo-legal moves of the side's
+// knights, bishops, rooks and queens.
+type Side struct {
+	Material int
+	Mobility int
+	King     KingSafety
+	Pawns    PawnStructure
+	Hanging  []Piece
+}
+
+// KingSafety describes the shelter of a king. Shield counts the side's
+// pawns on the king's file and the files next to it, one or two ranks in
+// front of the king; OpenFiles counts those files without any of the
+// side's pawns; ZoneAttacks counts the squares next to the king the
+// opponent attacks.
+type KingSafety struct {
+	Square      chess.Square
+	InCheck     bool
+	Shield      int
+	OpenFiles   int
+	ZoneAttacks int
+}
+
+// PawnStructure lists the squares of a side's weak and strong pawns.
+// Doubled holds every pawn sharing a file with another.
+type PawnStructure struct {
+	Isolated []chess.Square
+	Doubled  []chess.Square
+	Passed   []chess.Square
+}
+
+// Piece is a piece on a square.
+type Piece struct {
+	Piece  chess.Piece
+	Square chess.Square
+}
+
+// Side returns the report for color c.
+func (r Report) Side(c chess.Color) Side {
+	if c == chess.Black {
+		return r.Black
+	}
+	return r.White
+}
+
+// Analyze reports on pos.
+func Analyze(pos *chess.Position) Report {
+	b := newBoard(pos.Board())
+	return Report{
+		Turn:  pos.Turn(),
+		White: b.side(chess.White),
+		Black: b.side(chess.Black),
+	}
+}
+
+func (b *board) side(c chess.Color) Side {
+	var s Side
+	for i, p := range b {
+		sq := chess.Square(i)
+		if p.Color() != c {
+			continue
+		}
+		s.Material += engine.PieceValue(p.Type())
+		if directions(p.Type()) != nil || p.Type() == chess.Knight {
+			for _, to := range b.attacks(sq) {
+				if b[to].Color() != c {
+					s.Mobility++
+				}
+			}
+		}
+		if b.hanging(sq) {
+			s.Hanging = append(s.Hanging, Piece{Piece: p, Square: sq})
+		}
+	}
+	s.King = b.kingSafety(c)
+	s.Pawns = b.pawnStructure(c)
+	return s
+}
+
+// hanging reports whether the piece on sq can be won: it is attacked
+// more often than it is defended, or by a cheaper piece. Kings never
+// hang, and a king can't take a defended piece, so it only counts as an
+// attacker of undefended ones.
+func (b *board) hanging(sq chess.Square) bool {
+	p := b[sq]
+	if p.Type() == chess.King {
+		return false
+	}
+	attackers := b.attackers(sq, other(p.Color()))
+	defenders := b.attackers(sq, p.Color())
+	if len(defenders) > 0 {
+		attackers = slices.DeleteFunc(attackers, func(from chess.Square) bool {
+			return b[from].Type() == chess.King
+		})
+	}
+	if len(attackers) == 0 {
+		return false
+	}
+	if len(attackers) > len(defenders) {
+		return true
+	}
+	for _, from := range attackers {
+		if engine.PieceValue(b[from].Type()) < engine.PieceValue(p.Type()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *board) kingSafety(c chess.Color) KingSafety {
+	sq, ok := b.king(c)
+	if !ok {
+		return KingSafety{}
+	}
+	k := KingSafety{
+		Square:  sq,
+		InCheck: len(b.attackers(sq, other(c))) > 0,
+	}
+
+	forward := 1
+	if c == chess.Black {
+		forward = -1
+	}
+	for df := -1; df <= 1; df++ {
+		f := int(sq.File()) + df
+		if f < 0 || f > 7 {
+			continue
+		}
+		var pawns bool
+		for r := 0; r < 8; r++ {
+			p := b[r*8+f]
+			if p.Type() != chess.Pawn || p.Color() != c {
+				continue
+			}
+			pawns = true
+			if ahead := (r - int(sq.Rank())) * forward; ahead == 1 || ahead == 2 {
+				k.Shield++
+			}
+		}
+		if !pawns {
+			k.OpenFiles++
+		}
+	}
+
+	for _, d := range allDirs {
+		if to, ok := step(sq, d[0], d[1]); ok && len(b.attackers(to, other(c))) > 0 {
+			k.ZoneAttacks++
+		}
+	}
+	return k
+}
+
+func (b *board) pawnStructure(c chess.Color) PawnStructure {
+	var files [8]int
+	for _, p := range b.pawns(c) {
+		files[p.File()]++
+	}
+	hasPawns := func(f int) bool {
+		return f >= 0 && f < 8 && files[f] > 0
+	}
+
+	var ps PawnStructure
+	for _, sq := range b.pawns(c) {
+		f := int(sq.File())
+		if !hasPawns(f-1) && !hasPawns(f+1) {
+			ps.Isolated = append(ps.Isolated, sq)
+		}
+		if files[f] > 1 {
+			ps.Doubled = append(ps.Doubled, sq)
+		}
+		if b.passed(sq) {
+			ps.Passed = append(ps.Passed, sq)
+		}
+	}
+	return ps
+}
+
+// pawns returns the squares of the pawns of color c.
+func (b *board) pawns(c chess.Color) []chess.Square {
+	var out []chess.Square
+	for sq, p := range b {
+		if p.Type() == chess.Pawn && p.Color() == c {
+			out = append(out, chess.Square(sq))
+		}
+	}
+	return out
+}
+
+// passed reports whether the pawn on sq has no opposing pawns in front
+// of it on its own or a neighbouring file.
+func (b *board) passed(sq chess.Square) bool {
+	c := b[sq].Color()
+	for _, theirs := range b.pawns(other(c)) {
+		df := int(theirs.File()) - int(sq.File())
+		dr := int(theirs.Rank()) - int(sq.Rank())
+		if c == chess.Black {
+			dr = -dr
+		}
+		if df >= -1 && df <= 1 && dr > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Facts describes the report in plain sentences, for prompts.
+func (r Report) Facts() []string {
+	var facts []string
+	switch d := r.White.Material - r.Black.Material; {
+	case d > 0:
+		facts = append(facts, fmt.Sprintf("White is ahead in material by %s.", Pawns(d)))
+	case d < 0:
+		facts = append(facts, fmt.Sprintf("Black is ahead in material by %s.", Pawns(-d)))
+	default:
+		facts = append(facts, "Material is level.")
+	}
+	facts = append(facts, fmt.Sprintf("White's pieces have %d moves, Black's %d.", r.White.Mobility, r.Black.Mobility))
+
+	for _, c := range []chess.Color{r.Turn, other(r.Turn)} {
+		s := r.Side(c)
+		name := strings.ToLower(c.Name())
+		if s.King.InCheck {
+			facts = append(facts, fmt.Sprintf("The %s king on %s is in check.", name, s.King.Square))
+		}
+		facts = append(facts, fmt.Sprintf("The %s king on %s is sheltered by %s, with %s next to it and %s around it attacked.",
+			name, s.King.Square, count(s.King.Shield, "pawn"), count(s.King.OpenFiles, "open file"), count(s.King.ZoneAttacks, "square")))
+		for _, p := range s.Hanging {
+			facts = append(facts, fmt.Sprintf("The %s %s on %s is hanging.", name, PieceName(p.Piece.Type()), p.Square))
+		}
+		for _, pawns := range []struct {
+			kind    string
+			squares []chess.Square
+		}{
+			{"isolated", s.Pawns.Isolated},
+			{"doubled", s.Pawns.Doubled},
+			{"passed", s.Pawns.Passed},
+		} {
+			if len(pawns.squares) == 0 {
+				continue
+			}
+			noun := "pawns"
+			if len(pawns.squares) == 1 {
+				noun = "pawn"
+			}
+			facts = append(facts, fmt.Sprintf("%s has %s %s on %s.", c.Name(), pawns.kind, noun, squareList(pawns.squares)))
+		}
+	}
+	return facts
+}
+
+// Pawns formats centipawns as pawns, e.g. "1.5 pawns".
+func Pawns(cp int) string {
+	if cp == 100 {
+		return "1 pawn"
+	}
+	return fmt.Sprintf("%.1f pawns", float64(cp)/100)
+}
+
+// count formats n of noun, e.g. "1 pawn" or "2 pawns".
+func count(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// PieceName returns the English name of a piece type.
+func PieceName(t chess.PieceType) string {
+	switch t {
+	case chess.King:
+		return "king"
+	case chess.Queen:
+		return "queen"
+	case chess.Rook:
+		return "rook"
+	case chess.Bishop:
+		return "bishop"
+	case chess.Knight:
+		return "knight"
+	case chess.Pawn:
+		return "pawn"
+	}
+	return ""
+}
+
+// squareList joins squares as "a2, b3 and c4".
+func squareList(squares []chess.Square) string {
+	names := make([]string, len(squares))
+	for i, sq := range squares {
+		names[i] = sq.String()
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
@@ -0,0 +1,92 @@
+package analysis
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+func position(t *testing.T, fen string) *chess.Position {
+	t.Helper()
+	opt, err := chess.FEN(fen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chess.NewGame(opt).Position()
+}
+
+func TestHanging(t *testing.T) {
+	tests := []struct {
+		name string
+		fen  string
+		want []Piece // the hanging pieces of both sides, white first
+	}{
+		{"king attacks a defended queen", "4k3/3Q4/8/8/8/8/8/3RK3 b - - 0 1", nil},
+		{"king attacks an undefended queen", "4k3/3Q4/8/8/8/8/8/4K3 b - - 0 1", []Piece{{chess.WhiteQueen, chess.D7}}},
+		{"pawn attacks a defended knight", "4k3/8/3p4/4N3/3P4/8/8/4K3 w - - 0 1", []Piece{{chess.WhiteKnight, chess.E5}}},
+		{"rooks attack each other", "4k3/8/8/3r4/8/8/3R4/3RK3 w - - 0 1", []Piece{{chess.BlackRook, chess.D5}}},
+		{"attacked twice, defended once", "4k3/8/4p3/3n3R/8/3R4/8/4K3 w - - 0 1", []Piece{{chess.BlackKnight, chess.D5}}},
+		{"defended by its own king", "8/8/8/8/8/3k4/3B4/3K4 w - - 0 1", nil},
+		{"nothing attacked", "4k3/8/8/8/8/8/8/4K3 w - - 0 1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Analyze(position(t, tt.fen))
+			if got := append(r.White.Hanging, r.Black.Hanging...); !slices.Equal(got, tt.want) {
+				t.Errorf("hanging = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKingSafety(t *testing.T) {
+	r := Analyze(position(t, "r4rk1/5ppp/8/8/8/8/PP3PPP/3R2K1 b - - 0 1"))
+	want := KingSafety{Square: chess.G8, Shield: 3}
+	if r.Black.King != want {
+		t.Errorf("black king = %+v, want %+v", r.Black.King, want)
+	}
+	want = KingSafety{Square: chess.G1, Shield: 3}
+	if r.White.King != want {
+		t.Errorf("white king = %+v, want %+v", r.White.King, want)
+	}
+
+	r = Analyze(position(t, "4k3/8/8/8/8/8/8/4K2r w - - 0 1"))
+	want = KingSafety{Square: chess.E1, InCheck: true, OpenFiles: 3, ZoneAttacks: 1}
+	if r.White.King != want {
+		t.Errorf("white king in check = %+v, want %+v", r.White.King, want)
+	}
+}
+
+func TestPawnStructure(t *testing.T) {
+	r := Analyze(position(t, "4k3/p7/8/8/8/2P5/2PP3P/4K3 w - - 0 1"))
+	want := PawnStructure{
+		Isolated: []chess.Square{chess.H2},
+		Doubled:  []chess.Square{chess.C2, chess.C3},
+		Passed:   []chess.Square{chess.C2, chess.D2, chess.H2, chess.C3},
+	}
+	got := r.White.Pawns
+	if !slices.Equal(got.Isolated, want.Isolated) || !slices.Equal(got.Doubled, want.Doubled) || !slices.Equal(got.Passed, want.Passed) {
+		t.Errorf("white pawns = %+v, want %+v", got, want)
+	}
+	if !slices.Equal(r.Black.Pawns.Isolated, []chess.Square{chess.A7}) || !slices.Equal(r.Black.Pawns.Passed, []chess.Square{chess.A7}) {
+		t.Errorf("black pawns = %+v, want a7 isolated and passed", r.Black.Pawns)
+	}
+}
+
+func TestFacts(t *testing.T) {
+	facts := Analyze(position(t, "4k3/3Q4/8/8/8/8/8/3RK3 b - - 0 1")).Facts()
+	text := strings.Join(facts, " ")
+	for _, want := range []string{
+		"White is ahead in material by 14.0 pawns.",
+		"The black king on e8 is in check.",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("facts %q: missing %q", facts, want)
+		}
+	}
+	if strings.Contains(text, "hanging") {
+		t.Errorf("facts %q: the defended queen is reported hanging", facts)
+	}
+}
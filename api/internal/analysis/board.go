@@ -0,0 +1,119 @@
+package analysis
+
+import (
+	"slices"
+
+	"github.com/notnil/chess"
+)
+
+// board is a copy of a position's pieces indexed by square, which makes
+// walking along files, ranks and diagonals cheap.
+type board [64]chess.Piece
+
+func newBoard(b *chess.Board) *board {
+	var bd board
+	for sq, p := range b.SquareMap() {
+		bd[sq] = p
+	}
+	return &bd
+}
+
+// Directions as file and rank steps.
+var (
+	straight = [][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+	diagonal = [][2]int{{1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+	allDirs  = append(slices.Clone(straight), diagonal...)
+	jumps    = [][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}}
+)
+
+// step returns the square df files and dr ranks away from sq, if it is
+// on the board.
+func step(sq chess.Square, df, dr int) (chess.Square, bool) {
+	f, r := int(sq.File())+df, int(sq.Rank())+dr
+	if f < 0 || f > 7 || r < 0 || r > 7 {
+		return 0, false
+	}
+	return chess.Square(r*8 + f), true
+}
+
+// directions returns the directions a sliding piece moves in, or nil.
+func directions(t chess.PieceType) [][2]int {
+	switch t {
+	case chess.Bishop:
+		return diagonal
+	case chess.Rook:
+		return straight
+	case chess.Queen:
+		return allDirs
+	}
+	return nil
+}
+
+// attacks returns the squares the piece on sq attacks, whether they are
+// empty or hold a piece of either color.
+func (b *board) attacks(sq chess.Square) []chess.Square {
+	p := b[sq]
+	var out []chess.Square
+	add := func(df, dr int) {
+		if to, ok := step(sq, df, dr); ok {
+			out = append(out, to)
+		}
+	}
+
+	switch p.Type() {
+	case chess.Pawn:
+		dr := 1
+		if p.Color() == chess.Black {
+			dr = -1
+		}
+		add(-1, dr)
+		add(1, dr)
+	case chess.Knight:
+		for _, d := range jumps {
+			add(d[0], d[1])
+		}
+	case chess.King:
+		for _, d := range allDirs {
+			add(d[0], d[1])
+		}
+	default:
+		for _, d := range directions(p.Type()) {
+			for to, ok := step(sq, d[0], d[1]); ok; to, ok = step(to, d[0], d[1]) {
+				out = append(out, to)
+				if b[to] != chess.NoPiece {
+					break
+				}
+			}
+		}
+	}
+	return out
+}
+
+// attackers returns the squares of the pieces of color c attacking sq.
+func (b *board) attackers(sq chess.Square, c chess.Color) []chess.Square {
+	var out []chess.Square
+	for from, p := range b {
+		if p.Color() == c && slices.Contains(b.attacks(chess.Square(from)), sq) {
+			out = append(out, chess.Square(from))
+		}
+	}
+	return out
+}
+
+// king returns the square of the king of color c.
+func (b *board) king(c chess.Color) (chess.Square, bool) {
+	for sq, p := range b {
+		if p.Type() == chess.King && p.Color() == c {
+			return chess.Square(sq), true
+		}
+	}
+	return 0, false
+}
+
+// other returns the opposing color.
+func other(c chess.Color) chess.Color {
+	if c == chess.White {
+		return chess.Black
+	}
+	return chess.White
+}
@@ -0,0 +1,262 @@
+package analysis
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/engine"
+	"github.com/notnil/chess"
+)
+
+// Tactical motifs.
+const (
+	MotifFork       = "fork"
+	MotifPin        = "pin"
+	MotifSkewer     = "skewer"
+	MotifDiscovered = "discovered_attack"
+)
+
+// Tactic is a tactical motif in favour of Side. Piece is the piece doing
+// the attacking and Targets the pieces it wins or ties down, most
+// valuable first for forks and nearest first otherwise. For a
+// discovered attack, Piece is the piece that uncovers the attack and
+// Targets holds the attacking piece and its target. Move, in algebraic
+// notation, is set for motifs that Side can play rather than ones on the
+// board already.
+type Tactic struct {
+	Motif   string
+	Side    chess.Color
+	Piece   Piece
+	Targets []Piece
+	Move    string
+}
+
+// String describes the tactic in a sentence.
+func (t Tactic) String() string {
+	switch t.Motif {
+	case MotifFork:
+		if t.Move != "" {
+			return fmt.Sprintf("%s can fork %s with %s.", t.Side.Name(), pieceList(t.Targets), t.Move)
+		}
+		return fmt.Sprintf("The %s forks %s.", describe(t.Piece), pieceList(t.Targets))
+	case MotifPin:
+		return fmt.Sprintf("The %s is pinned to the %s by the %s.", describe(t.Targets[0]), describe(t.Targets[1]), describe(t.Piece))
+	case MotifSkewer:
+		return fmt.Sprintf("The %s skewers the %s to the %s.", describe(t.Piece), describe(t.Targets[0]), describe(t.Targets[1]))
+	case MotifDiscovered:
+		return fmt.Sprintf("Moving the %s uncovers an attack by the %s on the %s.", describe(t.Piece), describe(t.Targets[0]), describe(t.Targets[1]))
+	}
+	return t.Motif
+}
+
+// Tactics finds the pins, skewers, forks and discovered attacks on the
+// board for both sides, and the forks each side could play next. The
+// side to move comes first.
+func Tactics(pos *chess.Position) []Tactic {
+	b := newBoard(pos.Board())
+	var out []Tactic
+	for _, c := range []chess.Color{pos.Turn(), other(pos.Turn())} {
+		out = append(out, b.lines(c)...)
+		out = append(out, b.forks(c)...)
+		out = append(out, forkMoves(pos, c)...)
+	}
+	return out
+}
+
+// lines finds the pins, skewers and discovered attacks of the sliding
+// pieces of color c.
+func (b *board) lines(c chess.Color) []Tactic {
+	var out []Tactic
+	for i, p := range b {
+		sq := chess.Square(i)
+		if p.Color() != c {
+			continue
+		}
+		for _, d := range directions(p.Type()) {
+			first, second, ok := b.ray(sq, d)
+			if !ok {
+				continue
+			}
+			front, back := b[first], b[second]
+			switch {
+			case front.Color() == c:
+
+				// Moving our own piece out of the way attacks what is
+				// behind it. A pawn on a file stays in the way unless it
+				// captures, so it doesn't count.
+				if front.Type() == chess.Pawn && d[0] == 0 {
+					continue
+				}
+				if back.Color() != c && b.worthAttacking(second, p) {
+					out = append(out, Tactic{
+						Motif:   MotifDiscovered,
+						Side:    c,
+						Piece:   Piece{front, first},
+						Targets: []Piece{{p, sq}, {back, second}},
+					})
+				}
+			case back.Color() == c:
+			case rank(front) < rank(back):
+				if back.Type() == chess.King || value(back) > value(p) || len(b.attackers(second, back.Color())) == 0 {
+					out = append(out, Tactic{
+						Motif:   MotifPin,
+						Side:    c,
+						Piece:   Piece{p, sq},
+						Targets: []Piece{{front, first}, {back, second}},
+					})
+				}
+			case rank(front) > rank(back) && rank(front) > value(p) && b.worthAttacking(second, p) && !b.hanging(sq):
+				out = append(out, Tactic{
+					Motif:   MotifSkewer,
+					Side:    c,
+					Piece:   Piece{p, sq},
+					Targets: []Piece{{front, first}, {back, second}},
+				})
+			}
+		}
+	}
+	return out
+}
+
+// ray returns the first two pieces from sq in direction d.
+func (b *board) ray(sq chess.Square, d [2]int) (first, second chess.Square, ok bool) {
+	var found int
+	for to, on := step(sq, d[0], d[1]); on; to, on = step(to, d[0], d[1]) {
+		if b[to] == chess.NoPiece {
+			continue
+		}
+		if found == 0 {
+			first = to
+			found++
+			continue
+		}
+		return first, to, true
+	}
+	return 0, 0, false
+}
+
+// forks finds the pieces of color c that attack two or more targets.
+func (b *board) forks(c chess.Color) []Tactic {
+	var out []Tactic
+	for i, p := range b {
+		sq := chess.Square(i)
+		if p.Color() != c || p.Type() == chess.King || b.hanging(sq) {
+			continue
+		}
+		if targets := b.targets(sq); len(targets) >= 2 {
+			out = append(out, Tactic{Motif: MotifFork, Side: c, Piece: Piece{p, sq}, Targets: targets})
+		}
+	}
+	return out
+}
+
+// forkMoves finds the moves of color c that fork two or more targets
+// with the moving piece, without leaving it hanging.
+func forkMoves(pos *chess.Position, c chess.Color) []Tactic {
+	if pos.Turn() != c {
+		var ok bool
+		if pos, ok = passTurn(pos); !ok {
+			return nil
+		}
+	}
+
+	var out []Tactic
+	for _, m := range pos.ValidMoves() {
+		b := newBoard(pos.Update(m).Board())
+		if b[m.S2()].Type() == chess.King || b.hanging(m.S2()) {
+			continue
+		}
+		if targets := b.targets(m.S2()); len(targets) >= 2 {
+			out = append(out, Tactic{
+				Motif:   MotifFork,
+				Side:    c,
+				Piece:   Piece{b[m.S2()], m.S2()},
+				Targets: targets,
+				Move:    chess.AlgebraicNotation{}.Encode(pos, m),
+			})
+		}
+	}
+	return out
+}
+
+// passTurn returns pos with the other side to move, to look at the moves
+// it could make. It fails if the side to move is in check, as the other
+// side could then take the king.
+func passTurn(pos *chess.Position) (*chess.Position, bool) {
+	b := newBoard(pos.Board())
+	if king, ok := b.king(pos.Turn()); !ok || len(b.attackers(king, other(pos.Turn()))) > 0 {
+		return nil, false
+	}
+
+	// Swap the side to move and drop the en passant square.
+	fields := strings.Fields(pos.String())
+	if len(fields) < 4 {
+		return nil, false
+	}
+	fields[1] = map[string]string{"w": "b", "b": "w"}[fields[1]]
+	fields[3] = "-"
+	opt, err := chess.FEN(strings.Join(fields, " "))
+	if err != nil {
+		return nil, false
+	}
+	return chess.NewGame(opt).Position(), true
+}
+
+// targets returns the pieces the piece on sq attacks that are worth
+// attacking, most valuable first.
+func (b *board) targets(sq chess.Square) []Piece {
+	p := b[sq]
+	var out []Piece
+	for _, to := range b.attacks(sq) {
+		if t := b[to]; t != chess.NoPiece && t.Color() != p.Color() && b.worthAttacking(to, p) {
+			out = append(out, Piece{t, to})
+		}
+	}
+	slices.SortStableFunc(out, func(a, b Piece) int {
+		return rank(b.Piece) - rank(a.Piece)
+	})
+	return out
+}
+
+// worthAttacking reports whether attacking the piece on sq with by wins
+// something: it is the king, worth more than by or undefended.
+func (b *board) worthAttacking(sq chess.Square, by chess.Piece) bool {
+	t := b[sq]
+	return t.Type() == chess.King || value(t) > value(by) || len(b.attackers(sq, t.Color())) == 0
+}
+
+// value returns the material value of a piece.
+func value(p chess.Piece) int {
+	return engine.PieceValue(p.Type())
+}
+
+// rank orders pieces by importance, the king first.
+func rank(p chess.Piece) int {
+	if p.Type() == chess.King {
+		return engine.MateScore
+	}
+	return value(p)
+}
+
+// describe names a piece on a square, e.g. "white knight on c3".
+func describe(p Piece) string {
+	return fmt.Sprintf("%s %s on %s", strings.ToLower(p.Piece.Color().Name()), PieceName(p.Piece.Type()), p.Square)
+}
+
+// pieceList names pieces of one color, e.g. "the black king on e8 and
+// the rook on a8".
+func pieceList(pieces []Piece) string {
+	names := make([]string, len(pieces))
+	for i, p := range pieces {
+		if i == 0 {
+			names[i] = "the " + describe(p)
+			continue
+		}
+		names[i] = fmt.Sprintf("the %s on %s", PieceName(p.Piece.Type()), p.Square)
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
@@ -0,0 +1,66 @@
+package analysis
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTactics(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		motif string
+		want  string // the tactic, or "" for none with the motif
+	}{
+		{
+			"knight fork", "r3k3/2N5/8/8/8/8/8/4K3 b - - 0 1", MotifFork,
+			"The white knight on c7 forks the black king on e8 and the rook on a8.",
+		},
+		{
+			"fork next to the king, defended", "3q4/2r2k2/4N3/3P4/8/8/8/4K3 b - - 0 1", MotifFork,
+			"The white knight on e6 forks the black queen on d8 and the rook on c7.",
+		},
+		{"forking knight hangs", "r3k3/2N5/8/8/8/8/2q5/4K3 b - - 0 1", MotifFork, ""},
+		{
+			"fork to play", "r3k3/8/8/3N4/8/8/8/4K3 w - - 0 1", MotifFork,
+			"White can fork the black king on e8 and the rook on a8 with Nc7+.",
+		},
+		{
+			"absolute pin", "4k3/8/2n5/1B6/8/8/8/4K3 w - - 0 1", MotifPin,
+			"The black knight on c6 is pinned to the black king on e8 by the white bishop on b5.",
+		},
+		{
+			"relative pin", "4q1k1/8/8/4n3/8/8/8/4RK2 w - - 0 1", MotifPin,
+			"The black knight on e5 is pinned to the black queen on e8 by the white rook on e1.",
+		},
+		{"defended cheaper piece behind", "4rk2/8/8/4n3/8/8/8/4QK2 w - - 0 1", MotifPin, ""},
+		{
+			"skewer", "R3k2r/8/8/8/8/8/8/4K3 b - - 0 1", MotifSkewer,
+			"The white rook on a8 skewers the black king on e8 to the black rook on h8.",
+		},
+		{"defended rook behind", "R3k2r/5n2/8/8/8/8/8/4K3 b - - 0 1", MotifSkewer, ""},
+		{"skewering rook hangs", "R3k2r/8/8/3b4/8/8/8/4K3 b - - 0 1", MotifSkewer, ""},
+		{
+			"discovered attack", "4k3/6q1/8/8/3N4/8/1B6/4K3 w - - 0 1", MotifDiscovered,
+			"Moving the white knight on d4 uncovers an attack by the white bishop on b2 on the black queen on g7.",
+		},
+		{"pawn in front on the file", "4q1k1/8/8/8/4P3/8/8/4RK2 w - - 0 1", MotifDiscovered, ""},
+		{"defended knight behind", "4k3/4p3/5n2/8/3N4/8/1B6/4K3 w - - 0 1", MotifDiscovered, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, tactic := range Tactics(position(t, tt.fen)) {
+				if tactic.Motif == tt.motif {
+					got = append(got, tactic.String())
+				}
+			}
+			switch {
+			case tt.want == "" && len(got) > 0:
+				t.Errorf("got %q, want no %s", got, tt.motif)
+			case tt.want != "" && !slices.Contains(got, tt.want):
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+// Package engine is a small alpha-beta chess engine working on
+// notnil/chess positions. It is no match for a real engine, but it
+// always finds a legal and sensible move, which makes it a fallback for
+// the LLM and a yardstick for its moves.
+package engine
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+// Defaults used for zero Options.
+const (
+	DefaultDepth    = 4
+	DefaultMoveTime = 2 * time.Second
+)
+
+// MateScore is the score of giving mate on the move. Mates further away
+// score MateScore minus the plies to mate.
+const MateScore = 100000
+
+// ErrNoMoves is returned when searching a position without legal moves.
+var ErrNoMoves = errors.New("engine: no legal moves")
+
+// Options bound a search. The search deepens one ply at a time until it
+// reaches Depth or runs out of MoveTime, whichever comes first. MultiPV
+// is the number of best moves to find lines for, one by default.
+type Options struct {
+	Depth    int
+	MoveTime time.Duration
+	MultiPV  int
+}
+
+// Line is the principal variation starting with one root move. Score is
+// in centipawns from the point of view of the side to move.
+type Line struct {
+	Move  *chess.Move
+	Score int
+	PV    []*chess.Move
+}
+
+// Result is the outcome of a search. Lines holds up to MultiPV lines,
+// best first; Move, Score and PV are those of the best line.
+type Result struct {
+	Move  *chess.Move
+	Score int
+	Depth int
+	Nodes int
+	PV    []*chess.Move
+	Lines []Line
+}
+
+// Search looks for the best move in pos. It returns the result of the
+// deepest completed iteration, so a time limit or a cancelled ctx still
+// yields a legal move.
+func Search(ctx context.Context, pos *chess.Position, opts Options) (Result, error) {
+	if opts.Depth <= 0 {
+		opts.Depth = DefaultDepth
+	}
+	if opts.MoveTime <= 0 {
+		opts.MoveTime = DefaultMoveTime
+	}
+	if opts.MultiPV <= 0 {
+		opts.MultiPV = 1
+	}
+
+	moves := pos.ValidMoves()
+	if len(moves) == 0 {
+		return Result{}, ErrNoMoves
+	}
+	orderMoves(pos, moves, nil)
+
+	s := searcher{ctx: ctx, deadline: time.Now().Add(opts.MoveTime)}
+	best := Result{Move: moves[0], Lines: []Line{{Move: moves[0]}}}
+	for depth := 1; depth <= opts.Depth; depth++ {
+		lines := s.root(pos, moves, depth, opts.MultiPV)
+		if s.stopped {
+			break
+		}
+		top := lines[0]
+		best = Result{Move: top.Move, Score: top.Score, Depth: depth, PV: top.PV, Lines: lines}
+
+		// Search the best moves first next time around.
+		first := make([]*chess.Move, len(lines))
+		for i, line := range lines {
+			first[i] = line.Move
+		}
+		orderMoves(pos, moves, first)
+
+		// Nothing deeper will change a forced mate.
+		if opts.MultiPV == 1 && (top.Score >= MateScore-depth || top.Score <= -MateScore+depth) {
+			break
+		}
+	}
+	best.Nodes = s.nodes
+
+	return best, nil
+}
+
+// searcher holds the state of one search.
+type searcher struct {
+	ctx      context.Context
+	deadline time.Time
+	nodes    int
+	stopped  bool
+}
+
+// checkEvery is how many nodes are searched between checks of the clock.
+const checkEvery = 1024
+
+// stop reports whether the search is out of time.
+func (s *searcher) stop() bool {
+	if s.stopped {
+		return true
+	}
+	if s.nodes%checkEvery == 0 && (time.Now().After(s.deadline) || s.ctx.Err() != nil) {
+		s.stopped = true
+	}
+	return s.stopped
+}
+
+// root searches each of the root moves to depth, returning the best k
+// lines. Only moves that could make the top k need an exact score, so
+// the k-th best score so far is the lower bound for the rest.
+func (s *searcher) root(pos *chess.Position, moves []*chess.Move, depth, k int) []Line {
+	lines := make([]Line, 0, len(moves))
+	for _, m := range moves {
+		alpha := -MateScore - 1
+		if len(lines) >= k {
+			alpha = lines[k-1].Score
+		}
+		score, pv := s.negamax(pos.Update(m), depth-1, 1, -MateScore-1, -alpha)
+		if s.stopped {
+			return nil
+		}
+		lines = append(lines, Line{Move: m, Score: -score, PV: append([]*chess.Move{m}, pv...)})
+		slices.SortStableFunc(lines, func(a, b Line) int {
+			return b.Score - a.Score
+		})
+	}
+	return lines[:min(k, len(lines))]
+}
+
+// negamax is a fail-hard alpha-beta search returning the score of pos
+// from the side to move's point of view and its principal variation.
+func (s *searcher) negamax(pos *chess.Position, depth, ply, alpha, beta int) (int, []*chess.Move) {
+	s.nodes++
+	if s.stop() {
+		return 0, nil
+	}
+
+	moves := pos.ValidMoves()
+	if len(moves) == 0 {
+		if pos.Status() == chess.Checkmate {
+			return -MateScore + ply, nil
+		}
+		return 0, nil
+	}
+	if pos.HalfMoveClock() >= 100 {
+		return 0, nil
+	}
+	if depth <= 0 {
+		return s.quiesce(pos, ply, alpha, beta), nil
+	}
+
+	orderMoves(pos, moves, nil)
+	var bestPV []*chess.Move
+	for _, m := range moves {
+		score, pv := s.negamax(pos.Update(m), depth-1, ply+1, -beta, -alpha)
+		score = -score
+		if s.stopped {
+			return 0, nil
+		}
+		if score >= beta {
+			return beta, nil
+		}
+		if score > alpha {
+			alpha = score
+			bestPV = append([]*chess.Move{m}, pv...)
+		}
+	}
+	return alpha, bestPV
+}
+
+// quiesce searches captures and promotions until the position is quiet,
+// so the static evaluation is not taken in the middle of an exchange.
+func (s *searcher) quiesce(pos *chess.Position, ply, alpha, beta int) int {
+	s.nodes++
+	if s.stop() {
+		return 0
+	}
+
+	standPat := Evaluate(pos)
+	if pos.Turn() == chess.Black {
+		standPat = -standPat
+	}
+	if standPat >= beta {
+		return beta
+	}
+	if standPat > alpha {
+		alpha = standPat
+	}
+
+	moves := slices.DeleteFunc(pos.ValidMoves(), func(m *chess.Move) bool {
+		return !m.HasTag(chess.Capture) && m.Promo() == chess.NoPieceType
+	})
+	orderMoves(pos, moves, nil)
+	for _, m := range moves {
+		score := -s.quiesce(pos.Update(m), ply+1, -beta, -alpha)
+		if s.stopped {
+			return 0
+		}
+		if score >= beta {
+			return beta
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+	return alpha
+}
+
+// orderMoves sorts moves so that those in first come first, in order,
+// then promotions and captures of the most valuable victim by the least
+// valuable attacker. Good moves first means more cutoffs.
+func orderMoves(pos *chess.Position, moves []*chess.Move, first []*chess.Move) {
+	board := pos.Board()
+	key := func(m *chess.Move) int {
+		for i, f := range first {
+			if m.S1() == f.S1() && m.S2() == f.S2() && m.Promo() == f.Promo() {
+				return 1<<20 - i
+			}
+		}
+		var k int
+		if m.Promo() != chess.NoPieceType {
+			k += PieceValue(m.Promo()) * 10
+		}
+		if m.HasTag(chess.Capture) {
+			victim := PieceValue(board.Piece(m.S2()).Type())
+			if m.HasTag(chess.EnPassant) {
+				victim = PieceValue(chess.Pawn)
+			}
+			k += victim*10 - PieceValue(board.Piece(m.S1()).Type())/10 + 1
+		}
+		return k
+	}
+	slices.SortStableFunc(moves, func(a, b *chess.Move) int {
+		return key(b) - key(a)
+	})
+}
@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/notnil/chess"
+)
+
+func position(t *testing.T, fen string) *chess.Position {
+	t.Helper()
+	opt, err := chess.FEN(fen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chess.NewGame(opt).Position()
+}
+
+// legal reports whether m is a legal move in pos.
+func legal(pos *chess.Position, m *chess.Move) bool {
+	for _, v := range pos.ValidMoves() {
+		if v.S1() == m.S1() && v.S2() == m.S2() && v.Promo() == m.Promo() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		depth int
+		move  string // the best move, or with a leading "!" a move to avoid
+		score func(int) bool
+	}{
+		{"mate in one", "6k1/5ppp/8/8/8/8/8/3RK3 w - - 0 1", 3, "d1d8",
+			func(s int) bool { return s == MateScore-1 }},
+		{"mate in two", "k7/8/2K5/8/8/8/8/7R w - - 0 1", 4, "",
+			func(s int) bool { return s == MateScore-3 }},
+		{"mated in one", "k7/8/1K6/8/8/8/8/7R b - - 0 1", 3, "a8b8",
+			func(s int) bool { return s == -MateScore+2 }},
+		{"win a hanging knight", "4k3/8/8/3n4/8/8/8/3RK3 w - - 0 1", 3, "d1d5",
+			func(s int) bool { return s > 300 }},
+		{"don't take a defended pawn with the queen", "4k3/2p5/3p4/8/8/8/8/3QK3 w - - 0 1", 3, "!d1d6",
+			func(s int) bool { return s > 500 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos := position(t, tt.fen)
+			res, err := Search(context.Background(), pos, Options{Depth: tt.depth, MoveTime: time.Minute})
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.move == "":
+			case tt.move[0] == '!' && res.Move.String() == tt.move[1:]:
+				t.Errorf("move = %s, want another", res.Move)
+			case tt.move[0] != '!' && res.Move.String() != tt.move:
+				t.Errorf("move = %s, want %s", res.Move, tt.move)
+			}
+			if !tt.score(res.Score) {
+				t.Errorf("score = %d", res.Score)
+			}
+			if len(res.PV) == 0 || res.PV[0] != res.Move {
+				t.Errorf("PV %v doesn't start with the move %s", res.PV, res.Move)
+			}
+		})
+	}
+}
+
+func TestSearchMultiPV(t *testing.T) {
+	pos := position(t, "4k3/8/8/3n4/8/8/8/3RK3 w - - 0 1")
+	res, err := Search(context.Background(), pos, Options{Depth: 2, MultiPV: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Lines) != 3 {
+		t.Fatalf("got %d lines, want 3", len(res.Lines))
+	}
+	if res.Lines[0].Move != res.Move || res.Lines[0].Score != res.Score {
+		t.Errorf("first line %s %d isn't the best move %s %d", res.Lines[0].Move, res.Lines[0].Score, res.Move, res.Score)
+	}
+	for i := 1; i < len(res.Lines); i++ {
+		if res.Lines[i].Score > res.Lines[i-1].Score {
+			t.Errorf("line %d scores %d, more than line %d's %d", i, res.Lines[i].Score, i-1, res.Lines[i-1].Score)
+		}
+	}
+}
+
+func TestSearchCutShort(t *testing.T) {
+	start := chess.NewGame().Position()
+
+	// Out of time long before the depth is reached.
+	res, err := Search(context.Background(), start, Options{Depth: 50, MoveTime: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Move == nil || !legal(start, res.Move) {
+		t.Fatalf("move %v is not legal", res.Move)
+	}
+	if res.Depth >= 50 {
+		t.Errorf("searched %d plies in 50ms", res.Depth)
+	}
+
+	// Cancelled before the search starts.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err = Search(ctx, start, Options{Depth: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Move == nil || !legal(start, res.Move) {
+		t.Fatalf("move %v is not legal", res.Move)
+	}
+}
+
+func TestSearchNoMoves(t *testing.T) {
+	for _, fen := range []string{
+		"R5k1/5ppp/8/8/8/8/8/4K3 b - - 0 1", // checkmate
+		"k7/2Q5/1K6/8/8/8/8/8 b - - 0 1",    // stalemate
+	} {
+		if _, err := Search(context.Background(), position(t, fen), Options{Depth: 2}); !errors.Is(err, ErrNoMoves) {
+			t.Errorf("%s: got %v, want ErrNoMoves", fen, err)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name string
+		fen  string
+		want func(int) bool
+	}{
+		{"starting position", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", func(s int) bool { return s == 0 }},
+		{"white a knight up", "rnbqkb1r/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", func(s int) bool { return s > 250 }},
+		{"black a rook up", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/1NBQKBNR w Kkq - 0 1", func(s int) bool { return s < -450 }},
+		{"side to move doesn't matter", "rnbqkb1r/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR b KQkq - 0 1", func(s int) bool { return s > 250 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Evaluate(position(t, tt.fen)); !tt.want(got) {
+				t.Errorf("Evaluate = %d", got)
+			}
+		})
+	}
+
+	// Mirrored positions score the same for the other side.
+	white := Evaluate(position(t, "4k3/8/8/8/4P3/2N5/8/4K3 w - - 0 1"))
+	black := Evaluate(position(t, "4k3/8/2n5/4p3/8/8/8/4K3 b - - 0 1"))
+	if white != -black {
+		t.Errorf("mirrored positions score %d and %d", white, black)
+	}
+}
@@ -0,0 +1,157 @@
+package engine
+
+import "github.com/notnil/chess"
+
+// pieceValues are the material values of the pieces in centipawns.
+var pieceValues = [...]int{
+	chess.King:   0,
+	chess.Queen:  900,
+	chess.Rook:   500,
+	chess.Bishop: 330,
+	chess.Knight: 320,
+	chess.Pawn:   100,
+}
+
+// PieceValue returns the material value of a piece type in centipawns.
+// Kings are worth nothing, since they are never traded.
+func PieceValue(t chess.PieceType) int {
+	if int(t) >= len(pieceValues) {
+		return 0
+	}
+	return pieceValues[t]
+}
+
+// Piece-square tables from white's point of view, written as a board
+// is drawn: the first row is the eighth rank. They are the tables of
+// Tomasz Michniewski's "Simplified Evaluation Function".
+var (
+	pawnTable = [64]int{
+		0, 0, 0, 0, 0, 0, 0, 0,
+		50, 50, 50, 50, 50, 50, 50, 50,
+		10, 10, 20, 30, 30, 20, 10, 10,
+		5, 5, 10, 25, 25, 10, 5, 5,
+		0, 0, 0, 20, 20, 0, 0, 0,
+		5, -5, -10, 0, 0, -10, -5, 5,
+		5, 10, 10, -20, -20, 10, 10, 5,
+		0, 0, 0, 0, 0, 0, 0, 0,
+	}
+	knightTable = [64]int{
+		-50, -40, -30, -30, -30, -30, -40, -50,
+		-40, -20, 0, 0, 0, 0, -20, -40,
+		-30, 0, 10, 15, 15, 10, 0, -30,
+		-30, 5, 15, 20, 20, 15, 5, -30,
+		-30, 0, 15, 20, 20, 15, 0, -30,
+		-30, 5, 10, 15, 15, 10, 5, -30,
+		-40, -20, 0, 5, 5, 0, -20, -40,
+		-50, -40, -30, -30, -30, -30, -40, -50,
+	}
+	bishopTable = [64]int{
+		-20, -10, -10, -10, -10, -10, -10, -20,
+		-10, 0, 0, 0, 0, 0, 0, -10,
+		-10, 0, 5, 10, 10, 5, 0, -10,
+		-10, 5, 5, 10, 10, 5, 5, -10,
+		-10, 0, 10, 10, 10, 10, 0, -10,
+		-10, 10, 10, 10, 10, 10, 10, -10,
+		-10, 5, 0, 0, 0, 0, 5, -10,
+		-20, -10, -10, -10, -10, -10, -10, -20,
+	}
+	rookTable = [64]int{
+		0, 0, 0, 0, 0, 0, 0, 0,
+		5, 10, 10, 10, 10, 10, 10, 5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		0, 0, 0, 5, 5, 0, 0, 0,
+	}
+	queenTable = [64]int{
+		-20, -10, -10, -5, -5, -10, -10, -20,
+		-10, 0, 0, 0, 0, 0, 0, -10,
+		-10, 0, 5, 5, 5, 5, 0, -10,
+		-5, 0, 5, 5, 5, 5, 0, -5,
+		0, 0, 5, 5, 5, 5, 0, -5,
+		-10, 5, 5, 5, 5, 5, 0, -10,
+		-10, 0, 5, 0, 0, 0, 0, -10,
+		-20, -10, -10, -5, -5, -10, -10, -20,
+	}
+	kingMiddleTable = [64]int{
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-20, -30, -30, -40, -40, -30, -30, -20,
+		-10, -20, -20, -20, -20, -20, -20, -10,
+		20, 20, 0, 0, 0, 0, 20, 20,
+		20, 30, 10, 0, 0, 10, 30, 20,
+	}
+	kingEndTable = [64]int{
+		-50, -40, -30, -20, -20, -30, -40, -50,
+		-30, -20, -10, 0, 0, -10, -20, -30,
+		-30, -10, 20, 30, 30, 20, -10, -30,
+		-30, -10, 30, 40, 40, 30, -10, -30,
+		-30, -10, 30, 40, 40, 30, -10, -30,
+		-30, -10, 20, 30, 30, 20, -10, -30,
+		-30, -30, 0, 0, 0, 0, -30, -30,
+		-50, -30, -30, -30, -30, -30, -30, -50,
+	}
+)
+
+// endgameMaterial is the non-pawn material at or below which a side is
+// considered to be in the endgame, e.g. a queen and a minor piece.
+const endgameMaterial = 1300
+
+// Evaluate scores a position statically in centipawns from white's
+// point of view: material plus piece placement. Mate and stalemate are
+// left to the search.
+func Evaluate(pos *chess.Position) int {
+	pieces := pos.Board().SquareMap()
+
+	var material [3]int
+	for _, p := range pieces {
+		if p.Type() != chess.Pawn {
+			material[p.Color()] += PieceValue(p.Type())
+		}
+	}
+	endgame := material[chess.White] <= endgameMaterial && material[chess.Black] <= endgameMaterial
+
+	var score int
+	for sq, p := range pieces {
+		v := PieceValue(p.Type()) + placement(p, sq, endgame)
+		if p.Color() == chess.White {
+			score += v
+		} else {
+			score -= v
+		}
+	}
+	return score
+}
+
+// placement returns the piece-square bonus of p standing on sq.
+func placement(p chess.Piece, sq chess.Square, endgame bool) int {
+
+	// The tables start at a8; black reads them mirrored.
+	idx := (7-int(sq.Rank()))*8 + int(sq.File())
+	if p.Color() == chess.Black {
+		idx = int(sq.Rank())*8 + int(sq.File())
+	}
+
+	switch p.Type() {
+	case chess.Pawn:
+		return pawnTable[idx]
+	case chess.Knight:
+		return knightTable[idx]
+	case chess.Bishop:
+		return bishopTable[idx]
+	case chess.Rook:
+		return rookTable[idx]
+	case chess.Queen:
+		return queenTable[idx]
+	case chess.King:
+		if endgame {
+			return kingEndTable[idx]
+		}
+		return kingMiddleTable[idx]
+	}
+	return 0
+}
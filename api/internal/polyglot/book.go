@@ -0,0 +1,163 @@
+package polyglot
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+
+	"github.com/notnil/chess"
+)
+
+// entrySize is the size of a book entry: key, move, weight and learn.
+const entrySize = 16
+
+// entry is a book move for the position with the given key.
+type entry struct {
+	key    uint64
+	move   uint16
+	weight uint16
+}
+
+// Book is an opening book loaded into memory. It is safe for concurrent
+// use.
+type Book struct {
+	entries []entry
+}
+
+// Open loads the book at path.
+func Open(path string) (*Book, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("polyglot: %w", err)
+	}
+	if len(data)%entrySize != 0 {
+		return nil, fmt.Errorf("polyglot: %s is not a Polyglot book, its size is not a multiple of %d", path, entrySize)
+	}
+
+	b := Book{entries: make([]entry, len(data)/entrySize)}
+	for i := range b.entries {
+		e := data[i*entrySize:]
+		b.entries[i] = entry{
+			key:    binary.BigEndian.Uint64(e),
+			move:   binary.BigEndian.Uint16(e[8:]),
+			weight: binary.BigEndian.Uint16(e[10:]),
+		}
+	}
+
+	// Books are sorted by key, which the lookups rely on.
+	if !slices.IsSortedFunc(b.entries, func(a, b entry) int {
+		return compareKeys(a.key, b.key)
+	}) {
+		return nil, fmt.Errorf("polyglot: %s is not sorted by key", path)
+	}
+
+	return &b, nil
+}
+
+// Len returns the number of entries in the book.
+func (b *Book) Len() int {
+	return len(b.entries)
+}
+
+// Move is a book move and its weight.
+type Move struct {
+	Move   *chess.Move
+	Weight int
+}
+
+// Moves returns the legal book moves for pos, heaviest first. Moves
+// with no weight are left out, as books use them to mark moves not to
+// play.
+func (b *Book) Moves(pos *chess.Position) []Move {
+	key := Random64.Hash(pos)
+	i, _ := slices.BinarySearchFunc(b.entries, key, func(e entry, key uint64) int {
+		return compareKeys(e.key, key)
+	})
+
+	var moves []Move
+	for ; i < len(b.entries) && b.entries[i].key == key; i++ {
+		e := b.entries[i]
+		if e.weight == 0 {
+			continue
+		}
+		if m := decodeMove(pos, e.move); m != nil {
+			moves = append(moves, Move{Move: m, Weight: int(e.weight)})
+		}
+	}
+	slices.SortStableFunc(moves, func(a, b Move) int {
+		return b.Weight - a.Weight
+	})
+	return moves
+}
+
+// Pick chooses a book move for pos, or returns nil if the position is
+// not in the book. With a variety of 0 it plays the heaviest move; with
+// 1 it picks in proportion to the weights, and values in between favour
+// the heavier moves more the closer they are to 0.
+func (b *Book) Pick(pos *chess.Position, variety float64) *chess.Move {
+	moves := b.Moves(pos)
+	if len(moves) == 0 {
+		return nil
+	}
+	if variety <= 0 {
+		return moves[0].Move
+	}
+
+	weights := make([]float64, len(moves))
+	var total float64
+	for i, m := range moves {
+		weights[i] = math.Pow(float64(m.Weight), 1/min(variety, 1))
+		total += weights[i]
+	}
+	r := rand.Float64() * total
+	for i, w := range weights {
+		if r < w {
+			return moves[i].Move
+		}
+		r -= w
+	}
+	return moves[len(moves)-1].Move
+}
+
+// decodeMove finds the legal move of pos that a book move stands for.
+// Book moves are packed as to-file, to-rank, from-file, from-rank and
+// promotion in three bits each, and castling is written as the king
+// taking its own rook.
+func decodeMove(pos *chess.Position, move uint16) *chess.Move {
+	to := chess.Square((move>>3&7)*8 + move&7)
+	from := chess.Square((move>>9&7)*8 + move>>6&7)
+	promo := [...]chess.PieceType{chess.NoPieceType, chess.Knight, chess.Bishop, chess.Rook, chess.Queen, chess.NoPieceType, chess.NoPieceType, chess.NoPieceType}[move>>12&7]
+
+	if pos.Board().Piece(from).Type() == chess.King {
+		switch {
+		case from == chess.E1 && to == chess.H1:
+			to = chess.G1
+		case from == chess.E1 && to == chess.A1:
+			to = chess.C1
+		case from == chess.E8 && to == chess.H8:
+			to = chess.G8
+		case from == chess.E8 && to == chess.A8:
+			to = chess.C8
+		}
+	}
+
+	for _, m := range pos.ValidMoves() {
+		if m.S1() == from && m.S2() == to && m.Promo() == promo {
+			return m
+		}
+	}
+	return nil
+}
+
+func compareKeys(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,161 @@
+package polyglot
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+// testdata/book.bin holds, for the starting position, e2e4 with weight
+// 30, d2d4 with 10 and g1f3 with 0, and after e2e4, c7c5 with 5 and e7e5
+// with 15.
+func openBook(t *testing.T) *Book {
+	t.Helper()
+	b, err := Open("testdata/book.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func position(t *testing.T, fen string) *chess.Position {
+	t.Helper()
+	opt, err := chess.FEN(fen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chess.NewGame(opt).Position()
+}
+
+// encode packs a book move the way Polyglot books store it.
+func encode(from, to chess.Square, promo uint16) uint16 {
+	return uint16(to.File()) | uint16(to.Rank())<<3 | uint16(from.File())<<6 | uint16(from.Rank())<<9 | promo<<12
+}
+
+func TestOpen(t *testing.T) {
+	if b := openBook(t); b.Len() != 5 {
+		t.Errorf("Len = %d, want 5", b.Len())
+	}
+
+	dir := t.TempDir()
+	data, err := os.ReadFile("testdata/book.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(dir, "truncated.bin")
+	if err := os.WriteFile(truncated, data[:len(data)-1], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(truncated); err == nil {
+		t.Error("opened a truncated book")
+	}
+	unsorted := filepath.Join(dir, "unsorted.bin")
+	if err := os.WriteFile(unsorted, append(data[len(data)-entrySize:], data[:len(data)-entrySize]...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(unsorted); err == nil {
+		t.Error("opened a book not sorted by key")
+	}
+}
+
+func TestMoves(t *testing.T) {
+	b := openBook(t)
+	tests := []struct {
+		name  string
+		moves string
+		want  []string
+	}{
+		{"heaviest first, no weight left out", "", []string{"e2e4", "d2d4"}},
+		{"black to move", "e2e4", []string{"e7e5", "c7c5"}},
+		{"not in the book", "d2d4", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, m := range b.Moves(play(t, tt.moves)) {
+				got = append(got, m.Move.String())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Moves = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Moves = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPick(t *testing.T) {
+	b := openBook(t)
+	start := play(t, "")
+
+	if m := b.Pick(play(t, "d2d4"), 1); m != nil {
+		t.Errorf("picked %s in a position not in the book", m)
+	}
+	for range 100 {
+		if m := b.Pick(start, 0); m.String() != "e2e4" {
+			t.Fatalf("picked %s with no variety, want e2e4", m)
+		}
+	}
+
+	// e2e4 weighs 30 and d2d4 10, so with a variety of 1 e2e4 comes up
+	// 3 times in 4 and with 0.5, the weights squared, 9 times in 10.
+	const n = 4000
+	for _, tt := range []struct {
+		variety float64
+		want    float64
+	}{
+		{1, 0.75},
+		{0.5, 0.9},
+	} {
+		var e4 int
+		for range n {
+			switch m := b.Pick(start, tt.variety); m.String() {
+			case "e2e4":
+				e4++
+			case "d2d4":
+			default:
+				t.Fatalf("picked %s, which has no weight", m)
+			}
+		}
+		if got := float64(e4) / n; math.Abs(got-tt.want) > 0.03 {
+			t.Errorf("variety %v: picked e2e4 %.3f of the time, want about %.2f", tt.variety, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeMove(t *testing.T) {
+	tests := []struct {
+		name string
+		fen  string
+		move uint16
+		want string // "" for no legal move
+	}{
+		{"white castles king side", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", encode(chess.E1, chess.H1, 0), "e1g1"},
+		{"white castles queen side", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", encode(chess.E1, chess.A1, 0), "e1c1"},
+		{"black castles king side", "r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", encode(chess.E8, chess.H8, 0), "e8g8"},
+		{"black castles queen side", "r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", encode(chess.E8, chess.A8, 0), "e8c8"},
+		{"king steps beside its rook", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", encode(chess.E1, chess.F1, 0), "e1f1"},
+		{"rook from the king's square", "4k3/8/8/8/8/8/8/K3R3 w - - 0 1", encode(chess.E1, chess.H1, 0), "e1h1"},
+		{"castling rights lost", "r3k2r/8/8/8/8/8/8/R3K2R w kq - 0 1", encode(chess.E1, chess.H1, 0), ""},
+		{"promotion to a knight", "8/P7/8/8/8/8/8/k6K w - - 0 1", encode(chess.A7, chess.A8, 1), "a7a8n"},
+		{"promotion to a queen", "8/P7/8/8/8/8/8/k6K w - - 0 1", encode(chess.A7, chess.A8, 4), "a7a8q"},
+		{"illegal move", "4k3/8/8/8/8/8/8/4K3 w - - 0 1", encode(chess.E1, chess.E3, 0), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			if m := decodeMove(position(t, tt.fen), tt.move); m != nil {
+				got = m.String()
+			}
+			if got != tt.want {
+				t.Errorf("decodeMove = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
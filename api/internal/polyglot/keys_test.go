@@ -0,0 +1,51 @@
+package polyglot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+// play returns the position after the moves, given in UCI notation.
+func play(t *testing.T, moves string) *chess.Position {
+	t.Helper()
+	game := chess.NewGame()
+	for _, s := range strings.Fields(moves) {
+		m, err := chess.UCINotation{}.Decode(game.Position(), s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := game.Move(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return game.Position()
+}
+
+// TestHash checks the keys of the positions in the format's
+// specification.
+func TestHash(t *testing.T) {
+	tests := []struct {
+		name  string
+		moves string
+		want  uint64
+	}{
+		{"starting position", "", StartKey},
+		{"en passant square nothing can take on", "e2e4", 0x823c9b50fd114196},
+		{"en passant square nothing can take on, black", "e2e4 d7d5", 0x0756b94461c50fb0},
+		{"no en passant square", "e2e4 d7d5 e4e5", 0x662fafb965db29d4},
+		{"en passant capture possible", "e2e4 d7d5 e4e5 f7f5", 0x22a48b5a8e47ff78},
+		{"white lost its castling rights", "e2e4 d7d5 e4e5 f7f5 e1e2", 0x652a607ca3f242c1},
+		{"no castling rights", "e2e4 d7d5 e4e5 f7f5 e1e2 e8f7", 0x00fdd303c946bdd9},
+		{"en passant capture possible for black", "a2a4 b7b5 h2h4 b5b4 c2c4", 0x3c8123ea7b067637},
+		{"white lost a castling side", "a2a4 b7b5 h2h4 b5b4 c2c4 b4c3 a1a3", 0x5c3f9b829b279560},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Random64.Hash(play(t, tt.moves)); got != tt.want {
+				t.Errorf("Hash = %016x, want %016x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package syzygy
+
+import (
+	"slices"
+
+	"github.com/notnil/chess"
+)
+
+// Tables for turning positions into table indices. Squares are numbered
+// rank*8+file, as in the chess package.
+var (
+	// mapPawns numbers the squares a2-h7 from 47 down, edge files and
+	// low ranks first. The pawn with the highest number leads.
+	mapPawns [64]int
+
+	// mapB1H1H7 numbers the 28 squares below the a1-h8 diagonal.
+	mapB1H1H7 [64]int
+
+	// mapA1D1D4 numbers the squares of the a1-d1-d4 triangle, the
+	// diagonal squares last.
+	mapA1D1D4 [64]int
+
+	// mapKK numbers the 462 legal placements of two kings with the
+	// first in the a1-d1-d4 triangle, by the first king's mapA1D1D4
+	// number and the second king's square.
+	mapKK [10][64]int
+
+	// binomial[k][n] is the number of ways to choose k of n squares.
+	binomial [6][64]uint64
+
+	// leadPawnIdx and leadPawnsSize encode the leading pawns, by their
+	// count and, respectively, the square of the first one or its file.
+	leadPawnIdx   [6][64]uint64
+	leadPawnsSize [6][4]uint64
+)
+
+func init() {
+	var code int
+	for sq := 0; sq < 64; sq++ {
+		if offDiagonal(sq) < 0 {
+			mapB1H1H7[sq] = code
+			code++
+		}
+	}
+
+	var diagonal []int
+	code = 0
+	for sq := 0; sq < 28; sq++ {
+		switch {
+		case sq&7 > 3:
+		case offDiagonal(sq) < 0:
+			mapA1D1D4[sq] = code
+			code++
+		case offDiagonal(sq) == 0:
+			diagonal = append(diagonal, sq)
+		}
+	}
+	for _, sq := range diagonal {
+		mapA1D1D4[sq] = code
+		code++
+	}
+
+	// Placements with both kings on the diagonal come last.
+	type placement struct{ idx, sq int }
+	var bothOnDiagonal []placement
+	code = 0
+	for idx := 0; idx < 10; idx++ {
+		for s1 := 0; s1 < 28; s1++ {
+			if mapA1D1D4[s1] != idx || (idx == 0 && s1 != int(chess.B1)) {
+				continue
+			}
+			for s2 := 0; s2 < 64; s2++ {
+				switch {
+				case distance(s1, s2) <= 1:
+				case offDiagonal(s1) == 0 && offDiagonal(s2) > 0:
+				case offDiagonal(s1) == 0 && offDiagonal(s2) == 0:
+					bothOnDiagonal = append(bothOnDiagonal, placement{idx, s2})
+				default:
+					mapKK[idx][s2] = code
+					code++
+				}
+			}
+		}
+	}
+	for _, p := range bothOnDiagonal {
+		mapKK[p.idx][p.sq] = code
+		code++
+	}
+
+	binomial[0][0] = 1
+	for n := 1; n < 64; n++ {
+		for k := 0; k < 6 && k <= n; k++ {
+			if k > 0 {
+				binomial[k][n] += binomial[k-1][n-1]
+			}
+			if k < n {
+				binomial[k][n] += binomial[k][n-1]
+			}
+		}
+	}
+
+	available := 47
+	for count := 1; count <= 5; count++ {
+		for f := 0; f < 4; f++ {
+			var idx uint64
+			for r := 1; r <= 6; r++ {
+				sq := r*8 + f
+				if count == 1 {
+					mapPawns[sq] = available
+					mapPawns[sq^7] = available - 1
+					available -= 2
+				}
+				leadPawnIdx[count][sq] = idx
+				idx += binomial[count-1][mapPawns[sq]]
+			}
+			leadPawnsSize[count][f] = idx
+		}
+	}
+}
+
+// offDiagonal returns how far sq is above the a1-h8 diagonal.
+func offDiagonal(sq int) int {
+	return sq>>3 - sq&7
+}
+
+// distance returns the number of king moves between two squares.
+func distance(a, b int) int {
+	return max(abs(a>>3-b>>3), abs(a&7-b&7))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// pieceCode returns the code of a piece in table files: 1 to 6 for the
+// white pawn, knight, bishop, rook, queen and king, plus 8 for black.
+func pieceCode(p chess.Piece) uint8 {
+	var code uint8
+	switch p.Type() {
+	case chess.Pawn:
+		code = 1
+	case chess.Knight:
+		code = 2
+	case chess.Bishop:
+		code = 3
+	case chess.Rook:
+		code = 4
+	case chess.Queen:
+		code = 5
+	case chess.King:
+		code = 6
+	}
+	if p.Color() == chess.Black {
+		code |= 8
+	}
+	return code
+}
+
+// index returns the pairs data and index of pos in table file tf of t,
+// or ok false if tf only holds the other side to move.
+func (t *table) index(tf *tableFile, pos *chess.Position) (d *pairsData, file int, idx uint64, ok bool) {
+	var squares [maxPieces]int
+	var pieces [maxPieces]uint8
+	board := pos.Board()
+
+	// Tables hold positions with the stronger side, named first, as
+	// white. Symmetric tables only hold white to move. Anything else is
+	// looked up with the colors swapped and the board flipped.
+	black := pos.Turn() == chess.Black
+	flip := (t.symmetric && black) || materialName(board) != t.name
+	var flipColor uint8
+	var flipSquares int
+	stm := 0
+	if flip {
+		flipColor, flipSquares = 8, 56
+	}
+	if flip != black {
+		stm = 1
+	}
+
+	// Tables with pawns are split by the file of the leading pawn,
+	// mirrored onto files a to d.
+	var size, leadPawns int
+	var isLeadPawn [64]bool
+	if t.hasPawns {
+		lead := tf.get(0, 0).pieces[0] ^ flipColor
+		for sq := 0; sq < 64; sq++ {
+			if p := board.Piece(chess.Square(sq)); p != chess.NoPiece && pieceCode(p) == lead {
+				isLeadPawn[sq] = true
+				squares[size] = sq ^ flipSquares
+				size++
+			}
+		}
+		leadPawns = size
+		first := 0
+		for i := 1; i < leadPawns; i++ {
+			if mapPawns[squares[i]] > mapPawns[squares[first]] {
+				first = i
+			}
+		}
+		squares[0], squares[first] = squares[first], squares[0]
+		file = min(squares[0]&7, 7-squares[0]&7)
+	}
+
+	// DTZ tables only hold one side to move.
+	if tf.kind == dtzKind && tf.get(stm, file).flags&flagSTM != uint8(stm) && !(t.symmetric && !t.hasPawns) {
+		return nil, 0, 0, false
+	}
+
+	for sq := 0; sq < 64; sq++ {
+		p := board.Piece(chess.Square(sq))
+		if p == chess.NoPiece || isLeadPawn[sq] {
+			continue
+		}
+		squares[size] = sq ^ flipSquares
+		pieces[size] = pieceCode(p) ^ flipColor
+		size++
+	}
+
+	// Put the pieces in the order of the table.
+	d = tf.get(stm, file)
+	for i := leadPawns; i < size-1; i++ {
+		for j := i + 1; j < size; j++ {
+			if d.pieces[i] == pieces[j] {
+				pieces[i], pieces[j] = pieces[j], pieces[i]
+				squares[i], squares[j] = squares[j], squares[i]
+				break
+			}
+		}
+	}
+
+	// Mirror the board so the leading piece is on files a to d.
+	if squares[0]&7 > 3 {
+		for i := range size {
+			squares[i] ^= 7
+		}
+	}
+
+	if t.hasPawns {
+		idx = leadPawnIdx[leadPawns][squares[0]]
+		slices.SortStableFunc(squares[1:leadPawns], func(a, b int) int {
+			return mapPawns[a] - mapPawns[b]
+		})
+		for i := 1; i < leadPawns; i++ {
+			idx += binomial[i][mapPawns[squares[i]]]
+		}
+	} else {
+		idx = t.leadingPieces(d, squares[:size])
+	}
+
+	// Encode the other groups of pieces, each square counted among the
+	// squares left free by the groups before it.
+	idx *= d.groupIdx[0]
+	start := d.groupLen[0]
+	remainingPawns := t.hasPawns && t.bothPawns
+	for next := 1; d.groupLen[next] != 0; next++ {
+		group := squares[start : start+d.groupLen[next]]
+		slices.Sort(group)
+		var n uint64
+		for i, sq := range group {
+			adjust := 0
+			for _, s := range squares[:start] {
+				if sq > s {
+					adjust++
+				}
+			}
+			if remainingPawns {
+				adjust += 8
+			}
+			n += binomial[i+1][sq-adjust]
+		}
+		remainingPawns = false
+		idx += n * d.groupIdx[next]
+		start += d.groupLen[next]
+	}
+
+	return d, file, idx, true
+}
+
+// leadingPieces encodes the leading group of a table without pawns: the
+// two kings, or three unique pieces if the table has any. It mirrors the
+// board so the first piece is in the a1-d1-d4 triangle and the first
+// piece off the a1-h8 diagonal is below it.
+func (t *table) leadingPieces(d *pairsData, squares []int) uint64 {
+	if squares[0]>>3 > 3 {
+		for i := range squares {
+			squares[i] ^= 56
+		}
+	}
+	for i := 0; i < d.groupLen[0]; i++ {
+		if offDiagonal(squares[i]) == 0 {
+			continue
+		}
+		if offDiagonal(squares[i]) > 0 {
+			for j := i; j < len(squares); j++ {
+				squares[j] = (squares[j]>>3 | squares[j]<<3) & 63
+			}
+		}
+		break
+	}
+
+	if !t.hasUniquePieces {
+		return uint64(mapKK[mapA1D1D4[squares[0]]][squares[1]])
+	}
+
+	s0, s1, s2 := squares[0], squares[1], squares[2]
+	adjust1 := b2i(s1 > s0)
+	adjust2 := b2i(s2 > s0) + b2i(s2 > s1)
+	var idx int
+	switch {
+	case offDiagonal(s0) != 0:
+		idx = (mapA1D1D4[s0]*63+s1-adjust1)*62 + s2 - adjust2
+	case offDiagonal(s1) != 0:
+		idx = (6*63+(s0>>3)*28+mapB1H1H7[s1])*62 + s2 - adjust2
+	case offDiagonal(s2) != 0:
+		idx = 6*63*62 + 4*28*62 + (s0>>3)*7*28 + (s1>>3-adjust1)*28 + mapB1H1H7[s2]
+	default:
+		idx = 6*63*62 + 4*28*62 + 4*7*28 + (s0>>3)*7*6 + (s1>>3-adjust1)*6 + s2>>3 - adjust2
+	}
+	return uint64(idx)
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
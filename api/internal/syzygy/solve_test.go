@@ -0,0 +1,182 @@
+package syzygy
+
+import (
+	"encoding/binary"
+
+	"github.com/notnil/chess"
+)
+
+// solution is the result of every position of a king and piece against
+// a lone king, KQvK or KRvK, solved by retrograde analysis without the
+// table code: plies is the number of plies to mate with best play, and
+// zero for the mated side, or -1 for a draw.
+type solution struct {
+	piece chess.PieceType
+	plies []int16
+}
+
+// key numbers a position by the side to move (0 for white) and the
+// squares of the white king, the white piece and the black king.
+func key(stm, wk, wp, bk int) int {
+	return ((stm*64+wk)*64+wp)*64 + bk
+}
+
+// unkey undoes key.
+func unkey(k int) (stm, wk, wp, bk int) {
+	return k >> 18, k >> 12 & 63, k >> 6 & 63, k & 63
+}
+
+// attacks reports whether a queen or rook on from attacks to, with the
+// king on block standing in the way.
+func attacks(piece chess.PieceType, from, to, block int) bool {
+	df, dr := to&7-from&7, to>>3-from>>3
+	if from == to || (df != 0 && dr != 0 && (piece == chess.Rook || abs(df) != abs(dr))) {
+		return false
+	}
+	step := sign(dr)*8 + sign(df)
+	for sq := from + step; sq != to; sq += step {
+		if sq == block {
+			return false
+		}
+	}
+	return true
+}
+
+// legal reports whether the side not to move is out of check and the
+// pieces are on different squares.
+func (s *solution) legal(stm, wk, wp, bk int) bool {
+	if wk == wp || wk == bk || wp == bk || distance(wk, bk) <= 1 {
+		return false
+	}
+	return stm == 1 || !attacks(s.piece, wp, bk, wk)
+}
+
+// moves returns the keys of the positions after each legal move, with
+// -1 for black taking the piece, which draws.
+func (s *solution) moves(stm, wk, wp, bk int) []int {
+	var next []int
+	if stm == 0 {
+		for sq := range 64 {
+			if distance(wk, sq) == 1 && sq != wp && distance(sq, bk) > 1 {
+				next = append(next, key(1, sq, wp, bk))
+			}
+			if sq != wk && sq != bk && attacks(s.piece, wp, sq, wk) && attacks(s.piece, wp, sq, bk) {
+				next = append(next, key(1, wk, sq, bk))
+			}
+		}
+		return next
+	}
+	for sq := range 64 {
+		if distance(bk, sq) != 1 || distance(sq, wk) <= 1 {
+			continue
+		}
+		switch {
+		case sq == wp:
+			next = append(next, -1)
+		case !attacks(s.piece, wp, sq, wk):
+			next = append(next, key(0, wk, wp, sq))
+		}
+	}
+	return next
+}
+
+// solve solves KXvK for piece X, mating in rounds: white mates in n if a
+// move leads to a black loss in n-1, and black loses in n if every move
+// leads to a white mate in at most n-1.
+func solve(piece chess.PieceType) *solution {
+	s := &solution{piece: piece, plies: make([]int16, 2*64*64*64)}
+	succ := make([][]int, len(s.plies))
+	for k := range s.plies {
+		s.plies[k] = -1
+		stm, wk, wp, bk := unkey(k)
+		if !s.legal(stm, wk, wp, bk) {
+			continue
+		}
+		succ[k] = s.moves(stm, wk, wp, bk)
+		if stm == 1 && len(succ[k]) == 0 && attacks(piece, wp, bk, wk) {
+			s.plies[k] = 0
+		}
+	}
+
+	for n := int16(1); ; n++ {
+		changed := false
+		for k, next := range succ {
+			if s.plies[k] >= 0 || len(next) == 0 {
+				continue
+			}
+			if k>>18 == 0 {
+				for _, nk := range next {
+					if s.plies[nk] == n-1 {
+						s.plies[k] = n
+						changed = true
+						break
+					}
+				}
+				continue
+			}
+			lost := true
+			for _, nk := range next {
+				if nk < 0 || s.plies[nk] < 0 || s.plies[nk] >= n {
+					lost = false
+					break
+				}
+			}
+			if lost {
+				s.plies[k] = n
+				changed = true
+			}
+		}
+		if !changed {
+			return s
+		}
+	}
+}
+
+// position returns the position with key k, with the colors swapped if
+// flip is set. It is built from the chess package's binary encoding,
+// which is much quicker to decode than FEN: twelve bitboards, white's
+// king to black's pawns with a1 the highest bit, then the counters, the
+// en passant square and the flags, which hold the side to move.
+func (s *solution) position(k int, flip bool) *chess.Position {
+	stm, wk, wp, bk := unkey(k)
+	white, black := 0, 6
+	if flip {
+		white, black = black, white
+		stm ^= 1
+		wk, wp, bk = wk^56, wp^56, bk^56
+	}
+	pieceBoard := map[chess.PieceType]int{chess.Queen: 1, chess.Rook: 2}[s.piece]
+
+	data := make([]byte, 101)
+	for _, p := range []struct{ board, sq int }{{white, wk}, {white + pieceBoard, wp}, {black, bk}} {
+		bb := binary.BigEndian.Uint64(data[8*p.board:]) | 1<<(63-p.sq)
+		binary.BigEndian.PutUint64(data[8*p.board:], bb)
+	}
+	data[98] = 1
+	data[100] = byte(stm << 4)
+
+	var pos chess.Position
+	if err := pos.UnmarshalBinary(data); err != nil {
+		panic(err)
+	}
+	return &pos
+}
+
+// fen returns the FEN of the position with key k, with the colors
+// swapped if flip is set.
+func (s *solution) fen(k int, flip bool) string {
+	return s.position(k, flip).String()
+}
+
+// result returns the WDL and DTZ of the position with key k, the way
+// the tables give them: a mated side has DTZ -1 rather than zero.
+func (s *solution) result(k int) (WDL, int) {
+	switch plies := int(s.plies[k]); {
+	case plies < 0:
+		return Draw, 0
+	case k>>18 == 0:
+		return Win, plies
+	default:
+		return Loss, -max(plies, 1)
+	}
+}
@@ -0,0 +1,447 @@
+// Package syzygy probes Syzygy endgame tablebases: the WDL tables, which
+// give the result of a position with perfect play, and the DTZ tables,
+// which give the distance to the next capture or pawn move on the way
+// there. Following DTZ wins any won position within the 50-move rule.
+//
+// The probing code follows the one in Stockfish, which reads the tables
+// the way their generator writes them.
+package syzygy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+// maxPieces is the most pieces, kings included, a table can have.
+const maxPieces = 7
+
+// ErrNotFound is returned for positions the tablebase doesn't cover.
+var ErrNotFound = errors.New("syzygy: position not in the tablebase")
+
+// WDL is the result of a position for the side to move. Cursed wins and
+// blessed losses are wins and losses the 50-move rule turns into draws.
+type WDL int
+
+// Results of a position.
+const (
+	Loss        WDL = -2
+	BlessedLoss WDL = -1
+	Draw        WDL = 0
+	CursedWin   WDL = 1
+	Win         WDL = 2
+)
+
+// String returns the name of the result, e.g. "cursed_win".
+func (w WDL) String() string {
+	switch w {
+	case Loss:
+		return "loss"
+	case BlessedLoss:
+		return "blessed_loss"
+	case Draw:
+		return "draw"
+	case CursedWin:
+		return "cursed_win"
+	case Win:
+		return "win"
+	}
+	return fmt.Sprintf("WDL(%d)", int(w))
+}
+
+// tableName matches the name of a table file, e.g. KRPvKR.
+var tableName = regexp.MustCompile(`^K[QRBNP]*vK[QRBNP]*$`)
+
+// Tablebase is a set of tables. Tables are read on first use. It is safe
+// for concurrent use.
+type Tablebase struct {
+	tables    map[string]*table
+	count     int
+	maxPieces int
+}
+
+// Open finds the tables in dirs, a list of directories separated like
+// PATH. A table needs its WDL file; without its DTZ file it can still
+// tell the result of a position, but not the move to play.
+func Open(dirs string) (*Tablebase, error) {
+	tb := Tablebase{tables: make(map[string]*table)}
+	var dtz []string
+	for _, dir := range filepath.SplitList(dirs) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("syzygy: %w", err)
+		}
+		for _, e := range entries {
+			name, ext := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name())), filepath.Ext(e.Name())
+			if !tableName.MatchString(name) || len(name)-1 > maxPieces {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			switch ext {
+			case extensions[wdlKind]:
+				if _, ok := tb.tables[name]; !ok {
+					tb.add(newTable(name, path))
+				}
+			case extensions[dtzKind]:
+				dtz = append(dtz, path)
+			}
+		}
+	}
+	for _, path := range dtz {
+		if t, ok := tb.tables[filepath.Base(path)]; ok && t.paths[dtzKind] == "" {
+			t.paths[dtzKind] = path + extensions[dtzKind]
+		}
+	}
+	if tb.count == 0 {
+		return nil, fmt.Errorf("syzygy: no tables in %s", dirs)
+	}
+
+	return &tb, nil
+}
+
+// newTable returns the table with the given name, such as KRPvKR.
+func newTable(name, path string) *table {
+	white, black, _ := strings.Cut(name, "v")
+	t := table{
+		name:      name,
+		pieces:    len(white) + len(black),
+		hasPawns:  strings.Contains(name, "P"),
+		bothPawns: strings.Contains(white, "P") && strings.Contains(black, "P"),
+		symmetric: white == black,
+	}
+	t.paths[wdlKind] = path + extensions[wdlKind]
+	for _, side := range []string{white, black} {
+		for _, p := range "QRBNP" {
+			if strings.Count(side, string(p)) == 1 {
+				t.hasUniquePieces = true
+			}
+		}
+	}
+	return &t
+}
+
+// add adds t under its name and that of its mirror image, KRvK and KvKR.
+func (tb *Tablebase) add(t *table) {
+	white, black, _ := strings.Cut(t.name, "v")
+	tb.tables[t.name] = t
+	tb.tables[black+"v"+white] = t
+	tb.count++
+	tb.maxPieces = max(tb.maxPieces, t.pieces)
+}
+
+// Len returns the number of tables.
+func (tb *Tablebase) Len() int {
+	return tb.count
+}
+
+// MaxPieces returns the most pieces, kings included, of the tables.
+func (tb *Tablebase) MaxPieces() int {
+	return tb.maxPieces
+}
+
+// Close closes the table files.
+func (tb *Tablebase) Close() error {
+	for name, t := range tb.tables {
+		if name == t.name {
+			t.close()
+		}
+	}
+	return nil
+}
+
+// Covers reports whether pos has few enough pieces for the tablebase.
+// Positions with castling rights are never covered. Covers doesn't
+// check that the tables needed are there.
+func (tb *Tablebase) Covers(pos *chess.Position) bool {
+	rights := pos.CastleRights()
+	for _, c := range []chess.Color{chess.White, chess.Black} {
+		if rights.CanCastle(c, chess.KingSide) || rights.CanCastle(c, chess.QueenSide) {
+			return false
+		}
+	}
+	return len(pos.Board().SquareMap()) <= tb.maxPieces
+}
+
+// ProbeWDL returns the result of pos for the side to move.
+func (tb *Tablebase) ProbeWDL(pos *chess.Position) (WDL, error) {
+	if !tb.Covers(pos) {
+		return 0, ErrNotFound
+	}
+	wdl, _, err := tb.search(pos, false)
+	return wdl, err
+}
+
+// ProbeDTZ returns the number of plies to the next capture or pawn move
+// with best play, positive if the side to move wins and negative if it
+// loses. Cursed wins and blessed losses are 100 plies further away than
+// their distance; draws are zero.
+func (tb *Tablebase) ProbeDTZ(pos *chess.Position) (int, error) {
+	if !tb.Covers(pos) {
+		return 0, ErrNotFound
+	}
+	return tb.probeDTZ(pos)
+}
+
+// Move is the tablebase's choice of move. WDL is the result of the
+// position before the move and DTZ the distance to zeroing after it,
+// counting the move itself.
+type Move struct {
+	Move *chess.Move
+	WDL  WDL
+	DTZ  int
+}
+
+// BestMove returns the move that keeps the best result for the side to
+// move: when winning, the one nearest to zeroing the 50-move counter,
+// and when losing, the one furthest from it. Mates come first.
+func (tb *Tablebase) BestMove(pos *chess.Position) (Move, error) {
+	wdl, err := tb.ProbeWDL(pos)
+	if err != nil {
+		return Move{}, err
+	}
+
+	best := Move{WDL: wdl}
+	bestRank := math.MinInt
+	for _, m := range pos.ValidMoves() {
+		next := pos.Update(m)
+		var dtz, rank int
+		switch {
+		case next.Status() == chess.Checkmate:
+			dtz, rank = 1, math.MaxInt
+		case zeroing(pos, m):
+			v, err := tb.ProbeWDL(next)
+			if err != nil {
+				return Move{}, err
+			}
+			dtz = dtzBeforeZeroing(-v)
+		default:
+			d, err := tb.ProbeDTZ(next)
+			if err != nil {
+				return Move{}, err
+			}
+			dtz = -d + sign(-d)
+		}
+		if rank == 0 {
+			rank = dtzRank(dtz)
+		}
+		if rank > bestRank {
+			best.Move, best.DTZ, bestRank = m, dtz, rank
+		}
+	}
+	if best.Move == nil {
+		return Move{}, errors.New("syzygy: no legal moves")
+	}
+
+	// A move that doesn't keep the result means the tables disagree
+	// with each other.
+	if sign(best.DTZ) != sign(int(wdl)) {
+		return Move{}, fmt.Errorf("syzygy: %s is %s but its best move %s has DTZ %d", pos, wdl, best.Move, best.DTZ)
+	}
+	return best, nil
+}
+
+// dtzRank orders moves by the DTZ after them: the quickest wins, then
+// draws, then the slowest losses.
+func dtzRank(dtz int) int {
+	const limit = 1 << 20
+	switch {
+	case dtz > 0:
+		return limit - dtz
+	case dtz < 0:
+		return -limit - dtz
+	}
+	return 0
+}
+
+// search returns the result of pos. Tables may store any value for
+// positions where a capture is best, so captures are searched and the
+// best of them and the stored value wins. When checkZeroing is set pawn
+// moves are searched as well, for DTZ tables, which don't store values
+// where a capture or pawn move is best. zeroingBest reports whether the
+// result is reached by such a move.
+func (tb *Tablebase) search(pos *chess.Position, checkZeroing bool) (wdl WDL, zeroingBest bool, err error) {
+	moves := pos.ValidMoves()
+	best := Loss
+	var searched int
+	for _, m := range moves {
+		if !isCapture(m) && (!checkZeroing || !zeroing(pos, m)) {
+			continue
+		}
+		searched++
+
+		v, _, err := tb.search(pos.Update(m), false)
+		if err != nil {
+			return 0, false, err
+		}
+		if v = -v; v > best {
+			best = v
+			if v >= Win {
+				return v, true, nil
+			}
+		}
+	}
+
+	// With every move searched, the stored value doesn't matter.
+	noMoreMoves := searched > 0 && searched == len(moves)
+	value := best
+	if !noMoreMoves {
+		v, _, err := tb.probeTable(pos, wdlKind, Draw)
+		if err != nil {
+			return 0, false, err
+		}
+		value = WDL(v)
+	}
+
+	if best >= value {
+		return best, best > Draw || noMoreMoves, nil
+	}
+	return value, false, nil
+}
+
+func (tb *Tablebase) probeDTZ(pos *chess.Position) (int, error) {
+	wdl, zeroingBest, err := tb.search(pos, true)
+	if err != nil || wdl == Draw {
+		return 0, err
+	}
+	if zeroingBest {
+		return dtzBeforeZeroing(wdl), nil
+	}
+
+	dtz, changeSTM, err := tb.probeTable(pos, dtzKind, wdl)
+	if err != nil {
+		return 0, err
+	}
+	if !changeSTM {
+		if wdl == CursedWin || wdl == BlessedLoss {
+			dtz += 100
+		}
+		return dtz * sign(int(wdl)), nil
+	}
+
+	// The table only holds the other side to move, so look one move
+	// ahead for the move that keeps the result soonest, or when losing
+	// latest.
+	minDTZ := math.MaxInt
+	for _, m := range pos.ValidMoves() {
+		next := pos.Update(m)
+		var dtz int
+		if zeroing(pos, m) {
+			v, _, err := tb.search(next, false)
+			if err != nil {
+				return 0, err
+			}
+			dtz = -dtzBeforeZeroing(v)
+		} else {
+			d, err := tb.probeDTZ(next)
+			if err != nil {
+				return 0, err
+			}
+			dtz = -d
+			if dtz == 1 && next.Status() == chess.Checkmate {
+				minDTZ = 1
+			}
+			dtz += sign(dtz)
+		}
+		if dtz < minDTZ && sign(dtz) == sign(int(wdl)) {
+			minDTZ = dtz
+		}
+	}
+
+	// Without legal moves the side to move is mated.
+	if minDTZ == math.MaxInt {
+		return -1, nil
+	}
+	return minDTZ, nil
+}
+
+// probeTable looks pos up in the table file of the given kind. DTZ
+// values need the result of the position, wdl. changeSTM reports that
+// the DTZ table only holds the other side to move.
+func (tb *Tablebase) probeTable(pos *chess.Position, kind int, wdl WDL) (value int, changeSTM bool, err error) {
+	board := pos.Board()
+	if len(board.SquareMap()) == 2 {
+		return 0, false, nil
+	}
+
+	name := materialName(board)
+	t, ok := tb.tables[name]
+	if !ok {
+		return 0, false, fmt.Errorf("%w: no %s table", ErrNotFound, name)
+	}
+	tf, err := t.file(kind)
+	if err != nil {
+		return 0, false, err
+	}
+	d, file, idx, ok := t.index(tf, pos)
+	if !ok {
+		return 0, true, nil
+	}
+	v, err := tf.decompress(d, idx)
+	if err != nil {
+		return 0, false, fmt.Errorf("%w: %s", err, t.paths[kind])
+	}
+	v, err = tf.mapScore(file, v, wdl)
+	return v, false, err
+}
+
+// materialName names the material on board the way tables are named,
+// white first, e.g. KRPvKR.
+func materialName(board *chess.Board) string {
+	var counts [2][7]int
+	for _, p := range board.SquareMap() {
+		counts[b2i(p.Color() == chess.Black)][p.Type()]++
+	}
+
+	var sb strings.Builder
+	for c := range counts {
+		if c == 1 {
+			sb.WriteByte('v')
+		}
+		sb.WriteByte('K')
+		for _, pt := range []chess.PieceType{chess.Queen, chess.Rook, chess.Bishop, chess.Knight, chess.Pawn} {
+			sb.WriteString(strings.Repeat(strings.ToUpper(pt.String()), counts[c][pt]))
+		}
+	}
+	return sb.String()
+}
+
+// dtzBeforeZeroing returns the DTZ of a capture or pawn move into a
+// position with result wdl for the side that made it.
+func dtzBeforeZeroing(wdl WDL) int {
+	switch wdl {
+	case Win:
+		return 1
+	case CursedWin:
+		return 101
+	case BlessedLoss:
+		return -101
+	case Loss:
+		return -1
+	}
+	return 0
+}
+
+// zeroing reports whether m resets the 50-move counter.
+func zeroing(pos *chess.Position, m *chess.Move) bool {
+	return isCapture(m) || pos.Board().Piece(m.S1()).Type() == chess.Pawn
+}
+
+func isCapture(m *chess.Move) bool {
+	return m.HasTag(chess.Capture) || m.HasTag(chess.EnPassant)
+}
+
+func sign(n int) int {
+	switch {
+	case n > 0:
+		return 1
+	case n < 0:
+		return -1
+	}
+	return 0
+}
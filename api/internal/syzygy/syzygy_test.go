@@ -0,0 +1,299 @@
+package syzygy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+// There are no table files small enough to commit that would cover the
+// code, so the tests solve KQvK and KRvK themselves, write them out in
+// the table format and probe them back. SYZYGY_TEST_PATH points
+// TestRealTables at downloaded tables to check against the same
+// solutions.
+var (
+	tablesDir string
+	solutions = make(map[chess.PieceType]*solution)
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "syzygy")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	tablesDir = dir
+	for _, piece := range []chess.PieceType{chess.Queen, chess.Rook} {
+		s := solve(piece)
+		if err := writeTables(dir, s); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		solutions[piece] = s
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func openTables(t *testing.T, dir string) *Tablebase {
+	t.Helper()
+	tb, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tb.Close() })
+	return tb
+}
+
+func position(t *testing.T, fen string) *chess.Position {
+	t.Helper()
+	var pos chess.Position
+	if err := pos.UnmarshalText([]byte(fen)); err != nil {
+		t.Fatal(err)
+	}
+	return &pos
+}
+
+// TestSolve checks the solutions the tests rely on against the longest
+// mates known for the endgames: 10 moves with a queen and 16 with a
+// rook.
+func TestSolve(t *testing.T) {
+	for piece, want := range map[chess.PieceType]int16{chess.Queen: 19, chess.Rook: 31} {
+		var longest int16
+		for k, plies := range solutions[piece].plies {
+			if k>>18 == 0 {
+				longest = max(longest, plies)
+			}
+		}
+		if longest != want {
+			t.Errorf("K%svK: longest mate is %d plies, want %d", piece, longest, want)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tb := openTables(t, tablesDir)
+	if tb.Len() != 2 || tb.MaxPieces() != 3 {
+		t.Errorf("got %d tables of up to %d pieces, want 2 of up to 3", tb.Len(), tb.MaxPieces())
+	}
+	if _, err := Open(t.TempDir()); err == nil {
+		t.Error("opening a directory without tables: got no error")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	tb := openTables(t, tablesDir)
+	for _, s := range solutions {
+		checkProbes(t, tb, s, 211, 0)
+	}
+}
+
+// checkProbes probes every step-th position of s, as is and with the
+// colors swapped, and compares the results with the solution. DTZ may be
+// off by slack plies.
+func checkProbes(t *testing.T, tb *Tablebase, s *solution, step, slack int) {
+	t.Helper()
+	var checked, failed int
+	for k := 0; k < len(s.plies) && failed < 10; k += step {
+		stm, wk, wp, bk := unkey(k)
+		if !s.legal(stm, wk, wp, bk) {
+			continue
+		}
+		wantWDL, wantDTZ := s.result(k)
+		for _, flip := range []bool{false, true} {
+			fen := s.fen(k, flip)
+			pos := position(t, fen)
+			checked++
+			wdl, err := tb.ProbeWDL(pos)
+			if err != nil || wdl != wantWDL {
+				t.Errorf("ProbeWDL(%s) = %v, %v, want %v", fen, wdl, err, wantWDL)
+				failed++
+				continue
+			}
+			dtz, err := tb.ProbeDTZ(pos)
+			if err != nil || abs(dtz-wantDTZ) > slack || sign(dtz) != sign(wantDTZ) {
+				t.Errorf("ProbeDTZ(%s) = %d, %v, want %d", fen, dtz, err, wantDTZ)
+				failed++
+			}
+		}
+	}
+	if checked == 0 {
+		t.Error("no positions checked")
+	}
+}
+
+func TestBestMove(t *testing.T) {
+	tb := openTables(t, tablesDir)
+	for _, s := range solutions {
+		for k := 0; k < len(s.plies); k += 1009 {
+			stm, wk, wp, bk := unkey(k)
+			if !s.legal(stm, wk, wp, bk) || len(s.moves(stm, wk, wp, bk)) == 0 {
+				continue
+			}
+			fen := s.fen(k, false)
+			pos := position(t, fen)
+			best, err := tb.BestMove(pos)
+			if err != nil {
+				t.Errorf("BestMove(%s): %v", fen, err)
+				continue
+			}
+
+			wantWDL, wantDTZ := s.result(k)
+			if best.WDL != wantWDL || best.DTZ != wantDTZ {
+				t.Errorf("BestMove(%s) = %s with %v, DTZ %d, want %v, DTZ %d", fen, best.Move, best.WDL, best.DTZ, wantWDL, wantDTZ)
+			}
+		}
+	}
+}
+
+func TestKnownPositions(t *testing.T) {
+	tb := openTables(t, tablesDir)
+	tests := []struct {
+		name string
+		fen  string
+		wdl  WDL
+		dtz  int
+		best string // "#" for any mate
+	}{
+		{"queen mates in one", "k7/8/1K6/8/8/8/8/6Q1 w - - 0 1", Win, 1, "#"},
+		{"rook mates in one", "7k/8/6K1/8/8/8/8/R7 w - - 0 1", Win, 1, "a1a8"},
+		{"mated by a black queen", "8/8/8/8/8/2k5/1q6/K7 w - - 0 1", Loss, -1, ""},
+		{"stalemate", "7k/5Q2/6K1/8/8/8/8/8 b - - 0 1", Draw, 0, ""},
+		{"king takes the queen", "8/8/8/8/8/8/8/Kq2k3 w - - 0 1", Draw, 0, "a1b1"},
+		{"rook left hanging", "8/8/8/8/8/8/1k6/R3K3 b - - 0 1", Draw, 0, "b2a1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos := position(t, tt.fen)
+			wdl, err := tb.ProbeWDL(pos)
+			if err != nil || wdl != tt.wdl {
+				t.Errorf("ProbeWDL = %v, %v, want %v", wdl, err, tt.wdl)
+			}
+			dtz, err := tb.ProbeDTZ(pos)
+			if err != nil || dtz != tt.dtz {
+				t.Errorf("ProbeDTZ = %d, %v, want %d", dtz, err, tt.dtz)
+			}
+			if tt.best == "" {
+				return
+			}
+			best, err := tb.BestMove(pos)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := (chess.UCINotation{}).Encode(pos, best.Move)
+			if tt.best == "#" && pos.Update(best.Move).Status() != chess.Checkmate {
+				t.Errorf("BestMove = %s, want a mate", got)
+			} else if tt.best != "#" && got != tt.best {
+				t.Errorf("BestMove = %s, want %s", got, tt.best)
+			}
+		})
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	tb := openTables(t, tablesDir)
+	for _, fen := range []string{
+		"8/8/8/8/8/8/1k6/R2QK3 w - - 0 1",
+		"8/8/8/8/8/8/1k6/B3K3 w - - 0 1",
+		"4k3/8/8/8/8/8/8/R3K3 w Q - 0 1",
+	} {
+		if _, err := tb.ProbeWDL(position(t, fen)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("ProbeWDL(%s): got %v, want ErrNotFound", fen, err)
+		}
+	}
+}
+
+// realTablesDir returns the directory of the real tables the tests use:
+// SYZYGY_TEST_PATH if set, otherwise testdata. Real tables are published
+// at https://tablebase.lichess.ovh/tables/standard/3-4-5/.
+func realTablesDir() string {
+	if dir := os.Getenv("SYZYGY_TEST_PATH"); dir != "" {
+		return dir
+	}
+	return "testdata"
+}
+
+// TestRealTables checks real KQvK and KRvK tables against the solutions.
+// Real DTZ tables may round to whole moves, so DTZ may be a ply off.
+func TestRealTables(t *testing.T) {
+	dir := realTablesDir()
+	var found []*solution
+	for _, s := range solutions {
+		name := "K" + strings.ToUpper(s.piece.String()) + "vK"
+		if _, err := os.Stat(filepath.Join(dir, name+".rtbz")); err != nil {
+			t.Logf("skipping %s: %v", name, err)
+			continue
+		}
+		found = append(found, s)
+	}
+	if len(found) == 0 {
+		t.Skipf("no real KQvK or KRvK tables in %s", dir)
+	}
+	tb := openTables(t, dir)
+	for _, s := range found {
+		checkProbes(t, tb, s, 37, 1)
+	}
+}
+
+// TestRealKPvK probes a real KPvK table, from testdata or
+// SYZYGY_TEST_PATH, for positions whose results are known. It is the
+// only check of the pawn table layout against tables this package didn't
+// write.
+func TestRealKPvK(t *testing.T) {
+	dir := realTablesDir()
+	for _, ext := range []string{".rtbw", ".rtbz"} {
+		if _, err := os.Stat(filepath.Join(dir, "KPvK"+ext)); err != nil {
+			t.Skipf("no real KPvK table: %v", err)
+		}
+	}
+	tb := openTables(t, dir)
+	tests := []struct {
+		name string
+		fen  string
+		wdl  WDL
+		dtz  int // 0 to only check its sign
+	}{
+		{"king ahead of its pawn", "4k3/8/4K3/4P3/8/8/8/8 w - - 0 1", Win, 0},
+		{"king ahead of its pawn, black to move", "4k3/8/4K3/4P3/8/8/8/8 b - - 0 1", Loss, 0},
+		{"outside the square", "8/8/8/8/8/8/P6k/K7 w - - 0 1", Win, 0},
+		{"promotes at once", "8/4P3/8/8/8/8/k7/4K3 w - - 0 1", Win, 1},
+		{"blockaded, white to move", "4k3/8/4P3/4K3/8/8/8/8 w - - 0 1", Draw, 0},
+		{"rook pawn, king in the corner", "7k/8/8/8/8/8/7P/7K w - - 0 1", Draw, 0},
+		{"rook pawn, king in front", "k7/8/8/8/8/8/P7/K7 w - - 0 1", Draw, 0},
+		{"pawn taken at once", "8/8/8/8/8/8/3kP3/6K1 b - - 0 1", Draw, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos := position(t, tt.fen)
+			wdl, err := tb.ProbeWDL(pos)
+			if err != nil || wdl != tt.wdl {
+				t.Errorf("ProbeWDL = %v, %v, want %v", wdl, err, tt.wdl)
+			}
+			dtz, err := tb.ProbeDTZ(pos)
+			switch {
+			case err != nil:
+				t.Errorf("ProbeDTZ: %v", err)
+			case tt.dtz != 0 && dtz != tt.dtz:
+				t.Errorf("ProbeDTZ = %d, want %d", dtz, tt.dtz)
+			case sign(dtz) != sign(int(tt.wdl)):
+				t.Errorf("ProbeDTZ = %d, want the sign of a %v", dtz, tt.wdl)
+			}
+			if tt.wdl != Win {
+				return
+			}
+			best, err := tb.BestMove(pos)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := tb.ProbeWDL(pos.Update(best.Move)); err != nil || got != Loss {
+				t.Errorf("BestMove %s leaves %v, %v, want a loss for the other side", best.Move, got, err)
+			}
+		})
+	}
+}
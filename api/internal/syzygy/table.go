@@ -0,0 +1,591 @@
+package syzygy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Table file kinds.
+const (
+	wdlKind = iota
+	dtzKind
+)
+
+// Extensions of the table files, by kind.
+var extensions = [...]string{".rtbw", ".rtbz"}
+
+// Magic numbers of the table files, by kind.
+var magics = [...][4]byte{
+	{0x71, 0xe8, 0x23, 0x5d},
+	{0xd7, 0x66, 0x0c, 0xa5},
+}
+
+// Flags of the pairs data. All but flagSingleValue only appear in DTZ
+// tables.
+const (
+	flagSTM         = 1
+	flagMapped      = 2
+	flagWinPlies    = 4
+	flagLossPlies   = 8
+	flagWide        = 16
+	flagSingleValue = 128
+)
+
+// errCorrupt is returned for table files that don't parse.
+var errCorrupt = errors.New("syzygy: corrupt table")
+
+// table is an endgame, such as KRPvKR, with its WDL and DTZ files.
+type table struct {
+	name            string
+	paths           [2]string
+	pieces          int
+	hasPawns        bool
+	bothPawns       bool
+	hasUniquePieces bool
+	symmetric       bool
+	files           [2]tableFile
+}
+
+// tableFile is one table file, read on first use. The header is kept in
+// memory, the compressed blocks are read as needed.
+type tableFile struct {
+	once   sync.Once
+	err    error
+	kind   int
+	f      *os.File
+	head   []byte
+	dtzMap int
+	pairs  [2][4]pairsData
+}
+
+// pairsData describes how one part of a table is compressed: a table
+// has one per side to move it stores and, with pawns, per file of the
+// leading pawn. Values are Huffman coded symbols, each of which expands
+// to a pair of symbols until it reaches a value.
+type pairsData struct {
+	flags       uint8
+	blockSize   int64
+	span        uint64
+	numBlocks   int
+	minSymLen   int
+	lowestSym   []uint16
+	base64      []uint64
+	symlen      []uint8
+	btree       []byte
+	sparseIndex int
+	sparseSize  int
+	blockLength int
+	blockCount  int
+	data        int64
+
+	pieces   [maxPieces]uint8
+	groupLen [maxPieces + 1]int
+	groupIdx [maxPieces + 1]uint64
+	mapIdx   [4]int
+}
+
+// get returns the pairs data for side to move stm and the file of the
+// leading pawn.
+func (tf *tableFile) get(stm, file int) *pairsData {
+	if tf.kind == dtzKind {
+		stm = 0
+	}
+	return &tf.pairs[stm][file]
+}
+
+// file returns the table file of the given kind, reading it on first
+// use.
+func (t *table) file(kind int) (*tableFile, error) {
+	tf := &t.files[kind]
+	tf.once.Do(func() {
+		tf.kind = kind
+		if t.paths[kind] == "" {
+			tf.err = fmt.Errorf("%w: no %s file for %s", ErrNotFound, extensions[kind], t.name)
+			return
+		}
+		tf.err = tf.open(t, t.paths[kind])
+	})
+	return tf, tf.err
+}
+
+// close closes the table's files.
+func (t *table) close() {
+	for i := range t.files {
+		if f := t.files[i].f; f != nil {
+			f.Close()
+		}
+	}
+}
+
+func (tf *tableFile) open(t *table, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("syzygy: %w", err)
+	}
+	tf.f = f
+	if err := tf.parse(t); err != nil {
+		f.Close()
+		tf.f = nil
+		tf.head = nil
+		if errors.Is(err, errCorrupt) {
+			return fmt.Errorf("%w: %s", err, path)
+		}
+		return err
+	}
+	return nil
+}
+
+// need reads the header up to offset end.
+func (tf *tableFile) need(end int) error {
+	if end <= len(tf.head) {
+		return nil
+	}
+	head := make([]byte, max(end, 2*len(tf.head), 4096))
+	n, err := tf.f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("syzygy: %w", err)
+	}
+	if n < end {
+		return errCorrupt
+	}
+	tf.head = head[:n]
+	return nil
+}
+
+// parse reads the header of the table file: the pieces and their order
+// in the index, then the pairs data of each part of the table, the DTZ
+// value maps, the sparse indices and the block lengths.
+func (tf *tableFile) parse(t *table) error {
+	if err := tf.need(5); err != nil {
+		return err
+	}
+	if [4]byte(tf.head) != magics[tf.kind] {
+		return errCorrupt
+	}
+
+	// The flags repeat what the table's name tells.
+	const split, hasPawns = 1, 2
+	flags := tf.head[4]
+	if (flags&hasPawns != 0) != t.hasPawns || (tf.kind == wdlKind && (flags&split != 0) == t.symmetric) {
+		return errCorrupt
+	}
+
+	sides := 1
+	if tf.kind == wdlKind && !t.symmetric {
+		sides = 2
+	}
+	files := 1
+	if t.hasPawns {
+		files = 4
+	}
+
+	off := 5
+	for f := 0; f < files; f++ {
+		orderLen := 1 + b2i(t.bothPawns)
+		if err := tf.need(off + orderLen + t.pieces); err != nil {
+			return err
+		}
+		order := [2][2]int{{int(tf.head[off] & 0xf), 0xf}, {int(tf.head[off] >> 4), 0xf}}
+		if t.bothPawns {
+			order[0][1], order[1][1] = int(tf.head[off+1]&0xf), int(tf.head[off+1]>>4)
+		}
+		off += orderLen
+
+		for k := 0; k < t.pieces; k++ {
+			tf.pairs[0][f].pieces[k] = tf.head[off] & 0xf
+			tf.pairs[1][f].pieces[k] = tf.head[off] >> 4
+			off++
+		}
+		for i := 0; i < sides; i++ {
+			if err := t.setGroups(&tf.pairs[i][f], order[i], f); err != nil {
+				return err
+			}
+		}
+	}
+	off += off & 1
+
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			var err error
+			if off, err = tf.setSizes(&tf.pairs[i][f], off); err != nil {
+				return err
+			}
+		}
+	}
+
+	if tf.kind == dtzKind {
+		var err error
+		if off, err = tf.setDTZMap(files, off); err != nil {
+			return err
+		}
+	}
+
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			d := &tf.pairs[i][f]
+			d.sparseIndex = off
+			off += 6 * d.sparseSize
+		}
+	}
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			d := &tf.pairs[i][f]
+			d.blockLength = off
+			off += 2 * d.blockCount
+		}
+	}
+	if err := tf.need(off); err != nil {
+		return err
+	}
+
+	// The compressed blocks start on 64 byte boundaries.
+	data := int64(off)
+	for f := 0; f < files; f++ {
+		for i := 0; i < sides; i++ {
+			d := &tf.pairs[i][f]
+			data = (data + 0x3f) &^ 0x3f
+			d.data = data
+			data += int64(d.numBlocks) * d.blockSize
+		}
+	}
+	return nil
+}
+
+// setGroups splits the pieces of d into the groups they are encoded in
+// and works out the multiplier of each group. Pieces of a kind form a
+// group, bar the first group: the leading pawns, or the kings along
+// with a unique piece if there is one. order gives the order of the
+// leading group and the other side's pawns among the groups.
+func (t *table) setGroups(d *pairsData, order [2]int, file int) error {
+	firstLen := 2
+	switch {
+	case t.hasPawns:
+		firstLen = 0
+	case t.hasUniquePieces:
+		firstLen = 3
+	}
+
+	n := 0
+	d.groupLen[0] = 1
+	for i := 1; i < t.pieces; i++ {
+		firstLen--
+		if firstLen > 0 || d.pieces[i] == d.pieces[i-1] {
+			d.groupLen[n]++
+		} else {
+			n++
+			d.groupLen[n] = 1
+		}
+	}
+	n++
+	d.groupLen[n] = 0
+	for _, l := range d.groupLen[:n] {
+		if l >= len(binomial) {
+			return errCorrupt
+		}
+	}
+
+	pp := t.hasPawns && t.bothPawns
+	next := 1
+	free := 64 - d.groupLen[0]
+	if pp {
+		next = 2
+		free -= d.groupLen[1]
+	}
+
+	idx := uint64(1)
+	for k := 0; next < n || k == order[0] || k == order[1]; k++ {
+		switch k {
+		case order[0]:
+			d.groupIdx[0] = idx
+			switch {
+			case t.hasPawns:
+				idx *= leadPawnsSize[d.groupLen[0]][file]
+			case t.hasUniquePieces:
+				idx *= 31332
+			default:
+				idx *= 462
+			}
+		case order[1]:
+			d.groupIdx[1] = idx
+			idx *= binomial[d.groupLen[1]][48-d.groupLen[0]]
+		default:
+			d.groupIdx[next] = idx
+			idx *= binomial[d.groupLen[next]][free]
+			free -= d.groupLen[next]
+			next++
+		}
+	}
+	d.groupIdx[n] = idx
+	return nil
+}
+
+// setSizes reads the pairs data at off: its block sizes, Huffman code
+// and symbol tree. It returns the offset past them.
+func (tf *tableFile) setSizes(d *pairsData, off int) (int, error) {
+	if err := tf.need(off + 2); err != nil {
+		return 0, err
+	}
+	d.flags = tf.head[off]
+	if d.flags&flagSingleValue != 0 {
+		d.minSymLen = int(tf.head[off+1])
+		return off + 2, nil
+	}
+
+	if err := tf.need(off + 10); err != nil {
+		return 0, err
+	}
+	var size uint64
+	for i := 0; d.groupLen[i] != 0; i++ {
+		size = d.groupIdx[i+1]
+	}
+	if tf.head[off+1] > 16 || tf.head[off+2] > 32 {
+		return 0, errCorrupt
+	}
+	d.blockSize = 1 << tf.head[off+1]
+	d.span = 1 << tf.head[off+2]
+	d.sparseSize = int((size + d.span - 1) / d.span)
+	padding := int(tf.head[off+3])
+	d.numBlocks = int(binary.LittleEndian.Uint32(tf.head[off+4:]))
+	d.blockCount = d.numBlocks + padding
+	maxSymLen := int(tf.head[off+8])
+	d.minSymLen = int(tf.head[off+9])
+	off += 10
+	if maxSymLen < d.minSymLen || d.minSymLen == 0 {
+		return 0, errCorrupt
+	}
+
+	// The Huffman code is canonical, so the lowest symbol of each length
+	// is enough to find the first code of that length, padded to 64 bits.
+	lengths := maxSymLen - d.minSymLen + 1
+	if err := tf.need(off + 2*lengths + 2); err != nil {
+		return 0, err
+	}
+	d.lowestSym = make([]uint16, lengths)
+	for i := range d.lowestSym {
+		d.lowestSym[i] = binary.LittleEndian.Uint16(tf.head[off+2*i:])
+	}
+	d.base64 = make([]uint64, lengths)
+	for i := lengths - 2; i >= 0; i-- {
+		d.base64[i] = (d.base64[i+1] + uint64(d.lowestSym[i]) - uint64(d.lowestSym[i+1])) / 2
+	}
+	for i := range d.base64 {
+		d.base64[i] <<= 64 - i - d.minSymLen
+	}
+	off += 2 * lengths
+
+	syms := int(binary.LittleEndian.Uint16(tf.head[off:]))
+	off += 2
+	if err := tf.need(off + 3*syms); err != nil {
+		return 0, err
+	}
+	d.btree = tf.head[off : off+3*syms]
+	d.symlen = make([]uint8, syms)
+	visited := make([]bool, syms)
+	for s := range syms {
+		if visited[s] {
+			continue
+		}
+		n, err := d.setSymlen(s, visited)
+		if err != nil {
+			return 0, err
+		}
+		d.symlen[s] = n
+	}
+
+	return off + 3*syms + syms&1, nil
+}
+
+// left and right return the symbols that sym expands to. A symbol
+// without a right one stands for the value left returns.
+func (d *pairsData) left(sym int) int {
+	return int(d.btree[3*sym+1]&0xf)<<8 | int(d.btree[3*sym])
+}
+
+func (d *pairsData) right(sym int) int {
+	return int(d.btree[3*sym+2])<<4 | int(d.btree[3*sym+1]>>4)
+}
+
+// setSymlen works out how many values, less one, sym expands to.
+func (d *pairsData) setSymlen(sym int, visited []bool) (uint8, error) {
+	visited[sym] = true
+	r := d.right(sym)
+	if r == 0xfff {
+		return 0, nil
+	}
+	l := d.left(sym)
+	if l >= len(d.symlen) || r >= len(d.symlen) {
+		return 0, errCorrupt
+	}
+	for _, s := range []int{l, r} {
+		if visited[s] {
+			continue
+		}
+		n, err := d.setSymlen(s, visited)
+		if err != nil {
+			return 0, err
+		}
+		d.symlen[s] = n
+	}
+	return d.symlen[l] + d.symlen[r] + 1, nil
+}
+
+// setDTZMap reads the maps from stored DTZ values, which are numbered by
+// frequency, to actual ones. There is a map for each result.
+func (tf *tableFile) setDTZMap(files, off int) (int, error) {
+	tf.dtzMap = off
+	for f := 0; f < files; f++ {
+		d := tf.get(0, f)
+		if d.flags&flagMapped == 0 {
+			continue
+		}
+		for i := range d.mapIdx {
+			if d.flags&flagWide != 0 {
+				off += off & 1
+				if err := tf.need(off + 2); err != nil {
+					return 0, err
+				}
+				d.mapIdx[i] = (off-tf.dtzMap)/2 + 1
+				off += 2*int(binary.LittleEndian.Uint16(tf.head[off:])) + 2
+			} else {
+				if err := tf.need(off + 1); err != nil {
+					return 0, err
+				}
+				d.mapIdx[i] = off - tf.dtzMap + 1
+				off += int(tf.head[off]) + 1
+			}
+		}
+	}
+	return off + off&1, nil
+}
+
+// mapScore turns a stored value into a WDL result, or a DTZ in plies
+// for a position with result wdl.
+func (tf *tableFile) mapScore(file, value int, wdl WDL) (int, error) {
+	if tf.kind == wdlKind {
+		return value - 2, nil
+	}
+
+	d := tf.get(0, file)
+	if d.flags&flagMapped != 0 {
+		i := d.mapIdx[[...]int{1, 3, 0, 2, 0}[wdl+2]] + value
+		if d.flags&flagWide != 0 {
+			i = tf.dtzMap + 2*i
+			if i+2 > len(tf.head) {
+				return 0, errCorrupt
+			}
+			value = int(binary.LittleEndian.Uint16(tf.head[i:]))
+		} else {
+			i += tf.dtzMap
+			if i >= len(tf.head) {
+				return 0, errCorrupt
+			}
+			value = int(tf.head[i])
+		}
+	}
+
+	// Tables store moves rather than plies where that makes no
+	// difference.
+	if (wdl == Win && d.flags&flagWinPlies == 0) || (wdl == Loss && d.flags&flagLossPlies == 0) || wdl == CursedWin || wdl == BlessedLoss {
+		value *= 2
+	}
+	return value + 1, nil
+}
+
+// decompress returns the value stored at index idx of d.
+func (tf *tableFile) decompress(d *pairsData, idx uint64) (int, error) {
+	if d.flags&flagSingleValue != 0 {
+		return d.minSymLen, nil
+	}
+
+	// Find the block holding idx from the nearest sparse index entry,
+	// which points at the value in the middle of its span.
+	k := int(idx / d.span)
+	if k >= d.sparseSize {
+		return 0, errCorrupt
+	}
+	entry := tf.head[d.sparseIndex+6*k:]
+	block := int(binary.LittleEndian.Uint32(entry))
+	offset := int(binary.LittleEndian.Uint16(entry[4:]))
+	offset += int(idx%d.span) - int(d.span/2)
+
+	blockLength := func(b int) (int, error) {
+		if b < 0 || b >= d.blockCount {
+			return 0, errCorrupt
+		}
+		return int(binary.LittleEndian.Uint16(tf.head[d.blockLength+2*b:])), nil
+	}
+	for offset < 0 {
+		block--
+		n, err := blockLength(block)
+		if err != nil {
+			return 0, err
+		}
+		offset += n + 1
+	}
+	for {
+		n, err := blockLength(block)
+		if err != nil {
+			return 0, err
+		}
+		if offset <= n {
+			break
+		}
+		offset -= n + 1
+		block++
+	}
+
+	buf := make([]byte, d.blockSize+8)
+	if _, err := tf.f.ReadAt(buf, d.data+int64(block)*d.blockSize); err != nil && err != io.EOF {
+		return 0, fmt.Errorf("syzygy: %w", err)
+	}
+
+	// Walk the symbols of the block to the one holding our value.
+	bits := binary.BigEndian.Uint64(buf)
+	next := 8
+	avail := 64
+	var sym int
+	for {
+		l := 0
+		for l < len(d.base64) && bits < d.base64[l] {
+			l++
+		}
+		if l == len(d.base64) {
+			return 0, errCorrupt
+		}
+		sym = int(uint16((bits-d.base64[l])>>(64-l-d.minSymLen)) + d.lowestSym[l])
+		if sym >= len(d.symlen) {
+			return 0, errCorrupt
+		}
+		if offset < int(d.symlen[sym])+1 {
+			break
+		}
+		offset -= int(d.symlen[sym]) + 1
+		l += d.minSymLen
+		bits <<= l
+		avail -= l
+		if avail <= 32 {
+			if next+4 > len(buf) {
+				return 0, errCorrupt
+			}
+			avail += 32
+			bits |= uint64(binary.BigEndian.Uint32(buf[next:])) << (64 - avail)
+			next += 4
+		}
+	}
+
+	// Expand the symbol down to the value.
+	for d.symlen[sym] != 0 {
+		l := d.left(sym)
+		if offset < int(d.symlen[l])+1 {
+			sym = l
+		} else {
+			offset -= int(d.symlen[l]) + 1
+			sym = d.right(sym)
+		}
+	}
+	return d.left(sym), nil
+}
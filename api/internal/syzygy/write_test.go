@@ -0,0 +1,245 @@
+package syzygy
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/notnil/chess"
+)
+
+// Block and sparse index sizes of the tables the tests write, small so
+// that lookups cross many blocks.
+const (
+	testBlockLog = 5
+	testSpanLog  = 6
+)
+
+// encodePairs compresses values the way the table files do, with a
+// simple canonical code: each value is a symbol of the same length,
+// bar a pair of the most common value, which is a one bit symbol. It
+// returns the part of the header that describes the compression, the
+// sparse index, the block lengths and the blocks.
+func encodePairs(flags uint8, values []int) (sizes, sparse, lengths, data []byte) {
+	counts := make(map[int]int)
+	for _, v := range values {
+		counts[v]++
+	}
+	common := values[0]
+	for v, n := range counts {
+		if n > counts[common] || (n == counts[common] && v < common) {
+			common = v
+		}
+	}
+
+	// Values are leaf symbols numbered by value, n of them, a power of
+	// two so the code is complete, and symbol n is the pair.
+	n := max(2, 1<<bits.Len(uint(slices.Max(values))))
+	symLen := bits.Len(uint(n))
+	btree := make([]byte, 3*(n+1))
+	for s := range n + 1 {
+		left, right := s, 0xfff
+		if s == n {
+			left, right = common, common
+		}
+		btree[3*s] = byte(left)
+		btree[3*s+1] = byte(left>>8&0xf | right&0xf<<4)
+		btree[3*s+2] = byte(right >> 4)
+	}
+
+	// Fill the blocks, noting the first value of each.
+	const blockSize = 1 << testBlockLog
+	starts := []int{0}
+	var code []bool
+	for i := 0; i < len(values); {
+		pair := values[i] == common && i+1 < len(values) && values[i+1] == common
+		l := symLen
+		if pair {
+			l = 1
+		}
+		if len(code)+l > 8*blockSize {
+			data = append(data, packBits(code, blockSize)...)
+			starts = append(starts, i)
+			code = nil
+		}
+		if pair {
+			code = append(code, true)
+			i += 2
+			continue
+		}
+		for b := symLen - 1; b >= 0; b-- {
+			code = append(code, values[i]>>b&1 != 0)
+		}
+		i++
+	}
+	data = append(data, packBits(code, blockSize)...)
+
+	// Block lengths are the number of values in a block, less one.
+	for b, start := range starts {
+		end := len(values)
+		if b+1 < len(starts) {
+			end = starts[b+1]
+		}
+		lengths = binary.LittleEndian.AppendUint16(lengths, uint16(end-start-1))
+	}
+
+	// Each sparse index entry points at the value in the middle of its
+	// span, counting on past the last value for the last span.
+	span := 1 << testSpanLog
+	for k := 0; k*span < len(values); k++ {
+		mid := k*span + span/2
+		v := min(mid, len(values)-1)
+		b, _ := slices.BinarySearch(starts, v+1)
+		b--
+		sparse = binary.LittleEndian.AppendUint32(sparse, uint32(b))
+		sparse = binary.LittleEndian.AppendUint16(sparse, uint16(v-starts[b]+mid-v))
+	}
+
+	sizes = []byte{flags, testBlockLog, testSpanLog, 0}
+	sizes = binary.LittleEndian.AppendUint32(sizes, uint32(len(starts)))
+	sizes = append(sizes, byte(symLen), 1)
+	for i := range symLen {
+		lowest := n
+		if i == symLen-1 {
+			lowest = 0
+		}
+		sizes = binary.LittleEndian.AppendUint16(sizes, uint16(lowest))
+	}
+	sizes = binary.LittleEndian.AppendUint16(sizes, uint16(n+1))
+	sizes = append(sizes, btree...)
+	if (n+1)&1 != 0 {
+		sizes = append(sizes, 0)
+	}
+	return sizes, sparse, lengths, data
+}
+
+// packBits packs code into a block of size bytes, first bit highest.
+func packBits(code []bool, size int) []byte {
+	block := make([]byte, size)
+	for i, bit := range code {
+		if bit {
+			block[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return block
+}
+
+// writeTables writes the WDL and DTZ files of the table solved in s to
+// dir. The DTZ file only holds white to move and maps its values, like
+// most real ones. The index mirrors every position onto one with the
+// white king in the a1-d1-d4 triangle, so only those are written, and
+// those it maps together must have the same result.
+func writeTables(dir string, s *solution) error {
+	white := chess.NewPiece(s.piece, chess.White)
+	order := []uint8{pieceCode(chess.WhiteKing), pieceCode(white), pieceCode(chess.BlackKing)}
+	name := "K" + strings.ToUpper(s.piece.String()) + "vK"
+	t := newTable(name, filepath.Join(dir, name))
+	t.paths[dtzKind] = filepath.Join(dir, name+extensions[dtzKind])
+
+	wdl, dtz := &tableFile{kind: wdlKind}, &tableFile{kind: dtzKind}
+	for _, d := range []*pairsData{&wdl.pairs[0][0], &wdl.pairs[1][0], &dtz.pairs[0][0]} {
+		copy(d.pieces[:], order)
+		if err := t.setGroups(d, [2]int{0, 0xf}, 0); err != nil {
+			return err
+		}
+	}
+	dtz.pairs[0][0].flags = flagMapped | flagWinPlies | flagLossPlies
+	size := wdl.pairs[0][0].groupIdx[1]
+
+	wdlValues := [2][]int{make([]int, size), make([]int, size)}
+	dtzValues := make([]int, size)
+	for _, values := range [][]int{wdlValues[0], wdlValues[1], dtzValues} {
+		for i := range values {
+			values[i] = -1
+		}
+	}
+	set := func(values []int, idx uint64, v int, k int) error {
+		if values[idx] >= 0 && values[idx] != v {
+			return fmt.Errorf("%s: index %d holds %d and %d, the latter for %s", name, idx, values[idx], v, s.fen(k, false))
+		}
+		values[idx] = v
+		return nil
+	}
+
+	frequency := make(map[int]int)
+	for k := range s.plies {
+		stm, wk, wp, bk := unkey(k)
+		if wk&7 > 3 || offDiagonal(wk) > 0 || !s.legal(stm, wk, wp, bk) {
+			continue
+		}
+		pos := s.position(k, false)
+		result, plies := s.result(k)
+		d, _, idx, _ := t.index(wdl, pos)
+		if err := set(wdlValues[b2i(d != &wdl.pairs[0][0])], idx, int(result)+2, k); err != nil {
+			return err
+		}
+		if _, _, idx, ok := t.index(dtz, pos); ok && result == Win {
+			if err := set(dtzValues, idx, plies-1, k); err != nil {
+				return err
+			}
+			frequency[plies-1]++
+		}
+	}
+
+	// The DTZ map numbers the values by frequency; only the win map is
+	// used.
+	var dtzMap []int
+	for v := range frequency {
+		dtzMap = append(dtzMap, v)
+	}
+	slices.SortFunc(dtzMap, func(a, b int) int { return cmp.Or(frequency[b]-frequency[a], a-b) })
+	for i, v := range dtzValues {
+		dtzValues[i] = max(slices.Index(dtzMap, v), 0)
+	}
+	mapBytes := []byte{byte(len(dtzMap))}
+	for _, v := range dtzMap {
+		mapBytes = append(mapBytes, byte(v))
+	}
+	mapBytes = append(mapBytes, 0, 0, 0)
+
+	for _, values := range wdlValues {
+		for i, v := range values {
+			if v < 0 {
+				values[i] = int(Draw) + 2
+			}
+		}
+	}
+	err := os.WriteFile(t.paths[wdlKind], tableBytes(wdlKind, order, nil, []uint8{0, 0}, wdlValues[:]), 0o644)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(t.paths[dtzKind], tableBytes(dtzKind, order, mapBytes, []uint8{dtz.pairs[0][0].flags}, [][]int{dtzValues}), 0o644)
+}
+
+// tableBytes lays out a table file for a table without pawns, with the
+// pieces in order and the values of each side to move it holds.
+func tableBytes(kind int, order []uint8, dtzMap []byte, flags []uint8, values [][]int) []byte {
+	buf := append([]byte{}, magics[kind][:]...)
+	buf = append(buf, 1, 0)
+	for _, p := range order {
+		buf = append(buf, p|p<<4)
+	}
+	buf = append(buf, make([]byte, len(buf)&1)...)
+
+	var sparse, lengths, data [][]byte
+	for i, v := range values {
+		s, sp, l, d := encodePairs(flags[i], v)
+		buf = append(buf, s...)
+		sparse, lengths, data = append(sparse, sp), append(lengths, l), append(data, d)
+	}
+	if kind == dtzKind {
+		buf = append(buf, dtzMap...)
+		buf = append(buf, make([]byte, len(buf)&1)...)
+	}
+	buf = append(slices.Concat(append([][]byte{buf}, sparse...)...), slices.Concat(lengths...)...)
+	for _, d := range data {
+		buf = append(buf, make([]byte, -len(buf)&0x3f)...)
+		buf = append(buf, d...)
+	}
+	return buf
+}
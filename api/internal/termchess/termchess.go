@@ -0,0 +1,164 @@
+// Package termchess holds the game and board helpers shared by the
+// terminal clients of the chess API.
+package termchess
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/notnil/chess"
+)
+
+// ParseGame parses PGN (with or without tag pairs) into a game. An
+// empty string is a new game.
+func ParseGame(pgn string) (*chess.Game, error) {
+	if strings.TrimSpace(pgn) == "" {
+		return chess.NewGame(), nil
+	}
+	opt, err := chess.PGN(bytes.NewReader([]byte(pgn)))
+	if err != nil {
+		return nil, err
+	}
+	return chess.NewGame(opt), nil
+}
+
+// Movetext returns the game's moves in PGN without tag pairs, which is
+// the format the API expects.
+func Movetext(game *chess.Game) string {
+	parts := strings.Split(game.String(), "\n\n")
+	return strings.TrimSpace(parts[len(parts)-1])
+}
+
+// Status describes the state of the game for a human player playing
+// white against the LLM.
+func Status(game *chess.Game) string {
+	switch game.Outcome() {
+	case chess.WhiteWon:
+		return "You won! You are smarter than a LLaMA."
+	case chess.BlackWon:
+		return "LLaMA 3 won!"
+	case chess.Draw:
+		return fmt.Sprintf("Draw by %s. Are you a LLaMA?", game.Method())
+	}
+	if game.Position().Turn() == chess.White {
+		return "In progress. White (you) to move."
+	}
+	return "In progress. Black (LLM) to move."
+}
+
+// Options control how Draw renders a board.
+type Options struct {
+
+	// ANSI colors the squares with terminal escape codes. Without it
+	// the board is plain text.
+	ANSI bool
+
+	// Letters uses FEN letters instead of Unicode chess symbols.
+	Letters bool
+
+	// Highlight marks the given squares, e.g. the last move.
+	Highlight []chess.Square
+}
+
+const (
+	ansiReset     = "\x1b[0m"
+	ansiLight     = "\x1b[48;5;180m"
+	ansiDark      = "\x1b[48;5;137m"
+	ansiHighlight = "\x1b[48;5;185m"
+	ansiWhite     = "\x1b[1;97m"
+	ansiBlack     = "\x1b[1;30m"
+)
+
+// Draw renders the board from white's side with rank and file labels.
+func Draw(board *chess.Board, opts Options) string {
+	highlight := make(map[chess.Square]bool, len(opts.Highlight))
+	for _, sq := range opts.Highlight {
+		highlight[sq] = true
+	}
+
+	var sb strings.Builder
+	files := "   a  b  c  d  e  f  g  h\n"
+	if !opts.ANSI {
+		files = "  a b c d e f g h\n"
+	}
+	sb.WriteString(files)
+
+	for r := 7; r >= 0; r-- {
+		fmt.Fprintf(&sb, "%d ", r+1)
+		for f := 0; f < 8; f++ {
+			sq := chess.NewSquare(chess.File(f), chess.Rank(r))
+			p := board.Piece(sq)
+
+			symbol := "."
+			if p != chess.NoPiece {
+				symbol = pieceSymbol(p, opts.Letters)
+			}
+
+			if !opts.ANSI {
+				if highlight[sq] && p == chess.NoPiece {
+					symbol = "*"
+				}
+				sb.WriteString(symbol + " ")
+				continue
+			}
+
+			bg := ansiDark
+			if (f+r)%2 == 1 {
+				bg = ansiLight
+			}
+			if highlight[sq] {
+				bg = ansiHighlight
+			}
+			fg := ansiBlack
+			if p.Color() == chess.White {
+				fg = ansiWhite
+			}
+			if p == chess.NoPiece {
+				symbol = " "
+			}
+			sb.WriteString(bg + fg + " " + symbol + " " + ansiReset)
+		}
+		fmt.Fprintf(&sb, " %d\n", r+1)
+	}
+	sb.WriteString(files)
+
+	return sb.String()
+}
+
+// pieceSymbol returns the FEN letter or Unicode symbol for a piece.
+func pieceSymbol(p chess.Piece, letters bool) string {
+	if letters {
+		s := p.Type().String()
+		if p.Color() == chess.White {
+			return strings.ToUpper(s)
+		}
+		return s
+	}
+	return p.String()
+}
+
+// LastMoveSquares returns the from and to squares of the last move.
+func LastMoveSquares(game *chess.Game) []chess.Square {
+	moves := game.Moves()
+	if len(moves) == 0 {
+		return nil
+	}
+	m := moves[len(moves)-1]
+	return []chess.Square{m.S1(), m.S2()}
+}
+
+// Mover names who made an API move from source: the LLM, or the
+// engine, opening book or endgame tablebase standing in for it.
+func Mover(source string) string {
+	switch source {
+	case chessapi.MoveSourceEngine:
+		return "LLaMA 3 got stumped, so the engine"
+	case chessapi.MoveSourceBook:
+		return "LLaMA 3, from the opening book,"
+	case chessapi.MoveSourceTablebase:
+		return "LLaMA 3, from the endgame tablebase,"
+	}
+	return "LLaMA 3"
+}
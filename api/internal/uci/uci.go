@@ -0,0 +1,438 @@
+// Package uci drives a chess engine speaking the Universal Chess
+// Interface, such as Stockfish or Leela, running as a subprocess.
+package uci
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/engine"
+)
+
+// stopGrace is how long an engine is given to answer "stop" with its
+// best move before it is killed.
+const stopGrace = 2 * time.Second
+
+// ErrExited is returned when the engine process has gone away and
+// could not be restarted.
+var ErrExited = errors.New("uci: engine exited")
+
+// Engine is a UCI engine process. It runs one search at a time; calls
+// made during a search wait for it to finish. An engine that exits,
+// stops answering or has to be killed is restarted by the next call.
+type Engine struct {
+	// Name and Author are reported by the engine in the handshake.
+	Name   string
+	Author string
+
+	path   string
+	args   []string
+	config map[string]string
+
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	lines   chan string
+	exited  chan struct{}
+	options map[string]bool
+
+	mu      sync.Mutex
+	multiPV int
+	broken  error
+}
+
+// Start runs the engine at path with args, completes the UCI handshake
+// and sets options, e.g. "Threads" or "Hash". ctx bounds the startup.
+func Start(ctx context.Context, path string, args []string, options map[string]string) (*Engine, error) {
+	e := &Engine{path: path, args: args, config: options}
+	if err := e.start(ctx); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// start runs the engine process and completes the handshake.
+func (e *Engine) start(ctx context.Context) error {
+	cmd := exec.Command(e.path, e.args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("uci: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("uci: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("uci: starting %s: %w", e.path, err)
+	}
+
+	e.cmd = cmd
+	e.stdin = stdin
+	e.lines = make(chan string, 256)
+	e.exited = make(chan struct{})
+	e.options = make(map[string]bool)
+	e.multiPV = 1
+	e.broken = nil
+	go read(cmd, stdout, e.lines, e.exited)
+
+	if err := e.handshake(ctx, e.config); err != nil {
+		e.kill()
+		return err
+	}
+	return nil
+}
+
+// restart replaces a broken engine process with a new one.
+func (e *Engine) restart(ctx context.Context) error {
+	e.kill()
+	if err := e.start(ctx); err != nil {
+		return fmt.Errorf("%w, restarting it failed: %w", ErrExited, err)
+	}
+	return nil
+}
+
+// read passes the output of the engine process cmd to lines line by
+// line, then reaps the process once its output ends.
+func read(cmd *exec.Cmd, stdout io.Reader, lines chan<- string, exited chan<- struct{}) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines <- scanner.Text()
+	}
+	close(lines)
+	cmd.Wait()
+	close(exited)
+}
+
+// handshake sends "uci", records the engine's id and options, applies
+// options and waits for the engine to be ready.
+func (e *Engine) handshake(ctx context.Context, options map[string]string) error {
+	if err := e.send("uci"); err != nil {
+		return err
+	}
+	err := e.readUntil(ctx, func(line string) bool {
+		switch {
+		case strings.HasPrefix(line, "id name "):
+			e.Name = strings.TrimPrefix(line, "id name ")
+		case strings.HasPrefix(line, "id author "):
+			e.Author = strings.TrimPrefix(line, "id author ")
+		case strings.HasPrefix(line, "option name "):
+			name, _, _ := strings.Cut(strings.TrimPrefix(line, "option name "), " type ")
+			e.options[strings.ToLower(name)] = true
+		}
+		return line == "uciok"
+	})
+	if err != nil {
+		return fmt.Errorf("uci: handshake: %w", err)
+	}
+
+	for name, value := range options {
+		if err := e.setOption(name, value); err != nil {
+			return err
+		}
+	}
+	return e.ready(ctx)
+}
+
+// HasOption reports whether the engine declared the named option.
+func (e *Engine) HasOption(name string) bool {
+	return e.options[strings.ToLower(name)]
+}
+
+// setOption sets an engine option.
+func (e *Engine) setOption(name, value string) error {
+	if !e.HasOption(name) {
+		return fmt.Errorf("uci: engine has no option %q", name)
+	}
+	return e.send("setoption name " + name + " value " + value)
+}
+
+// ready waits for the engine to answer "isready".
+func (e *Engine) ready(ctx context.Context) error {
+	if err := e.send("isready"); err != nil {
+		return err
+	}
+	return e.readUntil(ctx, func(line string) bool {
+		return line == "readyok"
+	})
+}
+
+// Ping checks that the engine is alive and responsive, restarting it if
+// it is broken. An engine in the middle of a search is taken to be
+// alive.
+func (e *Engine) Ping(ctx context.Context) error {
+	if !e.mu.TryLock() {
+		return nil
+	}
+	defer e.mu.Unlock()
+	if e.broken != nil {
+		return e.restart(ctx)
+	}
+	return e.ready(ctx)
+}
+
+// send writes a command to the engine. Failing to means the engine has
+// gone away, so it is marked broken.
+func (e *Engine) send(cmd string) error {
+	if _, err := io.WriteString(e.stdin, cmd+"\n"); err != nil {
+		e.broken = ErrExited
+		return fmt.Errorf("%w: sending %q: %w", ErrExited, cmd, err)
+	}
+	return nil
+}
+
+// readUntil reads the engine's output until done returns true for a
+// line, the engine exits or ctx is done.
+func (e *Engine) readUntil(ctx context.Context, done func(line string) bool) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case line, ok := <-e.lines:
+			if !ok {
+				e.broken = ErrExited
+				return ErrExited
+			}
+			if done(strings.TrimSpace(line)) {
+				return nil
+			}
+		}
+	}
+}
+
+// Params describe a search. The position is FEN, or the start position
+// if empty, followed by Moves in UCI notation. The search stops at Depth
+// plies or after MoveTime, whichever comes first; with neither set it
+// runs for a second. MultiPV asks for that many best lines.
+type Params struct {
+	FEN      string
+	Moves    []string
+	Depth    int
+	MoveTime time.Duration
+	MultiPV  int
+}
+
+// Score is an evaluation from the point of view of the side to move,
+// either in centipawns or, if Mate is not zero, as a mate in Mate moves
+// (negative when being mated).
+type Score struct {
+	CP   int
+	Mate int
+}
+
+// Centipawns returns the score in centipawns, mapping mates to scores
+// beyond any material advantage like the built-in engine does.
+func (s Score) Centipawns() int {
+	switch {
+	case s.Mate > 0:
+		return engine.MateScore - (2*s.Mate - 1)
+	case s.Mate < 0:
+		return -engine.MateScore - 2*s.Mate
+	}
+	return s.CP
+}
+
+// Line is one principal variation reported by the engine, with its
+// moves in UCI notation.
+type Line struct {
+	MultiPV int
+	Depth   int
+	Score   Score
+	Nodes   int
+	PV      []string
+}
+
+// Result is the outcome of a search. Lines are the deepest lines the
+// engine reported, best first.
+type Result struct {
+	BestMove string
+	Ponder   string
+	Lines    []Line
+}
+
+// Search runs a search and waits for its best move, restarting the
+// engine first if it is broken. If ctx is done first, the engine is told
+// to stop and the search's result so far is returned.
+func (e *Engine) Search(ctx context.Context, p Params) (Result, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.broken != nil {
+		if err := e.restart(ctx); err != nil {
+			return Result{}, err
+		}
+	}
+
+	if p.MultiPV <= 0 {
+		p.MultiPV = 1
+	}
+	if p.MultiPV != e.multiPV && e.HasOption("MultiPV") {
+		if err := e.setOption("MultiPV", strconv.Itoa(p.MultiPV)); err != nil {
+			return Result{}, err
+		}
+		e.multiPV = p.MultiPV
+	}
+
+	position := "position startpos"
+	if p.FEN != "" {
+		position = "position fen " + p.FEN
+	}
+	if len(p.Moves) > 0 {
+		position += " moves " + strings.Join(p.Moves, " ")
+	}
+	if err := e.send(position); err != nil {
+		return Result{}, err
+	}
+	if err := e.ready(ctx); err != nil {
+		return Result{}, err
+	}
+
+	goCmd := "go"
+	if p.Depth > 0 {
+		goCmd += " depth " + strconv.Itoa(p.Depth)
+	}
+	if p.MoveTime > 0 || p.Depth <= 0 {
+		moveTime := p.MoveTime
+		if moveTime <= 0 {
+			moveTime = time.Second
+		}
+		goCmd += " movetime " + strconv.FormatInt(moveTime.Milliseconds(), 10)
+	}
+	if err := e.send(goCmd); err != nil {
+		return Result{}, err
+	}
+
+	return e.collect(ctx)
+}
+
+// collect reads the output of a search until its best move.
+func (e *Engine) collect(ctx context.Context) (Result, error) {
+	var res Result
+	lines := make(map[int]Line)
+	handle := func(line string) bool {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == "bestmove" {
+			if len(fields) > 1 {
+				res.BestMove = fields[1]
+			}
+			if len(fields) > 3 && fields[2] == "ponder" {
+				res.Ponder = fields[3]
+			}
+			return true
+		}
+		if l, ok := ParseInfo(line); ok && l.Depth >= lines[l.MultiPV].Depth {
+			lines[l.MultiPV] = l
+		}
+		return false
+	}
+
+	err := e.readUntil(ctx, handle)
+	if ctx.Err() != nil {
+
+		// Stop the search and wait a little for its best move, or give
+		// up on the engine.
+		err = e.send("stop")
+		if err == nil {
+			stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), stopGrace)
+			err = e.readUntil(stopCtx, handle)
+			cancel()
+		}
+		if err != nil {
+			e.kill()
+			return Result{}, ctx.Err()
+		}
+	} else if err != nil {
+		return Result{}, err
+	}
+
+	for _, l := range lines {
+		res.Lines = append(res.Lines, l)
+	}
+	slices.SortFunc(res.Lines, func(a, b Line) int {
+		return a.MultiPV - b.MultiPV
+	})
+	if res.BestMove == "" || res.BestMove == "(none)" {
+		return res, errors.New("uci: no best move, the position may be over")
+	}
+
+	return res, nil
+}
+
+// ParseInfo parses an "info" line carrying a scored principal variation,
+// such as "info depth 20 multipv 1 score cp 31 nodes 1000 pv e2e4 e7e5".
+// Other lines, and scores that are only bounds, are rejected.
+func ParseInfo(line string) (Line, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "info" {
+		return Line{}, false
+	}
+
+	l := Line{MultiPV: 1}
+	var scored bool
+	for i := 1; i < len(fields); i++ {
+		next := func() int {
+			if i+1 >= len(fields) {
+				return 0
+			}
+			i++
+			v, _ := strconv.Atoi(fields[i])
+			return v
+		}
+		switch fields[i] {
+		case "depth":
+			l.Depth = next()
+		case "multipv":
+			l.MultiPV = next()
+		case "nodes":
+			l.Nodes = next()
+		case "cp":
+			l.Score.CP = next()
+			scored = true
+		case "mate":
+			l.Score.Mate = next()
+			scored = true
+		case "lowerbound", "upperbound":
+			return Line{}, false
+		case "string":
+			return Line{}, false
+		case "pv":
+			l.PV = fields[i+1:]
+			i = len(fields)
+		}
+	}
+
+	return l, scored && len(l.PV) > 0
+}
+
+// Close asks the engine to quit, killing it if it does not.
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.send("quit")
+	e.stdin.Close()
+	select {
+	case <-e.exited:
+	case <-time.After(time.Second):
+		e.kill()
+	}
+	return nil
+}
+
+// kill ends the engine process and marks the engine as broken until it
+// is restarted. The reader goroutine drains the rest of its output.
+func (e *Engine) kill() {
+	e.broken = ErrExited
+	e.cmd.Process.Kill()
+	lines := e.lines
+	go func() {
+		for range lines {
+		}
+	}()
+}
@@ -0,0 +1,339 @@
+package uci
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The tests run the test binary itself as the engine: TestHelperProcess
+// turns it into a fake engine that logs the commands it gets to a file
+// and answers them with a script. The mode picks how it searches:
+//
+//	normal  reports two depths of multi-PV lines, then its best move
+//	hang    reports a line and waits for "stop"
+//	deaf    reports a line and ignores "stop"
+//	crash   exits
+//
+// A mode ending in "-once" misbehaves in the first process only, and
+// searches normally once restarted.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) != 3 {
+		fmt.Fprintln(os.Stderr, "want -- mode dir")
+		os.Exit(2)
+	}
+	fakeEngine(args[1], args[2])
+	os.Exit(0)
+}
+
+// fakePVs are the lines the fake engine reports, by multipv number.
+var fakePVs = [][]string{{"e2e4", "e7e5", "g1f3"}, {"d2d4", "d7d5"}, {"g1f3"}}
+
+func fakeEngine(mode, dir string) {
+	log, err := os.OpenFile(filepath.Join(dir, "commands"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		os.Exit(2)
+	}
+	defer log.Close()
+
+	if m, ok := strings.CutSuffix(mode, "-once"); ok {
+		mode = m
+		marker := filepath.Join(dir, "misbehaved")
+		if _, err := os.Stat(marker); err == nil {
+			mode = "normal"
+		}
+		os.WriteFile(marker, nil, 0o644)
+	}
+
+	multiPV := 1
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fmt.Fprintln(log, line)
+		fields := strings.Fields(line)
+		switch {
+		case line == "uci":
+			fmt.Println("id name Fake Engine 1.0")
+			fmt.Println("id author The Tests")
+			fmt.Println("option name Hash type spin default 16 min 1 max 1024")
+			fmt.Println("option name MultiPV type spin default 1 min 1 max 3")
+			fmt.Println("option name UCI_ShowWDL type check default false")
+			fmt.Println("uciok")
+		case line == "isready":
+			fmt.Println("readyok")
+		case line == "quit":
+			return
+		case strings.HasPrefix(line, "setoption name MultiPV value "):
+			multiPV, _ = strconv.Atoi(fields[4])
+		case line == "stop" && mode == "hang":
+			fmt.Println("bestmove e2e4 ponder e7e5")
+		case len(fields) > 0 && fields[0] == "go":
+			switch mode {
+			case "crash":
+				os.Exit(1)
+			case "hang", "deaf":
+				fmt.Println("info depth 1 multipv 1 score cp 20 nodes 20 pv e2e4")
+				continue
+			}
+			for depth := 1; depth <= 2; depth++ {
+				for k := 1; k <= multiPV; k++ {
+					fmt.Printf("info depth %d seldepth %d multipv %d score cp %d nodes %d nps 1000 pv %s\n",
+						depth, depth, k, 50-10*k+depth, 100*depth, strings.Join(fakePVs[k-1], " "))
+				}
+			}
+			fmt.Println("info depth 3 multipv 1 score cp 500 lowerbound nodes 300 pv d2d4")
+			fmt.Println("info string search done")
+			fmt.Println("bestmove e2e4 ponder e7e5")
+		}
+	}
+}
+
+// startFake starts the fake engine in mode with options, and returns it
+// with a function that returns the commands it was sent so far.
+func startFake(t *testing.T, mode string, options map[string]string) (*Engine, func() []string) {
+	t.Helper()
+	t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+	dir := t.TempDir()
+	commands := func() []string {
+		data, _ := os.ReadFile(filepath.Join(dir, "commands"))
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	e, err := Start(ctx, os.Args[0], []string{"-test.run=^TestHelperProcess$", "--", mode, dir}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e, commands
+}
+
+func TestStart(t *testing.T) {
+	e, commands := startFake(t, "normal", map[string]string{"Hash": "64"})
+	if e.Name != "Fake Engine 1.0" || e.Author != "The Tests" {
+		t.Errorf("got name %q and author %q", e.Name, e.Author)
+	}
+	if !e.HasOption("multipv") || !e.HasOption("UCI_ShowWDL") || e.HasOption("Threads") {
+		t.Errorf("options = %v", e.options)
+	}
+	want := []string{"uci", "setoption name Hash value 64", "isready"}
+	if got := commands(); !slices.Equal(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+	if err := e.Ping(context.Background()); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+
+	t.Setenv("GO_WANT_HELPER_PROCESS", "1")
+	_, err := Start(context.Background(), os.Args[0], []string{"-test.run=^TestHelperProcess$", "--", "normal", t.TempDir()}, map[string]string{"Threads": "4"})
+	if err == nil || !strings.Contains(err.Error(), "Threads") {
+		t.Errorf("starting with an unknown option: got %v", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	e, commands := startFake(t, "normal", nil)
+	fen := "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+	tests := []struct {
+		name    string
+		params  Params
+		sent    []string
+		multiPV int
+	}{
+		{
+			"multi-PV to a depth",
+			Params{FEN: fen, Moves: []string{"e2e4", "e7e5"}, Depth: 2, MultiPV: 2},
+			[]string{"setoption name MultiPV value 2", "position fen " + fen + " moves e2e4 e7e5", "isready", "go depth 2"},
+			2,
+		},
+		{
+			"by time",
+			Params{MoveTime: 50 * time.Millisecond},
+			[]string{"setoption name MultiPV value 1", "position startpos", "isready", "go movetime 50"},
+			1,
+		},
+		{
+			"depth and time",
+			Params{FEN: fen, Depth: 8, MoveTime: 1500 * time.Millisecond},
+			[]string{"position fen " + fen, "isready", "go depth 8 movetime 1500"},
+			1,
+		},
+		{
+			"unbounded runs for a second",
+			Params{MultiPV: 3},
+			[]string{"setoption name MultiPV value 3", "position startpos", "isready", "go movetime 1000"},
+			3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(commands())
+			res, err := e.Search(context.Background(), tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := commands()[before:]; !slices.Equal(got, tt.sent) {
+				t.Errorf("sent %q, want %q", got, tt.sent)
+			}
+
+			if res.BestMove != "e2e4" || res.Ponder != "e7e5" {
+				t.Errorf("best move %q, ponder %q, want e2e4 and e7e5", res.BestMove, res.Ponder)
+			}
+			if len(res.Lines) != tt.multiPV {
+				t.Fatalf("got %d lines, want %d", len(res.Lines), tt.multiPV)
+			}
+			for i, l := range res.Lines {
+				want := Line{MultiPV: i + 1, Depth: 2, Score: Score{CP: 50 - 10*(i+1) + 2}, Nodes: 200, PV: fakePVs[i]}
+				if l.MultiPV != want.MultiPV || l.Depth != want.Depth || l.Score != want.Score || l.Nodes != want.Nodes || !slices.Equal(l.PV, want.PV) {
+					t.Errorf("line %d = %+v, want %+v", i+1, l, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseInfo(t *testing.T) {
+	tests := []struct {
+		line string
+		want Line
+		ok   bool
+	}{
+		{"info depth 20 seldepth 28 multipv 1 score cp 31 nodes 1000 nps 5000 pv e2e4 e7e5", Line{MultiPV: 1, Depth: 20, Score: Score{CP: 31}, Nodes: 1000, PV: []string{"e2e4", "e7e5"}}, true},
+		{"info depth 12 multipv 3 score cp -45 pv d2d4", Line{MultiPV: 3, Depth: 12, Score: Score{CP: -45}, PV: []string{"d2d4"}}, true},
+		{"info depth 5 score mate 3 pv h5f7", Line{MultiPV: 1, Depth: 5, Score: Score{Mate: 3}, PV: []string{"h5f7"}}, true},
+		{"info depth 5 score mate -2 pv e1e2 d8h4", Line{MultiPV: 1, Depth: 5, Score: Score{Mate: -2}, PV: []string{"e1e2", "d8h4"}}, true},
+		{"info depth 18 multipv 1 score cp 40 lowerbound nodes 900 pv e2e4", Line{}, false},
+		{"info depth 18 multipv 1 score cp 10 upperbound nodes 900 pv e2e4", Line{}, false},
+		{"info depth 18 score mate 4 lowerbound pv e2e4", Line{}, false},
+		{"info depth 18 currmove e2e4 currmovenumber 1", Line{}, false},
+		{"info depth 18 score cp 10", Line{}, false},
+		{"info string NNUE evaluation enabled", Line{}, false},
+		{"bestmove e2e4 ponder e7e5", Line{}, false},
+		{"", Line{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseInfo(tt.line)
+		if ok != tt.ok || (ok && (got.MultiPV != tt.want.MultiPV || got.Depth != tt.want.Depth || got.Score != tt.want.Score || got.Nodes != tt.want.Nodes || !slices.Equal(got.PV, tt.want.PV))) {
+			t.Errorf("ParseInfo(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCentipawns(t *testing.T) {
+	for _, tt := range []struct {
+		score Score
+		want  int
+	}{
+		{Score{CP: 31}, 31},
+		{Score{CP: -120}, -120},
+		{Score{Mate: 1}, 99999},
+		{Score{Mate: 3}, 99995},
+		{Score{Mate: -1}, -99998},
+		{Score{Mate: -3}, -99994},
+	} {
+		if got := tt.score.Centipawns(); got != tt.want {
+			t.Errorf("%+v.Centipawns() = %d, want %d", tt.score, got, tt.want)
+		}
+	}
+}
+
+// TestSearchStopped cancels a search the engine answers "stop" for: the
+// result so far comes back and the engine keeps running.
+func TestSearchStopped(t *testing.T) {
+	e, commands := startFake(t, "hang", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	res, err := e.Search(ctx, Params{Depth: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.BestMove != "e2e4" || len(res.Lines) != 1 || res.Lines[0].Score.CP != 20 {
+		t.Errorf("got %+v, want e2e4 with the line so far", res)
+	}
+	if got := commands(); got[len(got)-1] != "stop" {
+		t.Errorf("last command %q, want stop", got[len(got)-1])
+	}
+	if err := e.Ping(context.Background()); err != nil {
+		t.Errorf("Ping after stop: %v", err)
+	}
+	if n := count(commands(), "uci"); n != 1 {
+		t.Errorf("engine started %d times, want once", n)
+	}
+}
+
+// TestSearchKilled cancels a search the engine doesn't answer "stop"
+// for: it is killed, and restarted for the next search.
+func TestSearchKilled(t *testing.T) {
+	e, commands := startFake(t, "deaf-once", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := e.Search(ctx, Params{Depth: 30}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the context's error", err)
+	}
+	if d := time.Since(start); d < stopGrace {
+		t.Errorf("gave up after %v, want at least %v", d, stopGrace)
+	}
+
+	res, err := e.Search(context.Background(), Params{Depth: 2})
+	if err != nil || res.BestMove != "e2e4" {
+		t.Fatalf("search after the kill: got %+v, %v", res, err)
+	}
+	if n := count(commands(), "uci"); n != 2 {
+		t.Errorf("engine started %d times, want twice", n)
+	}
+}
+
+// TestRestart checks that an engine that exits is restarted by the next
+// call, and that ErrExited is returned when it can't be.
+func TestRestart(t *testing.T) {
+	e, commands := startFake(t, "crash-once", nil)
+	if _, err := e.Search(context.Background(), Params{Depth: 2}); !errors.Is(err, ErrExited) {
+		t.Fatalf("search of a crashing engine: got %v, want ErrExited", err)
+	}
+	res, err := e.Search(context.Background(), Params{Depth: 2, MultiPV: 2})
+	if err != nil || len(res.Lines) != 2 {
+		t.Fatalf("search after the crash: got %+v, %v", res, err)
+	}
+	if n := count(commands(), "uci"); n != 2 {
+		t.Errorf("engine started %d times, want twice", n)
+	}
+
+	// Killing it with a path that no longer works leaves it broken.
+	e.path = filepath.Join(t.TempDir(), "missing")
+	e.kill()
+	if _, err := e.Search(context.Background(), Params{Depth: 2}); !errors.Is(err, ErrExited) {
+		t.Errorf("search with a missing engine: got %v, want ErrExited", err)
+	}
+	if err := e.Ping(context.Background()); !errors.Is(err, ErrExited) {
+		t.Errorf("Ping with a missing engine: got %v, want ErrExited", err)
+	}
+}
+
+// count returns how many times s occurs in list.
+func count(list []string, s string) int {
+	var n int
+	for _, v := range list {
+		if v == s {
+			n++
+		}
+	}
+	return n
+}
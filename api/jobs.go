@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/gorilla/mux"
+	"github.com/notnil/chess"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// maxBatchGames caps the games in a batch-analyze job.
+	maxBatchGames = 20
+
+	// maxAnnotatePlies caps the moves of an annotate-game job, each of
+	// which the LLM comments on.
+	maxAnnotatePlies = 200
+
+	// maxJobAttempts is how many times a job interrupted by a crash is
+	// retried before it is failed.
+	maxJobAttempts = 3
+
+	// jobPollInterval is how often idle workers look for queued jobs
+	// that they were not woken for, e.g. after a restart.
+	jobPollInterval = 5 * time.Second
+)
+
+// errJobQueueFull is returned when too many jobs are waiting to run.
+var errJobQueueFull = errors.New("job queue is full, try again later")
+
+// runJobs runs queued jobs on cfg.JobWorkers workers until ctx is done.
+// Jobs live in the api_jobs table, which doubles as the queue: workers
+// claim the oldest queued job, so jobs outlive the process. Jobs cut
+// short by ctx are queued again for the next start. This assumes one
+// API instance per database.
+func (s *Server) runJobs(ctx context.Context) {
+
+	// Requeue the jobs that were running when the API last stopped
+	// without a chance to hand them back.
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE api_jobs SET
+			status = CASE WHEN attempts >= $1 THEN 'failed' ELSE 'queued' END,
+			error = CASE WHEN attempts >= $1 THEN 'interrupted too many times' ELSE '' END,
+			finished_at = CASE WHEN attempts >= $1 THEN now() END
+		WHERE status = 'running'`, maxJobAttempts)
+	if err != nil {
+		slog.ErrorContext(ctx, "requeueing jobs failed", "error", err)
+	} else if n, _ := res.RowsAffected(); n > 0 {
+		slog.InfoContext(ctx, "requeued interrupted jobs", "count", n)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.cfg.JobWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.jobWorker(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// jobWorker runs queued jobs one at a time, sleeping when there are none.
+func (s *Server) jobWorker(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			ran, err := s.runNextJob(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "running job failed", "error", err)
+				break
+			}
+			if !ran {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.jobWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// wakeJobWorker tells an idle worker that a job was queued.
+func (s *Server) wakeJobWorker() {
+	select {
+	case s.jobWake <- struct{}{}:
+	default:
+	}
+}
+
+// runNextJob claims the oldest queued job and runs it, reporting whether
+// there was one.
+func (s *Server) runNextJob(ctx context.Context) (bool, error) {
+	var id, subject, task string
+	var raw []byte
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE api_jobs SET status = 'running', started_at = now(), attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM api_jobs WHERE status = 'queued'
+			ORDER BY created_at LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, subject, task, request`).Scan(&id, &subject, &task, &raw)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("claiming job: %w", err)
+	}
+
+	var req CreateJobRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return true, s.finishJob(ctx, id, nil, fmt.Errorf("decoding request: %w", err))
+	}
+
+	jobCtx, span := tracer.Start(ctx, "job", trace.WithNewRoot(), trace.WithAttributes(
+		attribute.String("job.id", id),
+		attribute.String("job.task", task),
+	))
+	jobCtx, cancel := context.WithTimeout(jobCtx, s.cfg.JobTimeout)
+	defer cancel()
+
+	start := time.Now()
+	slog.InfoContext(jobCtx, "job started", "job", id, "task", task, "subject", subject)
+	result, err := s.runJob(jobCtx, req)
+	endSpan(span, err)
+
+	// Hand the job back if the API is shutting down.
+	if ctx.Err() != nil {
+		jobDuration.WithLabelValues(task, "interrupted").Observe(time.Since(start).Seconds())
+		slog.InfoContext(jobCtx, "job interrupted", "job", id, "task", task)
+		_, err := s.db.ExecContext(context.WithoutCancel(ctx),
+			"UPDATE api_jobs SET status = 'queued', started_at = NULL WHERE id = $1", id)
+		return true, err
+	}
+
+	status := chessapi.JobSucceeded
+	if err != nil {
+		status = chessapi.JobFailed
+	}
+	jobDuration.WithLabelValues(task, status).Observe(time.Since(start).Seconds())
+	slog.InfoContext(jobCtx, "job finished", "job", id, "task", task, "status", status, "duration", time.Since(start), "error", err)
+
+	return true, s.finishJob(ctx, id, result, err)
+}
+
+// finishJob records the result or error of a job.
+func (s *Server) finishJob(ctx context.Context, id string, result *chessapi.JobResult, jobErr error) error {
+	status, errText := chessapi.JobSucceeded, ""
+	var raw []byte
+	if jobErr != nil {
+		status, errText = chessapi.JobFailed, jobErr.Error()
+	} else {
+		var err error
+		if raw, err = json.Marshal(result); err != nil {
+			return fmt.Errorf("encoding job result: %w", err)
+		}
+	}
+
+	_, err := s.db.ExecContext(context.WithoutCancel(ctx), `
+		UPDATE api_jobs SET status = $2, result = $3, error = $4, finished_at = now()
+		WHERE id = $1`, id, status, raw, errText)
+	if err != nil {
+		return fmt.Errorf("recording job: %w", err)
+	}
+	return nil
+}
+
+// runJob runs the task of a job.
+func (s *Server) runJob(ctx context.Context, req CreateJobRequest) (*chessapi.JobResult, error) {
+	switch req.Task {
+	case chessapi.JobTaskHelp:
+		game, err := parsePGN(req.Game)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.genHelp(ctx, game, req.Game, helpObserver{})
+		if err != nil {
+			return nil, err
+		}
+		return &chessapi.JobResult{Help: &resp}, nil
+
+	case chessapi.JobTaskAnnotateGame:
+		game, err := parsePGN(req.Game)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.annotateGame(ctx, game)
+		if err != nil {
+			return nil, err
+		}
+		return &chessapi.JobResult{AnnotateGame: &resp}, nil
+
+	case chessapi.JobTaskBatchAnalyze:
+		resp := s.batchAnalyze(ctx, req.Games)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return &chessapi.JobResult{BatchAnalyze: &resp}, nil
+	}
+
+	return nil, fmt.Errorf("unknown task %q", req.Task)
+}
+
+// annotateGame asks the LLM to comment on every move of game.
+func (s *Server) annotateGame(ctx context.Context, game *chess.Game) (chessapi.AnnotateGameResult, error) {
+	moves := game.Moves()
+	positions := game.Positions()
+	resp := chessapi.AnnotateGameResult{Moves: []chessapi.MoveAnnotation{}}
+
+	var played []string
+	var annotated strings.Builder
+	for i, m := range moves {
+		san := chess.AlgebraicNotation{}.Encode(positions[i], m)
+		color := strings.ToLower(positions[i].Turn().Name())
+		num := i/2 + 1
+		if i%2 == 0 {
+			played = append(played, fmt.Sprintf("%d. %s", num, san))
+		} else {
+			played = append(played, san)
+		}
+
+		comment, err := s.generateCommentaryWithLLM(ctx, strings.Join(played, " "), san, color, nil)
+		if err != nil {
+			return chessapi.AnnotateGameResult{}, fmt.Errorf("move %d: %w", i+1, err)
+		}
+		comment = strings.TrimSpace(comment)
+		resp.Moves = append(resp.Moves, chessapi.MoveAnnotation{
+			Ply:     i + 1,
+			Move:    san,
+			Color:   color,
+			Comment: comment,
+		})
+
+		// Black's moves are numbered again after a comment, and PGN
+		// comments cannot hold braces.
+		if i%2 == 0 {
+			fmt.Fprintf(&annotated, "%d. %s", num, san)
+		} else {
+			fmt.Fprintf(&annotated, "%d... %s", num, san)
+		}
+		fmt.Fprintf(&annotated, " {%s} ", commentBraces.Replace(comment))
+	}
+	annotated.WriteString(string(game.Outcome()))
+	resp.Game = annotated.String()
+
+	return resp, nil
+}
+
+// commentBraces swaps braces for parentheses in PGN comments.
+var commentBraces = strings.NewReplacer("{", "(", "}", ")")
+
+// batchAnalyze describes each of games. A game that fails is reported
+// in its analysis rather than failing the batch.
+func (s *Server) batchAnalyze(ctx context.Context, games []string) chessapi.BatchAnalyzeResult {
+	resp := chessapi.BatchAnalyzeResult{Games: []chessapi.GameAnalysis{}}
+	for i, pgn := range games {
+		if ctx.Err() != nil {
+			break
+		}
+
+		analysis := chessapi.GameAnalysis{Index: i}
+		game, err := parsePGN(pgn)
+		if err == nil {
+			state := gameState(game)
+			analysis.Outcome = state.Outcome
+			analysis.Method = state.Method
+			analysis.Plies = len(state.Moves)
+			analysis.Opening = state.Opening
+			analysis.Description, err = s.generateGameDescWithLLM(ctx, pgn, s.analyzePosition(ctx, game).Facts)
+		}
+		if err != nil {
+			analysis.Error = err.Error()
+		}
+		resp.Games = append(resp.Games, analysis)
+	}
+	return resp
+}
+
+// parsePGN parses a game in PGN.
+func parsePGN(game string) (*chess.Game, error) {
+	pgn, err := chess.PGN(bytes.NewReader([]byte(game)))
+	if err != nil {
+		return nil, err
+	}
+	return chess.NewGame(pgn), nil
+}
+
+// validateJob checks that a job request can be run and returns its
+// cost: the number of LLM calls it makes, which count against the
+// caller's quota like as many metered requests.
+func validateJob(req CreateJobRequest) (int, error) {
+	switch req.Task {
+	case chessapi.JobTaskHelp:
+		if _, err := parsePGN(req.Game); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	case chessapi.JobTaskAnnotateGame:
+		game, err := parsePGN(req.Game)
+		if err != nil {
+			return 0, err
+		}
+		plies := len(game.Moves())
+		if plies > maxAnnotatePlies {
+			return 0, fmt.Errorf("annotate-game takes games of up to %d plies", maxAnnotatePlies)
+		}
+		return max(plies, 1), nil
+	case chessapi.JobTaskBatchAnalyze:
+		if len(req.Games) == 0 || len(req.Games) > maxBatchGames {
+			return 0, fmt.Errorf("batch-analyze takes 1 to %d games", maxBatchGames)
+		}
+		return len(req.Games), nil
+	}
+	return 0, fmt.Errorf("unknown task %q", req.Task)
+}
+
+// getJob loads a job owned by subject.
+func (s *Server) getJob(ctx context.Context, id, subject string) (JobResponse, error) {
+	var job JobResponse
+	var result []byte
+	var started, finished sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, task, status, result, error, created_at, started_at, finished_at
+		FROM api_jobs WHERE id = $1 AND subject = $2`, id, subject).
+		Scan(&job.ID, &job.Task, &job.Status, &result, &job.Error, &job.CreatedAt, &started, &finished)
+	if err != nil {
+		return JobResponse{}, err
+	}
+	if started.Valid {
+		job.StartedAt = &started.Time
+	}
+	if finished.Valid {
+		job.FinishedAt = &finished.Time
+	}
+	if result != nil {
+		if err := json.Unmarshal(result, &job.Result); err != nil {
+			return JobResponse{}, fmt.Errorf("decoding job result: %w", err)
+		}
+	}
+	return job, nil
+}
+
+// CreateJob queues a long-running task.
+func (s *Server) CreateJob(w http.ResponseWriter, r *http.Request) {
+
+	// Parse the body into a value of CreateJobRequest.
+	var req CreateJobRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cost, err := validateJob(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Auth charged the request as one call; charge the job's other LLM
+	// calls up front, and give them back if the job isn't queued.
+	p, _ := principalFromContext(r.Context())
+	err = s.chargeQuota(r.Context(), p, "CreateJob", cost-1)
+	switch {
+	case errors.Is(err, errQuotaExceeded):
+		http.Error(w, fmt.Sprintf("%v: the job makes %d LLM calls", err, cost), http.StatusTooManyRequests)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	queued := false
+	defer func() {
+		if !queued && p.Subject != "" && cost > 1 {
+			if err := s.recordUsage(context.WithoutCancel(r.Context()), p.Subject, "CreateJob", 1-cost); err != nil {
+				slog.WarnContext(r.Context(), "refunding job quota failed", "error", err)
+			}
+		}
+	}()
+
+	// Queue the job, unless too many are waiting already.
+	raw, err := json.Marshal(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id := newID()
+	res, err := s.db.ExecContext(r.Context(), `
+		INSERT INTO api_jobs (id, subject, task, status, request)
+		SELECT $1, $2, $3, 'queued', $4
+		WHERE (SELECT count(*) FROM api_jobs WHERE status = 'queued') < $5`,
+		id, p.Subject, req.Task, raw, s.cfg.JobQueueLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, errJobQueueFull.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	queued = true
+	s.wakeJobWorker()
+
+	// Prep the response.
+	resp, err := s.getJob(r.Context(), id, p.Subject)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return the response.
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+id)
+	w.WriteHeader(http.StatusAccepted)
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetJob returns the status and, once finished, the result of a job.
+// Callers only see their own jobs.
+func (s *Server) GetJob(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalFromContext(r.Context())
+	resp, err := s.getJob(r.Context(), mux.Vars(r)["id"], p.Subject)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Return the response.
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
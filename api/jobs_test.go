@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+)
+
+func TestValidateJob(t *testing.T) {
+	// A game of 2*n plies, the knights going out and back.
+	game := func(n int) string {
+		var b strings.Builder
+		for i := range n {
+			if i%2 == 0 {
+				fmt.Fprintf(&b, "%d. Nf3 Nf6 ", i+1)
+			} else {
+				fmt.Fprintf(&b, "%d. Ng1 Ng8 ", i+1)
+			}
+		}
+		return b.String() + "*"
+	}
+
+	tests := []struct {
+		name string
+		req  CreateJobRequest
+		cost int // 0 for an invalid request
+	}{
+		{"help", CreateJobRequest{Task: chessapi.JobTaskHelp, Game: "1. e4 e5 *"}, 1},
+		{"annotate costs a call per move", CreateJobRequest{Task: chessapi.JobTaskAnnotateGame, Game: game(5)}, 10},
+		{"annotate at the cap", CreateJobRequest{Task: chessapi.JobTaskAnnotateGame, Game: game(maxAnnotatePlies / 2)}, maxAnnotatePlies},
+		{"annotate over the cap", CreateJobRequest{Task: chessapi.JobTaskAnnotateGame, Game: game(maxAnnotatePlies/2 + 1)}, 0},
+		{"annotate without moves", CreateJobRequest{Task: chessapi.JobTaskAnnotateGame, Game: "*"}, 1},
+		{"batch costs a call per game", CreateJobRequest{Task: chessapi.JobTaskBatchAnalyze, Games: []string{"*", "*", "*"}}, 3},
+		{"batch over the cap", CreateJobRequest{Task: chessapi.JobTaskBatchAnalyze, Games: make([]string, maxBatchGames+1)}, 0},
+		{"empty batch", CreateJobRequest{Task: chessapi.JobTaskBatchAnalyze}, 0},
+		{"unknown task", CreateJobRequest{Task: "solve-chess"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cost, err := validateJob(tt.req)
+			switch {
+			case tt.cost == 0 && err == nil:
+				t.Errorf("got cost %d, want an error", cost)
+			case tt.cost != 0 && (err != nil || cost != tt.cost):
+				t.Errorf("got cost %d, %v, want %d", cost, err, tt.cost)
+			}
+		})
+	}
+}
@@ -3,29 +3,134 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
 	"strings"
 	"time"
 
 	"github.com/predictionguard/go-client"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// llmLogger forwards the LLM client's logs to slog at debug level.
 var llmLogger = func(ctx context.Context, msg string, v ...any) {
-	s := fmt.Sprintf("msg: %s", msg)
-	for i := 0; i < len(v); i = i + 2 {
-		s = s + fmt.Sprintf(", %s: %v", v[i], v[i+1])
+	slog.DebugContext(ctx, msg, append([]any{"component", "llm_client"}, v...)...)
+}
+
+// chat sends input to the LLM and records latency and estimated token
+// metrics under task.
+func (s *Server) chat(ctx context.Context, task string, input client.ChatInput) (content string, err error) {
+	ctx, span := tracer.Start(ctx, "llm.chat", trace.WithAttributes(
+		attrLLMTask.String(task),
+		attrLLMModel.String(input.Model.String()),
+	))
+	defer func() { endSpan(span, err) }()
+
+	start := time.Now()
+	resp, err := s.llm.Chat(ctx, input)
+
+	status := "ok"
+	if err != nil {
+		status = "error"
 	}
-	log.Println(s)
+	llmCallDuration.WithLabelValues(task, status).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return "", fmt.Errorf("ERROR: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("ERROR: no choices returned for %s", task)
+	}
+
+	content = resp.Choices[0].Message.Content
+	s.observeChat(ctx, span, task, input.Model, input.Messages, content, start)
+
+	return content, nil
 }
 
-var host = "https://api.predictionguard.com"
-var apiKey = os.Getenv("PREDICTIONGUARD_API_KEY")
+// chatStream is chat for streaming completions. onToken, if not nil, is
+// called with each piece of the completion as it arrives and the full
+// completion is returned once the stream ends.
+func (s *Server) chatStream(ctx context.Context, task string, input client.ChatSSEInput, onToken func(string)) (content string, err error) {
+	ctx, span := tracer.Start(ctx, "llm.chat", trace.WithAttributes(
+		attrLLMTask.String(task),
+		attrLLMModel.String(input.Model.String()),
+		attrLLMStream.Bool(true),
+	))
+	defer func() { endSpan(span, err) }()
+
+	start := time.Now()
+	ch := make(chan client.ChatSSE, 16)
+	err = s.llm.ChatSSE(ctx, input, ch)
+	if err == nil {
+		var b strings.Builder
+		for resp := range ch {
+			for _, choice := range resp.Choices {
+				if choice.Delta.Content == "" {
+					continue
+				}
+				b.WriteString(choice.Delta.Content)
+				if onToken != nil {
+					onToken(choice.Delta.Content)
+				}
+			}
+		}
+		content = b.String()
+
+		// The client closes the stream on cancellation without an error.
+		switch {
+		case ctx.Err() != nil:
+			err = ctx.Err()
+		case content == "":
+			err = fmt.Errorf("no content streamed for %s", task)
+		}
+	}
 
-func parseMoveWithLLM(moveRequest string, pieceList string) (string, error) {
-	cln := client.New(llmLogger, host, apiKey)
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	llmCallDuration.WithLabelValues(task, status).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return "", fmt.Errorf("ERROR: %w", err)
+	}
+
+	s.observeChat(ctx, span, task, input.Model, input.Messages, content, start)
+
+	return content, nil
+}
+
+// observeChat records token metrics and logs for a successful chat call.
+func (s *Server) observeChat(ctx context.Context, span trace.Span, task string, model client.Model, messages []client.ChatInputMessage, content string, start time.Time) {
+	var promptTokens int
+	for _, msg := range messages {
+		promptTokens += estimateTokens(msg.Content)
+	}
+	completionTokens := estimateTokens(content)
+
+	llmTokens.WithLabelValues(task, "prompt").Add(float64(promptTokens))
+	llmTokens.WithLabelValues(task, "completion").Add(float64(completionTokens))
+	span.SetAttributes(
+		attrLLMPromptTokens.Int(promptTokens),
+		attrLLMCompletionTokens.Int(completionTokens),
+	)
+
+	// Prompts can contain user input, so full text is debug only.
+	slog.InfoContext(ctx, "llm call",
+		"task", task,
+		"model", model.String(),
+		"duration", time.Since(start),
+		"prompt_tokens", promptTokens,
+		"completion_tokens", completionTokens,
+	)
+	if slog.Default().Enabled(ctx, slog.LevelDebug) {
+		for _, msg := range messages {
+			slog.DebugContext(ctx, "llm prompt", "task", task, "role", msg.Role.String(), "content", msg.Content)
+		}
+		slog.DebugContext(ctx, "llm completion", "task", task, "content", content)
+	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (s *Server) parseMoveWithLLM(ctx context.Context, moveRequest string, pieceList string, color string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	input := client.ChatInput{
@@ -37,25 +142,18 @@ func parseMoveWithLLM(moveRequest string, pieceList string) (string, error) {
 			},
 			{
 				Role:    client.Roles.User,
-				Content: "Move by white: " + moveRequest,
+				Content: "Move by " + color + ": " + moveRequest,
 			},
 		},
 		MaxTokens:   10,
 		Temperature: 0.1,
 	}
 
-	resp, err := cln.Chat(ctx, input)
-	if err != nil {
-		return "", fmt.Errorf("ERROR: %w", err)
-	}
-
-	return resp.Choices[0].Message.Content, nil
+	return s.chat(ctx, "parse_move", input)
 }
 
-func generateMoveWithLLM(board string, pgn string, invalid []string) (string, error) {
-	cln := client.New(llmLogger, host, apiKey)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (s *Server) generateMoveWithLLM(ctx context.Context, board string, pgn string, invalid []string, blunders []string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	//messageContent := strings.Replace(board+"\n\n"+pgn+"\n\n"+"Chess expert move: ", "\n", "\\n", -1)
@@ -64,13 +162,16 @@ func generateMoveWithLLM(board string, pgn string, invalid []string) (string, er
 	messageContent += "\n\nHistory of moves (PGN format):\n" + pgn
 	if len(invalid) > 0 {
 		messageContent += "\n\nInvalid moves (Do NOT respond with one of the following listed invalid moves.): " + strings.Join(invalid, ", ")
-		messageContent += "\n\nNext chess move (different from the invalid moves): "
+	}
+	if len(blunders) > 0 {
+		messageContent += "\n\nBlunders (Do NOT respond with one of the following moves, they were rejected for these reasons.):\n- " + strings.Join(blunders, "\n- ")
+	}
+	if len(invalid) > 0 || len(blunders) > 0 {
+		messageContent += "\n\nNext chess move (different from the invalid moves and blunders): "
 	} else {
 		messageContent += "\n\nNext black chess move: "
 	}
 
-	fmt.Println(messageContent)
-
 	input := client.ChatInput{
 		Model: client.Models.Hermes2ProLlama38B,
 		Messages: []client.ChatInputMessage{
@@ -89,13 +190,11 @@ func generateMoveWithLLM(board string, pgn string, invalid []string) (string, er
 		TopK:        50.0,
 	}
 
-	resp, err := cln.Chat(ctx, input)
+	move, err := s.chat(ctx, "generate_move", input)
 	if err != nil {
-		return "", fmt.Errorf("ERROR: %w", err)
+		return "", err
 	}
-
-	move := resp.Choices[0].Message.Content
-	fmt.Println(move)
+	slog.DebugContext(ctx, "llm move response", "raw", move)
 
 	// if "..." in move, split on this substring.
 	if strings.Contains(move, "...") {
@@ -117,10 +216,17 @@ func generateMoveWithLLM(board string, pgn string, invalid []string) (string, er
 	return move, nil
 }
 
-func generateGameDescWithLLM(game string) (string, error) {
-	cln := client.New(llmLogger, host, apiKey)
+// factsPrompt lists verified facts about the current position for a
+// prompt, or returns "" if there are none.
+func factsPrompt(facts []string) string {
+	if len(facts) == 0 {
+		return ""
+	}
+	return "\n\nVerified facts about the current position (from analysis by a chess engine; trust these over your own reading of the moves):\n- " + strings.Join(facts, "\n- ")
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (s *Server) generateGameDescWithLLM(ctx context.Context, game string, facts []string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	input := client.ChatInput{
@@ -128,61 +234,152 @@ func generateGameDescWithLLM(game string) (string, error) {
 		Messages: []client.ChatInputMessage{
 			{
 				Role:    client.Roles.System,
-				Content: "You are a chess expert. Given information about a chess game (in standard Algebraic notation), you respond with a concise description of the game. Make brief observations about the strategies or tactics employed.",
+				Content: "You are a chess expert. Given information about a chess game (in standard Algebraic notation), you respond with a concise description of the game. Make brief observations about the strategies or tactics employed. Base any claims about the current position on the verified facts, if given.",
 			},
 			{
 				Role:    client.Roles.User,
-				Content: "Game: " + game,
+				Content: "Game: " + game + factsPrompt(facts),
 			},
 		},
 		MaxTokens:   500,
 		Temperature: 0.1,
 	}
 
-	resp, err := cln.Chat(ctx, input)
-	if err != nil {
-		return "", fmt.Errorf("ERROR: %w", err)
+	return s.chat(ctx, "describe_game", input)
+}
+
+// generateHintWithLLM explains in a sentence why the best move is worth
+// playing, giving away no more than reveal allows.
+func (s *Server) generateHintWithLLM(ctx context.Context, game string, facts []string, move string, reveal string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	input := client.ChatInput{
+		Model: client.Models.Hermes2ProLlama38B,
+		Messages: []client.ChatInputMessage{
+			{
+				Role:    client.Roles.System,
+				Content: "You are a chess coach giving a player a hint. Given a chess game (in standard Algebraic notation), verified facts about the position and the best move, you respond with exactly one short sentence saying why the player should look there. " + reveal + " Base any claims about the position on the verified facts.",
+			},
+			{
+				Role:    client.Roles.User,
+				Content: "Game: " + game + factsPrompt(facts) + "\n\nBest move: " + move,
+			},
+		},
+		MaxTokens:   60,
+		Temperature: 0.2,
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	return s.chat(ctx, "hint", input)
 }
 
-// qAPromptTemplate is a template for a question and answer prompt.
-func qAPromptTemplate(context, game, question string) string {
-	return fmt.Sprintf(`Relevant reference information: "%s"
+// generateMoveQualityWithLLM explains in a sentence what a move missed
+// compared with the engine's best move and line.
+func (s *Server) generateMoveQualityWithLLM(ctx context.Context, game string, move string, class string, best string, bestLine []string, reply string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
 
-Current game (PGN format): "%s"
+	messageContent := "Game: " + game + "\n\nMove played: " + move + " (" + class + ")\n\nBest move: " + best
+	if len(bestLine) > 0 {
+		messageContent += "\n\nBest line: " + strings.Join(bestLine, " ")
+	}
+	if reply != "" {
+		messageContent += "\n\nOpponent's best reply to the move played: " + reply
+	}
 
-Question: "%s"
-`, context, game, question)
-}
+	input := client.ChatInput{
+		Model: client.Models.Hermes2ProLlama38B,
+		Messages: []client.ChatInputMessage{
+			{
+				Role:    client.Roles.System,
+				Content: "You are a chess coach reviewing a player's move. Given a chess game (in standard Algebraic notation) before the move, the move played, the best move and the engine's lines, you respond with exactly one short sentence saying what the move played missed or allowed. Only mention moves from the lines you are given.",
+			},
+			{
+				Role:    client.Roles.User,
+				Content: messageContent,
+			},
+		},
+		MaxTokens:   60,
+		Temperature: 0.2,
+	}
 
-func generateQAWithLLM(content, game, question string) (string, error) {
-	cln := client.New(llmLogger, host, apiKey)
+	return s.chat(ctx, "move_quality", input)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// generateCommentaryWithLLM comments on the move just played, passing
+// the commentary to onToken, if not nil, as it streams in.
+func (s *Server) generateCommentaryWithLLM(ctx context.Context, game string, move string, color string, onToken func(string)) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, streamTimeout)
 	defer cancel()
 
-	input := client.ChatInput{
+	input := client.ChatSSEInput{
 		Model: client.Models.Hermes2ProLlama38B,
 		Messages: []client.ChatInputMessage{
 			{
 				Role:    client.Roles.System,
-				Content: "You are a chess tutor. Given reference information and information about moves in a current chess game (in PGN format), you respond with advice regarding the current game (what to consider, what to avoid, etc.). Make brief observations about the strategies or tactics. Focuse on the current game and providing advice for the next move.",
+				Content: "You are a lively chess commentator. Given a chess game (in standard Algebraic notation) and the move just played, you respond with one or two short sentences of commentary on that move. Mention the idea behind the move or what it threatens. Do not suggest moves for either player.",
 			},
 			{
 				Role:    client.Roles.User,
-				Content: qAPromptTemplate(content, game, question),
+				Content: "Game: " + game + "\n\nMove just played by " + color + ": " + move,
 			},
 		},
-		MaxTokens:   500,
-		Temperature: 0.1,
+		MaxTokens:   100,
+		Temperature: 0.5,
 	}
 
-	resp, err := cln.Chat(ctx, input)
-	if err != nil {
-		return "", fmt.Errorf("ERROR: %w", err)
+	return s.chatStream(ctx, "commentary", input, onToken)
+}
+
+// qAPromptTemplate is a template for a question and answer prompt.
+func qAPromptTemplate(context, game, question string, facts []string) string {
+	return fmt.Sprintf(`Relevant reference information: "%s"
+
+Current game (PGN format): "%s"%s
+
+Question: "%s"
+`, context, game, factsPrompt(facts), question)
+}
+
+// streamTimeout bounds streamed calls, which keep the connection open
+// for the whole completion rather than just the time to first byte.
+const streamTimeout = 30 * time.Second
+
+func (s *Server) generateQAWithLLM(ctx context.Context, content, game, question string, facts []string, onToken func(string)) (string, error) {
+	messages := []client.ChatInputMessage{
+		{
+			Role:    client.Roles.System,
+			Content: "You are a chess tutor. Given reference information and information about moves in a current chess game (in PGN format), you respond with advice regarding the current game (what to consider, what to avoid, etc.). Make brief observations about the strategies or tactics. Focuse on the current game and providing advice for the next move. Never contradict the verified facts about the current position.",
+		},
+		{
+			Role:    client.Roles.User,
+			Content: qAPromptTemplate(content, game, question, facts),
+		},
+	}
+
+	// Stream the answer if the caller wants it token by token.
+	if onToken != nil {
+		ctx, cancel := context.WithTimeout(ctx, streamTimeout)
+		defer cancel()
+
+		input := client.ChatSSEInput{
+			Model:       client.Models.Hermes2ProLlama38B,
+			Messages:    messages,
+			MaxTokens:   500,
+			Temperature: 0.1,
+		}
+		return s.chatStream(ctx, "qa", input, onToken)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	input := client.ChatInput{
+		Model:       client.Models.Hermes2ProLlama38B,
+		Messages:    messages,
+		MaxTokens:   500,
+		Temperature: 0.1,
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	return s.chat(ctx, "qa", input)
 }
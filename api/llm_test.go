@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/predictionguard/go-client"
+)
+
+// fakeLLM serves chat completions from reply, which gets the system and
+// user prompts of each call, and returns a client for it. Streamed calls
+// get the reply a word at a time. An error from reply fails the call.
+func fakeLLM(t *testing.T, reply func(system, user string) (string, error)) *client.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"messages"`
+			Stream bool `json:"stream"`
+		}
+		if r.URL.Path != "/chat/completions" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.Error(w, `{"error":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+		var system, user string
+		for _, m := range req.Messages {
+			switch m.Role {
+			case "system":
+				system = m.Content
+			case "user":
+				user = m.Content
+			}
+		}
+
+		content, err := reply(system, user)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if !req.Stream {
+			json.NewEncoder(w).Encode(map[string]any{
+				"choices": []any{map[string]any{"message": map[string]string{"role": "assistant", "content": content}}},
+			})
+			return
+		}
+		for i, word := range strings.SplitAfter(content, " ") {
+			data, _ := json.Marshal(map[string]any{
+				"choices": []any{map[string]any{"index": i, "delta": map[string]string{"content": word}}},
+			})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+	return client.New(llmLogger, srv.URL, "test")
+}
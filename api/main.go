@@ -1,46 +1,86 @@
 package main
 
 import (
-	"database/sql"
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
-
-	_ "github.com/lib/pq"
+	"os/signal"
+	"syscall"
 )
 
-func init() {
+func main() {
+
+	cfg := loadConfig()
+	setupLogging(cfg.LogLevel)
 
-	// Get the DB connection string from env var.
-	connStr := os.Getenv("DB_CONN_STR")
-	db, err := sql.Open("postgres", connStr)
+	// Install the tracer provider before anything starts spans.
+	shutdownTracing, err := setupTracing(context.Background(), cfg)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("startup failed", "error", err)
+		os.Exit(1)
 	}
-	defer db.Close()
+	defer shutdownTracing(context.Background())
 
-	// Check the connection.
-	rows, err := db.Query("select version()")
+	// Set up the shared dependencies for the handlers.
+	srv, err := NewServer(cfg)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("startup failed", "error", err)
+		os.Exit(1)
 	}
-	defer rows.Close()
+	defer srv.Close()
 
-	var version string
-	for rows.Next() {
-		err := rows.Scan(&version)
-		if err != nil {
-			log.Fatal(err)
-		}
+	httpServer := &http.Server{
+		Addr:    srv.cfg.Addr,
+		Handler: NewRouter(srv),
 	}
-	log.Printf("Checked DB connection: version=%s\n", version)
-}
 
-func main() {
+	// Hijacked WebSocket connections aren't tracked by Shutdown, so
+	// disconnect them explicitly.
+	httpServer.RegisterOnShutdown(srv.sessions.closeAll)
+
+	// Run queued jobs in the background until shutdown.
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	jobsDone := make(chan struct{})
+	go func() {
+		defer close(jobsDone)
+		srv.runJobs(jobsCtx)
+	}()
 
 	// ListenAndServe starts an HTTP server with a given address and
 	// handler defined in NewRouter.
-	log.Println("🎧 Starting to listen on port 8080!")
-	router := NewRouter()
-	log.Fatal(http.ListenAndServe(":8080", router))
+	serverErrors := make(chan error, 1)
+	go func() {
+		slog.Info("🎧 Starting to listen", "addr", srv.cfg.Addr)
+		serverErrors <- httpServer.ListenAndServe()
+	}()
+
+	// Wait for either a server error or a shutdown signal.
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErrors:
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("server failed", "error", err)
+		}
+
+	case sig := <-shutdown:
+		slog.Info("draining in-flight requests", "signal", sig.String(), "timeout", srv.cfg.ShutdownTimeout)
+
+		// Stop accepting new connections and let in-flight requests,
+		// including their LLM calls, run to completion.
+		ctx, cancel := context.WithTimeout(context.Background(), srv.cfg.ShutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			slog.Error("graceful shutdown did not complete", "error", err)
+			httpServer.Close()
+		}
+	}
+
+	// Running jobs are requeued rather than waited for, since they can
+	// take far longer than the shutdown timeout.
+	stopJobs()
+	<-jobsDone
 }
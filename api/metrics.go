@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// llmBuckets covers chat calls from sub-second parses to multi-second
+// descriptions and QA answers.
+var llmBuckets = []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32}
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chess_http_request_duration_seconds",
+		Help:    "Duration of HTTP requests by route, method and status code.",
+		Buckets: llmBuckets,
+	}, []string{"route", "method", "code"})
+
+	llmCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chess_llm_call_duration_seconds",
+		Help:    "Duration of LLM chat calls by task and outcome.",
+		Buckets: llmBuckets,
+	}, []string{"task", "status"})
+
+	llmTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chess_llm_tokens_total",
+		Help: "Estimated LLM tokens by task and direction (prompt or completion).",
+	}, []string{"task", "direction"})
+
+	moveAttempts = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chess_move_attempts",
+		Help:    "Number of LLM attempts needed per MakeMove request.",
+		Buckets: []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+	})
+
+	moveResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chess_move_results_total",
+		Help: "LLM-proposed moves by result (legal, illegal or blunder).",
+	}, []string{"result"})
+
+	moveGiveUps = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chess_move_give_ups_total",
+		Help: "MakeMove requests where the LLM never produced an acceptable move.",
+	})
+
+	engineSearchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chess_engine_search_duration_seconds",
+		Help:    "Duration of engine searches by engine.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"engine"})
+
+	embeddingDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chess_embedding_duration_seconds",
+		Help:    "Duration of embedding calls.",
+		Buckets: llmBuckets,
+	})
+
+	vectorSearchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chess_vector_search_duration_seconds",
+		Help:    "Duration of pgvector nearest neighbor queries.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chess_job_duration_seconds",
+		Help:    "Duration of job runs by task and outcome (succeeded, failed or interrupted).",
+		Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200},
+	}, []string{"task", "status"})
+)
+
+// estimateTokens approximates a token count, since the chat API does
+// not report usage. Roughly four characters per token for English.
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades through the recorder.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Metrics receives a handler, wraps the passed handler with
+// request duration metrics labeled by route name.
+func Metrics(inner http.Handler, name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		inner.ServeHTTP(rec, r)
+		httpRequestDuration.
+			WithLabelValues(name, r.Method, strconv.Itoa(rec.status)).
+			Observe(time.Since(start).Seconds())
+	})
+}
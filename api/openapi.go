@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// routeDoc documents a route for the OpenAPI spec. Request and Response
+// hold zero values of the wire types; nil means no JSON body. Streams
+// set MediaType to describe Response as the data of each event.
+type routeDoc struct {
+	Summary   string
+	Request   any
+	Response  any
+	MediaType string
+}
+
+// routeDocs documents the routes by name. The schemas are derived from
+// the Go types by reflection, so the spec follows the handlers.
+var routeDocs = map[string]routeDoc{
+	"Index":         {Summary: "Plain text health message."},
+	"Healthz":       {Summary: "Liveness probe.", Response: HealthResponse{}},
+	"Readyz":        {Summary: "Readiness probe checking the DB, pgvector, items, the LLM and the chess engine.", Response: HealthResponse{}},
+	"Metrics":       {Summary: "Prometheus metrics."},
+	"OpenAPI":       {Summary: "This OpenAPI document."},
+	"ParseMove":     {Summary: "Apply a natural language move for the side to move and, if asked, classify its quality.", Request: ParseMoveRequest{}, Response: ParseMoveResponse{}},
+	"MakeMove":      {Summary: "Play the next black move from the opening book, the endgame tablebase or the LLM, with the engine moving when the LLM cannot.", Request: MakeMoveRequest{}, Response: MakeMoveResponse{}},
+	"GenHelp":       {Summary: "Generate advice for the current game.", Request: GenHelpRequest{}, Response: GenHelpResponse{}},
+	"GenHelpStream": {Summary: "Stream advice as server-sent events: a progress event per stage, the retrieved sources, the answer token by token, then done or error.", Request: GenHelpRequest{}, Response: HelpStreamEvent{}, MediaType: "text/event-stream"},
+	"Usage":         {Summary: "Metered usage for the caller today.", Response: UsageResponse{}},
+	"GameState":     {Summary: "Describe a game (FEN, legal moves, outcome) without calling the LLM.", Request: GameStateRequest{}, Response: GameStateResponse{}},
+	"Evaluate":      {Summary: "Analyse the current position: engine score and line, material, mobility, king safety, pawn structure, hanging pieces and tactics.", Request: EvaluateRequest{}, Response: EvaluateResponse{}},
+	"Hint":          {Summary: "Hint at the best move: the piece to move, the square it goes to or the whole move, with a one-sentence rationale and an SVG of the board.", Request: HintRequest{}, Response: HintResponse{}},
+	"CreateGame":    {Summary: "Start a game session.", Request: CreateGameRequest{}, Response: GameSessionResponse{}},
+	"GetGame":       {Summary: "Get a game session.", Response: GameSessionResponse{}},
+	"GameSocket":    {Summary: "WebSocket streaming GameEvent messages for a session. Players send GameCommand messages; add ?role=spectator to only watch."},
+	"CreateJob":     {Summary: "Queue a help, annotate-game or batch-analyze job, charged as one request per LLM call it makes: one per move annotated or game analysed. Returns 202 with the job; 503 when the queue is full.", Request: CreateJobRequest{}, Response: JobResponse{}},
+	"GetJob":        {Summary: "Get the status and, once finished, the result of a job.", Response: JobResponse{}},
+	"WebUI":         {Summary: "The web UI."},
+	"WebAsset":      {Summary: "Static files for the web UI."},
+}
+
+var pathParamRE = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// openAPISpec builds an OpenAPI 3 document for the given routes.
+func openAPISpec(routes Routes) map[string]any {
+	reg := &schemaRegistry{schemas: make(map[string]any), names: make(map[reflect.Type]string)}
+	paths := make(map[string]map[string]any)
+
+	for _, route := range routes {
+		doc := routeDocs[route.Name]
+		summary := doc.Summary
+		if summary == "" {
+			summary = route.Name
+		}
+
+		op := map[string]any{
+			"operationId": route.Name,
+			"summary":     summary,
+		}
+
+		// Path parameters use the same {name} syntax as gorilla/mux,
+		// minus any regexp.
+		path := pathParamRE.ReplaceAllString(route.Pattern, "{$1}")
+		var params []any
+		for _, m := range pathParamRE.FindAllStringSubmatch(route.Pattern, -1) {
+			params = append(params, map[string]any{
+				"name":     m[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+
+		if doc.Request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": reg.schemaFor(reflect.TypeOf(doc.Request))},
+				},
+			}
+		}
+
+		ok := map[string]any{"description": "OK"}
+		if doc.Response != nil {
+			mediaType := "application/json"
+			if doc.MediaType != "" {
+				mediaType = doc.MediaType
+			}
+			ok["content"] = map[string]any{
+				mediaType: map[string]any{"schema": reg.schemaFor(reflect.TypeOf(doc.Response))},
+			}
+		} else {
+			ok["content"] = map[string]any{
+				"text/plain": map[string]any{"schema": map[string]any{"type": "string"}},
+			}
+		}
+		responses := map[string]any{"200": ok}
+		if doc.Request != nil {
+			responses["400"] = map[string]any{"description": "Invalid request or game."}
+		}
+		if !publicRoutes[route.Name] {
+			op["security"] = []any{map[string]any{"bearerAuth": []any{}}}
+			responses["401"] = map[string]any{"description": "Missing or invalid credentials."}
+		}
+		if !rateExemptRoutes[route.Name] {
+			responses["429"] = map[string]any{"description": "Rate limit or daily quota exceeded. See Retry-After."}
+		}
+		op["responses"] = responses
+
+		if paths[path] == nil {
+			paths[path] = make(map[string]any)
+		}
+		paths[path][strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "LLM Chess API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": reg.schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "A static API key or an HS256 JWT. API keys can also be sent in X-API-Key.",
+				},
+			},
+		},
+	}
+}
+
+// schemaRegistry collects the schemas of named structs for
+// components/schemas.
+type schemaRegistry struct {
+	schemas map[string]any
+	names   map[reflect.Type]string
+}
+
+// name returns the component name of t: its type name, qualified by its
+// package when a struct from another package already has that name.
+func (reg *schemaRegistry) name(t reflect.Type) string {
+	if name, ok := reg.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, taken := reg.schemas[name]; taken {
+		name = path.Base(t.PkgPath()) + "." + t.Name()
+	}
+	if _, taken := reg.schemas[name]; taken {
+		name = strings.ReplaceAll(t.PkgPath(), "/", ".") + "." + t.Name()
+	}
+	reg.names[t] = name
+	return name
+}
+
+// schemaFor returns the JSON schema of t, registering named structs
+// under components/schemas and referencing them.
+func (reg *schemaRegistry) schemaFor(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": reg.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": reg.schemaFor(t.Elem())}
+	case reflect.Struct:
+		_, seen := reg.names[t]
+		name := reg.name(t)
+		ref := map[string]any{"$ref": "#/components/schemas/" + name}
+		if seen {
+			return ref
+		}
+
+		// Register first so recursive types terminate.
+		reg.schemas[name] = nil
+		props := make(map[string]any)
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = reg.schemaFor(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]any{"type": "object", "properties": props}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		reg.schemas[name] = schema
+		return ref
+	default:
+		return map[string]any{}
+	}
+}
+
+// OpenAPI serves the OpenAPI document for the API.
+func (s *Server) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(openAPISpec(s.routes()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/engine"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/uci"
+)
+
+func TestRouteDocs(t *testing.T) {
+	s := &Server{}
+	routes := make(map[string]bool)
+	for _, route := range s.routes() {
+		routes[route.Name] = true
+		if doc, ok := routeDocs[route.Name]; !ok || doc.Summary == "" {
+			t.Errorf("route %s has no summary in routeDocs", route.Name)
+		}
+	}
+	for name := range routeDocs {
+		if !routes[name] {
+			t.Errorf("routeDocs documents %s, which is not a route", name)
+		}
+	}
+}
+
+// TestRouteDocTypes checks that every request and response type
+// resolves to an object schema with properties for its JSON fields.
+func TestRouteDocTypes(t *testing.T) {
+	for name, doc := range routeDocs {
+		for _, v := range []any{doc.Request, doc.Response} {
+			if v == nil {
+				continue
+			}
+			reg := &schemaRegistry{schemas: make(map[string]any), names: make(map[reflect.Type]string)}
+			typ := reflect.TypeOf(v)
+			ref := reg.schemaFor(typ)["$ref"]
+			schema, _ := reg.schemas[typ.Name()].(map[string]any)
+			if ref != "#/components/schemas/"+typ.Name() || schema == nil {
+				t.Errorf("%s: %s does not resolve to a schema", name, typ.Name())
+				continue
+			}
+			props, _ := schema["properties"].(map[string]any)
+			for i := 0; i < typ.NumField(); i++ {
+				field, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+				if field != "" && field != "-" && props[field] == nil {
+					t.Errorf("%s: %s has no property %s", name, typ.Name(), field)
+				}
+			}
+		}
+	}
+}
+
+// TestSchemaNames checks that structs of the same name from different
+// packages get a schema each.
+func TestSchemaNames(t *testing.T) {
+	reg := &schemaRegistry{schemas: make(map[string]any), names: make(map[reflect.Type]string)}
+	engineRef := reg.schemaFor(reflect.TypeOf(engine.Line{}))["$ref"]
+	uciRef := reg.schemaFor(reflect.TypeOf(uci.Line{}))["$ref"]
+	if engineRef != "#/components/schemas/Line" || uciRef != "#/components/schemas/uci.Line" {
+		t.Errorf("refs = %v and %v, want Line and uci.Line", engineRef, uciRef)
+	}
+	if again := reg.schemaFor(reflect.TypeOf(uci.Line{}))["$ref"]; again != uciRef {
+		t.Errorf("second ref to uci.Line = %v, want %v", again, uciRef)
+	}
+	for _, name := range []string{"Line", "uci.Line"} {
+		if schema, _ := reg.schemas[name].(map[string]any); schema == nil {
+			t.Errorf("no schema %s", name)
+		}
+	}
+}
+
+// TestOpenAPI checks the document the API serves against the structure
+// OpenAPI 3 requires: the version and info, paths with operations that
+// have unique IDs and described responses, declared path parameters,
+// and references that resolve to component schemas.
+func TestOpenAPI(t *testing.T) {
+	s := &Server{}
+	rec := httptest.NewRecorder()
+	s.OpenAPI(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("got status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Title   string `json:"title"`
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas         map[string]map[string]any `json:"schemas"`
+			SecuritySchemes map[string]any            `json:"securitySchemes"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	if !regexp.MustCompile(`^3\.0\.\d+$`).MatchString(spec.OpenAPI) {
+		t.Errorf("openapi = %q, want 3.0.x", spec.OpenAPI)
+	}
+	if spec.Info.Title == "" || spec.Info.Version == "" {
+		t.Errorf("info = %+v, want a title and version", spec.Info)
+	}
+	if len(spec.Paths) == 0 {
+		t.Fatal("no paths")
+	}
+
+	methods := map[string]bool{"get": true, "put": true, "post": true, "delete": true, "options": true, "head": true, "patch": true, "trace": true}
+	ids := make(map[string]bool)
+	for path, ops := range spec.Paths {
+		if !strings.HasPrefix(path, "/") || strings.Contains(path, ":") {
+			t.Errorf("path %q: want a template starting with /", path)
+		}
+		for method, op := range ops {
+			where := method + " " + path
+			if !methods[method] {
+				t.Errorf("%s: unknown method", where)
+			}
+			id, _ := op["operationId"].(string)
+			if id == "" || ids[id] {
+				t.Errorf("%s: operationId %q missing or repeated", where, id)
+			}
+			ids[id] = true
+
+			params := make(map[string]bool)
+			list, _ := op["parameters"].([]any)
+			for _, p := range list {
+				p, _ := p.(map[string]any)
+				if p["in"] == "path" && p["required"] == true {
+					params[p["name"].(string)] = true
+				}
+			}
+			for _, m := range regexp.MustCompile(`\{([^}]+)\}`).FindAllStringSubmatch(path, -1) {
+				if !params[m[1]] {
+					t.Errorf("%s: path parameter %s not declared", where, m[1])
+				}
+			}
+
+			responses, _ := op["responses"].(map[string]any)
+			if len(responses) == 0 {
+				t.Errorf("%s: no responses", where)
+			}
+			for code, r := range responses {
+				r, _ := r.(map[string]any)
+				if d, _ := r["description"].(string); d == "" || !regexp.MustCompile(`^[1-5]\d\d$`).MatchString(code) {
+					t.Errorf("%s: response %s needs a status code and description", where, code)
+				}
+			}
+			if sec, ok := op["security"].([]any); ok {
+				for _, req := range sec {
+					for scheme := range req.(map[string]any) {
+						if spec.Components.SecuritySchemes[scheme] == nil {
+							t.Errorf("%s: unknown security scheme %s", where, scheme)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Every reference, in the paths or the schemas themselves, resolves.
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				name, found := strings.CutPrefix(ref, "#/components/schemas/")
+				if !found || spec.Components.Schemas[name] == nil {
+					t.Errorf("reference %s does not resolve", ref)
+				}
+			}
+			for _, e := range v {
+				walk(e)
+			}
+		case []any:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	for _, ops := range spec.Paths {
+		for _, op := range ops {
+			walk(op)
+		}
+	}
+	for name, schema := range spec.Components.Schemas {
+		if schema["type"] != "object" {
+			t.Errorf("schema %s: type = %v, want object", name, schema["type"])
+		}
+		props, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, r := range required {
+			if props[r.(string)] == nil {
+				t.Errorf("schema %s: required property %v is not defined", name, r)
+			}
+		}
+		walk(schema)
+	}
+}
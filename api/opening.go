@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/polyglot"
+	"github.com/notnil/chess"
+	"github.com/notnil/chess/opening"
+)
+
+// ecoBook is the Encyclopaedia of Chess Openings indexed by position, so
+// that a game reaching an opening by another move order is still
+// classified. Building it takes about a second, so the server warms it up
+// in the background.
+var ecoBook = sync.OnceValue(newECOIndex)
+
+// ecoOpening is an ECO opening and the number of plies of its main line.
+type ecoOpening struct {
+	*opening.Opening
+	plies int
+}
+
+// newECOIndex maps the Polyglot key of the position each ECO opening
+// ends in to the opening. Where several openings end in the same
+// position, the one with the shortest line wins.
+//
+// The library's Opening.PGN returns the line as space-separated UCI
+// moves, e.g. "e2e4 e7e5 g1f3", rather than as PGN, so its fields are
+// the plies of the opening. That is undocumented; if it ever returns
+// real PGN, with move numbers, decoding fails and the opening is
+// skipped.
+func newECOIndex() map[uint64]ecoOpening {
+	index := make(map[uint64]ecoOpening)
+	for _, o := range opening.NewBookECO().Possible(nil) {
+		moves := strings.Fields(o.PGN())
+		pos := chess.StartingPosition()
+		for _, s := range moves {
+			m, err := chess.UCINotation{}.Decode(pos, s)
+			if err != nil {
+				pos = nil
+				break
+			}
+			pos = pos.Update(m)
+		}
+		if pos == nil {
+			continue
+		}
+		key := polyglot.Random64.Hash(pos)
+		if prev, ok := index[key]; !ok || len(moves) < prev.plies {
+			index[key] = ecoOpening{Opening: o, plies: len(moves)}
+		}
+	}
+	return index
+}
+
+// classifyOpening returns the ECO opening of the latest position of game
+// that is one, or nil if no position is. Plies is the length of the
+// opening's own line, which can differ from the moves played to reach it.
+func classifyOpening(game *chess.Game) *chessapi.Opening {
+	positions := game.Positions()
+	for i := len(positions) - 1; i > 0; i-- {
+		if o, ok := ecoBook()[polyglot.Random64.Hash(positions[i])]; ok {
+			return &chessapi.Opening{
+				ECO:   o.Code(),
+				Name:  o.Title(),
+				Plies: o.plies,
+			}
+		}
+	}
+	return nil
+}
+
+// openingFact describes an opening in a sentence, for prompts and
+// retrieval queries.
+func openingFact(o *chessapi.Opening) string {
+	return fmt.Sprintf("Opening: %s (ECO %s).", o.Name, o.ECO)
+}
+
+// applyBookMove plays a move from the opening book, if there is one and
+// the game is still within the plies it is used for. It returns an empty
+// move otherwise.
+func (s *Server) applyBookMove(ctx context.Context, game *chess.Game) (string, error) {
+	if s.book == nil || len(game.Moves()) >= s.cfg.BookPlies {
+		return "", nil
+	}
+
+	pos := game.Position()
+	m := s.book.Pick(pos, s.cfg.BookVariety)
+	if m == nil {
+		return "", nil
+	}
+	move := chess.AlgebraicNotation{}.Encode(pos, m)
+	if err := game.Move(m); err != nil {
+		return "", err
+	}
+	slog.InfoContext(ctx, "book move", "move", move, "ply", len(game.Moves()))
+
+	return move, nil
+}
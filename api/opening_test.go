@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/notnil/chess"
+)
+
+func TestClassifyOpening(t *testing.T) {
+	tests := []struct {
+		name  string
+		pgn   string
+		eco   string // "" for no opening
+		title string
+		plies int
+	}{
+		{"Ruy Lopez", "1. e4 e5 2. Nf3 Nc6 3. Bb5 *", "C60", "Ruy Lopez", 5},
+		{"Ruy Lopez by transposition", "1. Nf3 Nc6 2. e4 e5 3. Bb5 *", "C60", "Ruy Lopez", 5},
+		{"Nimzo-Indian from the English", "1. c4 e6 2. Nc3 Nf6 3. d4 Bb4 *", "E20", "Nimzo-Indian Defense", 6},
+		{"out of the book keeps the last opening", "1. e4 e5 2. Nf3 Nc6 3. Bb5 Qh4 4. Nxh4 *", "C60", "Ruy Lopez", 5},
+		{"no moves", "*", "", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pgn, err := chess.PGN(strings.NewReader(tt.pgn))
+			if err != nil {
+				t.Fatal(err)
+			}
+			o := classifyOpening(chess.NewGame(pgn))
+			if tt.eco == "" {
+				if o != nil {
+					t.Errorf("got %+v, want no opening", o)
+				}
+				return
+			}
+			if o == nil || o.ECO != tt.eco || o.Name != tt.title || o.Plies != tt.plies {
+				t.Errorf("got %+v, want %s %s in %d plies", o, tt.eco, tt.title, tt.plies)
+			}
+		})
+	}
+}
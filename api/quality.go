@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/notnil/chess"
+)
+
+// qualityLimits are the most centipawns a move may lose compared with
+// the engine's best move for each classification. Anything worse is a
+// blunder.
+var qualityLimits = []struct {
+	loss  int
+	class string
+}{
+	{0, chessapi.MoveQualityBest},
+	{50, chessapi.MoveQualityGood},
+	{120, chessapi.MoveQualityInaccuracy},
+	{300, chessapi.MoveQualityMistake},
+}
+
+// classifyLoss returns the classification of a move that loses loss
+// centipawns.
+func classifyLoss(loss int) string {
+	for _, l := range qualityLimits {
+		if loss <= l.loss {
+			return l.class
+		}
+	}
+	return chessapi.MoveQualityBlunder
+}
+
+// classifyMove compares m, played in pos, with the engine's best move
+// there, and for inaccuracies and worse has the LLM explain what m
+// missed. The explanation costs the caller a call of quota and is left
+// out if that is used up or the LLM fails. pgn is the game before m.
+func (s *Server) classifyMove(ctx context.Context, pos *chess.Position, m *chess.Move, pgn string) (MoveQuality, error) {
+	quality := MoveQuality{Move: chess.AlgebraicNotation{}.Encode(pos, m)}
+
+	// Both searches get an explicit depth: a UCI engine searches a
+	// depth of zero by time alone, which wouldn't match the reply's.
+	depth := max(s.cfg.EngineDepth, 2)
+	cands, err := s.searchPosition(ctx, pos, 1, depth)
+	if err != nil {
+		return MoveQuality{}, err
+	}
+	best := cands[0]
+	if sameMove(best.Move, m) || len(pos.ValidMoves()) == 1 {
+		quality.BestMove = quality.Move
+		quality.Classification = chessapi.MoveQualityBest
+		return quality, nil
+	}
+
+	// Score the move by the opponent's best reply, a ply shallower so
+	// both searches see as far ahead.
+	next := pos.Update(m)
+	var score int
+	var reply string
+	switch next.Status() {
+	case chess.Checkmate:
+		quality.BestMove = quality.Move
+		quality.Classification = chessapi.MoveQualityBest
+		return quality, nil
+	case chess.Stalemate:
+	default:
+		replies, err := s.searchPosition(ctx, next, 1, max(best.Depth-1, 1))
+		if err != nil {
+			return MoveQuality{}, err
+		}
+
+		// A time limit can stop the reply's search short of its depth,
+		// in which case the best move is searched again to match it.
+		// Mates are exact however deep they were found.
+		r := replies[0]
+		if !isMate(best.Score) && !isMate(r.Score) && r.Depth+1 != best.Depth {
+			if r.Depth == 0 || r.Depth+1 > best.Depth {
+				return MoveQuality{}, fmt.Errorf("searched the move %d plies deep and the reply %d", best.Depth, r.Depth)
+			}
+			if cands, err = s.searchPosition(ctx, pos, 1, r.Depth+1); err != nil {
+				return MoveQuality{}, err
+			}
+			if best = cands[0]; best.Depth != r.Depth+1 {
+				return MoveQuality{}, fmt.Errorf("searched the move %d plies deep and the reply %d", best.Depth, r.Depth)
+			}
+			if sameMove(best.Move, m) {
+				quality.BestMove = quality.Move
+				quality.Classification = chessapi.MoveQualityBest
+				return quality, nil
+			}
+		}
+		score, reply = -r.Score, r.SAN
+	}
+
+	quality.BestMove = best.SAN
+	quality.Loss = max(best.Score-score, 0)
+	quality.Classification = classifyLoss(quality.Loss)
+	switch quality.Classification {
+	case chessapi.MoveQualityBest, chessapi.MoveQualityGood:
+		return quality, nil
+	}
+
+	p, _ := principalFromContext(ctx)
+	if err := s.chargeQuota(ctx, p, "ParseMove", 1); err != nil {
+		slog.WarnContext(ctx, "not explaining move quality", "move", quality.Move, "error", err)
+		return quality, nil
+	}
+	explanation, err := s.generateMoveQualityWithLLM(ctx, pgn, quality.Move, quality.Classification, best.SAN, best.PV, reply)
+	if err != nil {
+		slog.WarnContext(ctx, "explaining move quality failed", "move", quality.Move, "error", err)
+		return quality, nil
+	}
+	quality.Explanation = firstSentence(explanation)
+	return quality, nil
+}
+
+// sameMove reports whether a and b move the same piece the same way.
+func sameMove(a, b *chess.Move) bool {
+	return a.S1() == b.S1() && a.S2() == b.S2() && a.Promo() == b.Promo()
+}
+
+// saveMoveQuality stores the quality of a game's move for subject. Any
+// stored moves after it are from a line the game no longer follows, so
+// they are dropped.
+func (s *Server) saveMoveQuality(ctx context.Context, subject, gameID string, quality MoveQuality) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM api_move_quality
+		WHERE subject = $1 AND game_id = $2 AND ply >= $3`,
+		subject, gameID, quality.Ply)
+	if err != nil {
+		return fmt.Errorf("saving move quality: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO api_move_quality (subject, game_id, ply, move, classification, best_move, loss, explanation)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		subject, gameID, quality.Ply, quality.Move, quality.Classification, quality.BestMove, quality.Loss, quality.Explanation)
+	if err != nil {
+		return fmt.Errorf("saving move quality: %w", err)
+	}
+	return tx.Commit()
+}
+
+// moveQualities loads the stored move qualities of a game for subject,
+// in the order the moves were played.
+func (s *Server) moveQualities(ctx context.Context, subject, gameID string) ([]MoveQuality, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT ply, move, classification, best_move, loss, explanation
+		FROM api_move_quality
+		WHERE subject = $1 AND game_id = $2
+		ORDER BY ply`,
+		subject, gameID)
+	if err != nil {
+		return nil, fmt.Errorf("loading move qualities: %w", err)
+	}
+	defer rows.Close()
+
+	var qualities []MoveQuality
+	for rows.Next() {
+		var q MoveQuality
+		if err := rows.Scan(&q.Ply, &q.Move, &q.Classification, &q.BestMove, &q.Loss, &q.Explanation); err != nil {
+			return nil, fmt.Errorf("loading move qualities: %w", err)
+		}
+		qualities = append(qualities, q)
+	}
+	return qualities, rows.Err()
+}
+
+// coach classifies the last move of game, played after pgn. For an
+// authenticated caller it stores the classification with the game's
+// earlier ones and returns them all; anonymous callers only get the
+// move's own, since a game ID alone doesn't keep others out of a game.
+// Failures are logged and leave the response without classifications,
+// as the move itself stands.
+func (s *Server) coach(ctx context.Context, game *chess.Game, pgn string, resp *ParseMoveResponse) {
+	moves, positions := game.Moves(), game.Positions()
+	ply := len(moves)
+	quality, err := s.classifyMove(ctx, positions[ply-1], moves[ply-1], pgn)
+	if err != nil {
+		slog.WarnContext(ctx, "classifying move failed", "move", resp.Move, "error", err)
+		return
+	}
+	quality.Ply = ply
+
+	p, _ := principalFromContext(ctx)
+	if p.Subject == "" {
+		resp.Quality = &quality
+		return
+	}
+	if err := s.saveMoveQuality(ctx, p.Subject, resp.GameID, quality); err != nil {
+		slog.WarnContext(ctx, "storing move quality failed", "game", resp.GameID, "error", err)
+		return
+	}
+	qualities, err := s.moveQualities(ctx, p.Subject, resp.GameID)
+	if err != nil {
+		slog.WarnContext(ctx, "loading move qualities failed", "game", resp.GameID, "error", err)
+		return
+	}
+	resp.Quality, resp.Classifications = &quality, qualities
+}
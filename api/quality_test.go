@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/notnil/chess"
+)
+
+// position parses a FEN for a test.
+func position(t *testing.T, fen string) *chess.Position {
+	t.Helper()
+	opt, err := chess.FEN(fen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chess.NewGame(opt).Position()
+}
+
+func TestClassifyLoss(t *testing.T) {
+	tests := []struct {
+		loss int
+		want string
+	}{
+		{0, chessapi.MoveQualityBest},
+		{1, chessapi.MoveQualityGood},
+		{50, chessapi.MoveQualityGood},
+		{51, chessapi.MoveQualityInaccuracy},
+		{120, chessapi.MoveQualityInaccuracy},
+		{121, chessapi.MoveQualityMistake},
+		{300, chessapi.MoveQualityMistake},
+		{301, chessapi.MoveQualityBlunder},
+		{100000, chessapi.MoveQualityBlunder},
+	}
+	for _, tt := range tests {
+		if got := classifyLoss(tt.loss); got != tt.want {
+			t.Errorf("classifyLoss(%d) = %q, want %q", tt.loss, got, tt.want)
+		}
+	}
+}
+
+// coachServer returns a server searching with the built-in engine whose
+// LLM explains every move the same way.
+func coachServer(t *testing.T) *Server {
+	return &Server{
+		cfg: Config{EngineDepth: 2, EngineMoveTime: 10 * time.Second},
+		llm: fakeLLM(t, func(system, user string) (string, error) {
+			return "It leaves the queen to the knight. Watch out.", nil
+		}),
+	}
+}
+
+func TestClassifyMove(t *testing.T) {
+	s := coachServer(t)
+	tests := []struct {
+		name  string
+		fen   string
+		move  string
+		class string
+		best  string
+		loss  bool
+	}{
+		{"mate in one", "6k1/5ppp/8/8/8/8/8/3RK3 w - - 0 1", "d1d8", chessapi.MoveQualityBest, "Rd8#", false},
+		{"only legal move", "k7/8/8/8/8/8/6q1/7K w - - 0 1", "h1g2", chessapi.MoveQualityBest, "Kxg2", false},
+		{"queen left to a knight", "6k1/8/2n5/8/8/8/8/3QK3 w - - 0 1", "d1d4", chessapi.MoveQualityBlunder, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos := position(t, tt.fen)
+			q, err := s.classifyMove(context.Background(), pos, findMove(pos, tt.move), "*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if q.Classification != tt.class {
+				t.Errorf("classification = %q, want %q", q.Classification, tt.class)
+			}
+			if tt.best != "" && q.BestMove != tt.best {
+				t.Errorf("best move = %q, want %q", q.BestMove, tt.best)
+			}
+			if tt.loss {
+				if q.Loss < 500 || q.BestMove == q.Move {
+					t.Errorf("loss = %d with best move %s, want a queen's worth and another move", q.Loss, q.BestMove)
+				}
+				if q.Explanation != "It leaves the queen to the knight." {
+					t.Errorf("explanation = %q, want the LLM's first sentence", q.Explanation)
+				}
+			} else if q.Loss != 0 || q.Explanation != "" {
+				t.Errorf("loss = %d and explanation %q for the best move", q.Loss, q.Explanation)
+			}
+		})
+	}
+}
+
+// parseMove calls the ParseMove handler for p, coaching the move.
+func parseMove(t *testing.T, s *Server, p Principal, req ParseMoveRequest) ParseMoveResponse {
+	t.Helper()
+	req.Coach = true
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/parse", bytes.NewReader(body))
+	if p.Subject != "" {
+		r = r.WithContext(context.WithValue(r.Context(), principalKey, p))
+	}
+	w := httptest.NewRecorder()
+	s.ParseMove(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var resp ParseMoveResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestParseMoveCoachAnonymous(t *testing.T) {
+	s := coachServer(t)
+
+	// Without a subject nothing is stored, so no database is needed.
+	resp := parseMove(t, s, Principal{}, ParseMoveRequest{Game: "*", Move: "e2e4"})
+	if resp.Quality == nil || resp.Quality.Ply != 1 || resp.Quality.Move != "e4" {
+		t.Fatalf("quality = %+v, want e4 at ply 1", resp.Quality)
+	}
+	if resp.Classifications != nil {
+		t.Errorf("classifications = %+v for an anonymous caller, want none", resp.Classifications)
+	}
+	if resp.GameID == "" {
+		t.Error("no game ID issued")
+	}
+}
+
+// TestParseMoveCoach plays a game through ParseMove and checks that the
+// classifications build up move by move. It needs a Postgres database,
+// from TEST_DB_CONN_STR.
+func TestParseMoveCoach(t *testing.T) {
+	conn := os.Getenv("TEST_DB_CONN_STR")
+	if conn == "" {
+		t.Skip("TEST_DB_CONN_STR not set")
+	}
+	db, err := sql.Open("postgres", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	s := coachServer(t)
+	s.db = db
+	if err := s.migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	p := Principal{Subject: "test-" + newID()}
+	t.Cleanup(func() { db.Exec("DELETE FROM api_move_quality WHERE subject = $1", p.Subject) })
+
+	plies := func(resp ParseMoveResponse) string {
+		var moves []string
+		for _, q := range resp.Classifications {
+			moves = append(moves, q.Move)
+		}
+		return strings.Join(moves, " ")
+	}
+
+	resp := parseMove(t, s, p, ParseMoveRequest{Game: "*", Move: "e2e4"})
+	if got := plies(resp); got != "e4" {
+		t.Fatalf("after 1. e4: classifications %q, want %q", got, "e4")
+	}
+	gameID := resp.GameID
+
+	resp = parseMove(t, s, p, ParseMoveRequest{Game: "1. e4 e5 *", Move: "g1f3", GameID: gameID})
+	if got := plies(resp); got != "e4 Nf3" {
+		t.Fatalf("after 2. Nf3: classifications %q, want %q", got, "e4 Nf3")
+	}
+	if resp.Classifications[1].Ply != 3 {
+		t.Errorf("Nf3 stored at ply %d, want 3", resp.Classifications[1].Ply)
+	}
+
+	// Another subject doesn't see the game.
+	other := parseMove(t, s, Principal{Subject: p.Subject + "-other"}, ParseMoveRequest{Game: "*", Move: "d2d4", GameID: gameID})
+	t.Cleanup(func() { db.Exec("DELETE FROM api_move_quality WHERE subject = $1", p.Subject+"-other") })
+	if got := plies(other); got != "d4" {
+		t.Errorf("another subject's classifications %q, want %q", got, "d4")
+	}
+
+	// Taking the first move back drops the line played after it.
+	resp = parseMove(t, s, p, ParseMoveRequest{Game: "*", Move: "d2d4", GameID: gameID})
+	if got := plies(resp); got != "d4" {
+		t.Errorf("after taking back 1. e4: classifications %q, want %q", got, "d4")
+	}
+}
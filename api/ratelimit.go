@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Route classes share a rate limit. LLM routes are limited far more
+// tightly since a single /move can make up to 10 LLM calls, and engine
+// routes since each call can keep a CPU busy for the engine's move time.
+const (
+	rateClassLLM     = "llm"
+	rateClassEngine  = "engine"
+	rateClassDefault = "default"
+)
+
+// engineRoutes search with the engine without calling the LLM.
+var engineRoutes = map[string]bool{
+	"Evaluate": true,
+}
+
+// rateExemptRoutes are never rate limited so probes and scrapes keep
+// working under load.
+var rateExemptRoutes = map[string]bool{
+	"Healthz": true,
+	"Readyz":  true,
+	"Metrics": true,
+}
+
+// rateClass returns the rate limit class for a route.
+func rateClass(name string) string {
+	switch {
+	case meteredRoutes[name]:
+		return rateClassLLM
+	case engineRoutes[name]:
+		return rateClassEngine
+	}
+	return rateClassDefault
+}
+
+// visitor is the token bucket of a single client.
+type visitor struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter holds one token bucket per client for a route class.
+type rateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	visitors  map[string]*visitor
+	lastSweep time.Time
+}
+
+// newRateLimiter creates a limiter allowing perMinute requests per
+// client with the given burst.
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return &rateLimiter{
+		limit:     rate.Limit(float64(perMinute) / 60),
+		burst:     max(burst, 1),
+		visitors:  make(map[string]*visitor),
+		lastSweep: time.Now(),
+	}
+}
+
+// reserve takes a token for key, returning how long the client must
+// wait before retrying if none is available.
+func (rl *rateLimiter) reserve(key string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+
+	// Drop clients that have been idle long enough to have a full bucket.
+	if now.Sub(rl.lastSweep) > time.Minute {
+		for k, v := range rl.visitors {
+			if now.Sub(v.lastSeen) > 10*time.Minute {
+				delete(rl.visitors, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	v, ok := rl.visitors[key]
+	if !ok {
+		v = &visitor{limiter: rate.NewLimiter(rl.limit, rl.burst)}
+		rl.visitors[key] = v
+	}
+	v.lastSeen = now
+
+	res := v.limiter.ReserveN(now, 1)
+	if !res.OK() {
+		return false, time.Minute
+	}
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// clientKey identifies the caller by its authenticated subject or,
+// for anonymous calls and credentials that don't check out, by its IP
+// address. RateLimit runs before Auth, so it authenticates the request
+// itself; keying by the raw token would give each made-up token its own
+// bucket.
+func (s *Server) clientKey(r *http.Request) string {
+	if s.authEnabled() {
+		if p, err := s.authenticate(r); err == nil {
+			return "sub:" + p.Subject
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// RateLimit receives a handler, wraps the passed handler with a
+// per-client token bucket for the route's class.
+func (s *Server) RateLimit(inner http.Handler, name string) http.Handler {
+	rl, ok := s.limiters[rateClass(name)]
+	if rateExemptRoutes[name] || !ok {
+		return inner
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allowed, wait := rl.reserve(s.clientKey(r)); !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
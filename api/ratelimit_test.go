@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// limitedServer returns a server allowing one /move a minute per client,
+// with the given API keys and JWT secret.
+func limitedServer(t *testing.T, keys, jwtSecret string) *Server {
+	t.Helper()
+	apiKeys, err := parseAPIKeys(keys, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Server{
+		cfg:      Config{JWTSecret: jwtSecret},
+		apiKeys:  apiKeys,
+		limiters: map[string]*rateLimiter{rateClassLLM: newRateLimiter(1, 1)},
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   "alice",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		keys    string
+		secret  string
+		headers []map[string]string // one request each, in turn
+		want    []int
+		clients int
+	}{
+		{
+			"made-up keys without auth share the IP's bucket", "", "",
+			[]map[string]string{{"X-API-Key": "a"}, {"X-API-Key": "b"}, {"Authorization": "Bearer c"}},
+			[]int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests},
+			1,
+		},
+		{
+			"made-up keys with auth share the IP's bucket", "k1:alice", "",
+			[]map[string]string{{"X-API-Key": "a"}, {"X-API-Key": "b"}, {}},
+			[]int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests},
+			1,
+		},
+		{
+			"each subject has a bucket", "k1:alice,k2:bob", "",
+			[]map[string]string{{"X-API-Key": "k1"}, {"X-API-Key": "k2"}, {"X-API-Key": "k1"}, {}},
+			[]int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusOK},
+			3,
+		},
+		{
+			"a key and a token of the same subject share a bucket", "k1:alice", "secret",
+			[]map[string]string{{"X-API-Key": "k1"}, {"Authorization": "Bearer " + token}},
+			[]int{http.StatusOK, http.StatusTooManyRequests},
+			1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := limitedServer(t, tt.keys, tt.secret)
+			h := s.RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "MakeMove")
+			for i, headers := range tt.headers {
+				req := httptest.NewRequest(http.MethodPost, "/move", nil)
+				req.RemoteAddr = "192.0.2.1:1234"
+				for k, v := range headers {
+					req.Header.Set(k, v)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				if rec.Code != tt.want[i] {
+					t.Errorf("request %d with %v: got status %d, want %d", i, headers, rec.Code, tt.want[i])
+				}
+			}
+			if n := len(s.limiters[rateClassLLM].visitors); n != tt.clients {
+				t.Errorf("%d clients tracked, want %d", n, tt.clients)
+			}
+		})
+	}
+}
+
+func TestRateClass(t *testing.T) {
+	for route, want := range map[string]string{
+		"MakeMove":  rateClassLLM,
+		"Hint":      rateClassLLM,
+		"Evaluate":  rateClassEngine,
+		"GameState": rateClassDefault,
+		"OpenAPI":   rateClassDefault,
+	} {
+		if got := rateClass(route); got != want {
+			t.Errorf("rateClass(%q) = %q, want %q", route, got, want)
+		}
+	}
+}
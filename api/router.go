@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Route is used to pass information about a particular route.
@@ -17,51 +18,164 @@ type Route struct {
 // Routes is a slice of Route.
 type Routes []Route
 
-// Define our routes.
-var routes = Routes{
-	Route{
-		"Index",
-		"GET",
-		"/",
-		Index,
-	},
-	Route{
-		"ParseMove",
-		"POST",
-		"/parse",
-		ParseMove,
-	},
-	Route{
-		"MakeMove",
-		"POST",
-		"/move",
-		MakeMove,
-	},
-	Route{
-		"GenHelp",
-		"POST",
-		"/help",
-		GenHelp,
-	},
+// routes defines the API routes served by s.
+func (s *Server) routes() Routes {
+	return Routes{
+		Route{
+			"Index",
+			"GET",
+			"/",
+			s.Index,
+		},
+		Route{
+			"Healthz",
+			"GET",
+			"/healthz",
+			s.Healthz,
+		},
+		Route{
+			"Readyz",
+			"GET",
+			"/readyz",
+			s.Readyz,
+		},
+		Route{
+			"Metrics",
+			"GET",
+			"/metrics",
+			promhttp.Handler().ServeHTTP,
+		},
+		Route{
+			"OpenAPI",
+			"GET",
+			"/openapi.json",
+			s.OpenAPI,
+		},
+		Route{
+			"ParseMove",
+			"POST",
+			"/parse",
+			s.ParseMove,
+		},
+		Route{
+			"MakeMove",
+			"POST",
+			"/move",
+			s.MakeMove,
+		},
+		Route{
+			"GenHelp",
+			"POST",
+			"/help",
+			s.GenHelp,
+		},
+		Route{
+			"GenHelpStream",
+			"POST",
+			"/help/stream",
+			s.GenHelpStream,
+		},
+		Route{
+			"GameState",
+			"POST",
+			"/state",
+			s.GameState,
+		},
+		Route{
+			"Evaluate",
+			"POST",
+			"/evaluate",
+			s.Evaluate,
+		},
+		Route{
+			"Hint",
+			"POST",
+			"/hint",
+			s.Hint,
+		},
+		Route{
+			"CreateGame",
+			"POST",
+			"/games",
+			s.CreateGame,
+		},
+		Route{
+			"GetGame",
+			"GET",
+			"/games/{id}",
+			s.GetGame,
+		},
+		Route{
+			"GameSocket",
+			"GET",
+			"/games/{id}/ws",
+			s.GameSocket,
+		},
+		Route{
+			"CreateJob",
+			"POST",
+			"/jobs",
+			s.CreateJob,
+		},
+		Route{
+			"GetJob",
+			"GET",
+			"/jobs/{id}",
+			s.GetJob,
+		},
+		Route{
+			"WebUI",
+			"GET",
+			"/app",
+			s.WebUI,
+		},
+		Route{
+			"WebAsset",
+			"GET",
+			"/app/{file}",
+			s.WebAsset,
+		},
+		Route{
+			"Usage",
+			"GET",
+			"/usage",
+			s.Usage,
+		},
+	}
 }
 
 // NewRouter forms a new mux router, see https://github.com/gorilla/mux.
-func NewRouter() *mux.Router {
+func NewRouter(s *Server) *mux.Router {
 
 	// Create a basic router.
 	router := mux.NewRouter().StrictSlash(false)
 	router.SkipClean(true)
 
 	// Assign the handlers to run when endpoints are called.
-	for _, route := range routes {
+	for _, route := range s.routes() {
 
 		// Create a handler function.
 		var handler http.Handler
 		handler = route.HandlerFunc
 
+		// Require credentials and enforce quotas where configured.
+		handler = s.Auth(handler, route.Name)
+
+		// Throttle each client per route class.
+		handler = s.RateLimit(handler, route.Name)
+
 		// Wrap all current routes in the logger decorator to log out requests.
 		handler = Logger(handler, route.Name)
 
+		// Record request duration metrics for the route.
+		handler = Metrics(handler, route.Name)
+
+		// Start a server span for the route.
+		handler = Tracing(handler, route.Name)
+
+		// Tag the request with an ID for logs and the response header.
+		handler = RequestID(handler)
+
 		// Add the route to the router.
 		router.Methods(route.Method).Path(route.Pattern).Name(route.Name).Handler(handler)
 	}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// schema holds the statements that create the API's own tables. They
+// are idempotent and run on every startup; the items table is managed
+// by the db/ scripts.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS api_usage (
+		subject varchar NOT NULL,
+		day     date    NOT NULL,
+		route   varchar NOT NULL,
+		count   integer NOT NULL DEFAULT 0,
+		PRIMARY KEY (subject, day, route)
+	)`,
+	`CREATE TABLE IF NOT EXISTS api_jobs (
+		id          varchar     PRIMARY KEY,
+		subject     varchar     NOT NULL,
+		task        varchar     NOT NULL,
+		status      varchar     NOT NULL,
+		request     jsonb       NOT NULL,
+		result      jsonb,
+		error       text        NOT NULL DEFAULT '',
+		attempts    integer     NOT NULL DEFAULT 0,
+		created_at  timestamptz NOT NULL DEFAULT now(),
+		started_at  timestamptz,
+		finished_at timestamptz
+	)`,
+	`CREATE INDEX IF NOT EXISTS api_jobs_queued ON api_jobs (created_at) WHERE status = 'queued'`,
+	`CREATE TABLE IF NOT EXISTS api_move_quality (
+		subject        varchar     NOT NULL,
+		game_id        varchar     NOT NULL,
+		ply            integer     NOT NULL,
+		move           varchar     NOT NULL,
+		classification varchar     NOT NULL,
+		best_move      varchar     NOT NULL,
+		loss           integer     NOT NULL,
+		explanation    text        NOT NULL DEFAULT '',
+		created_at     timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (subject, game_id, ply)
+	)`,
+}
+
+// migrate creates any missing API tables.
+func (s *Server) migrate(ctx context.Context) error {
+	for _, stmt := range schema {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("applying schema: %w", err)
+		}
+	}
+	return nil
+}
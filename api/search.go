@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/engine"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/uci"
+	"github.com/notnil/chess"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Names of the engines behind searchPosition.
+const (
+	engineBuiltin = "builtin"
+	engineUCI     = "uci"
+)
+
+// startUCIEngine starts the configured UCI engine.
+func startUCIEngine(cfg Config) (*uci.Engine, error) {
+	options := make(map[string]string)
+	for _, opt := range strings.Split(cfg.UCIEngineOptions, ",") {
+		if strings.TrimSpace(opt) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid UCI_ENGINE_OPTIONS entry %q, want name=value", opt)
+		}
+		options[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return uci.Start(ctx, cfg.UCIEngine, nil, options)
+}
+
+// candidate is an engine's line for one move. Score is in centipawns
+// from the point of view of the side to move, with mates scored as by
+// the built-in engine. Depth is how many plies deep the line was
+// searched, or 0 if the engine didn't say.
+type candidate struct {
+	Move  *chess.Move
+	SAN   string
+	Score int
+	Depth int
+	PV    []string
+}
+
+// searchPosition asks the engine for the best multiPV moves in pos,
+// best first. It uses the UCI engine if one is configured and the
+// built-in engine otherwise. A depth of zero searches as deep as the
+// configuration allows.
+func (s *Server) searchPosition(ctx context.Context, pos *chess.Position, multiPV, depth int) (_ []candidate, err error) {
+	name := engineBuiltin
+	if s.uci != nil {
+		name = engineUCI
+	}
+	ctx, span := tracer.Start(ctx, "engine.search", trace.WithAttributes(
+		attribute.String("engine.name", name),
+		attribute.Int("engine.multipv", multiPV),
+		attribute.Int("engine.depth", depth),
+	))
+	defer func() { endSpan(span, err) }()
+
+	start := time.Now()
+	var cands []candidate
+	if s.uci != nil {
+		cands, err = s.searchUCI(ctx, pos, multiPV, depth)
+
+		// The UCI engine restarts itself when it can; if it can't, the
+		// built-in engine takes over rather than failing every search.
+		if errors.Is(err, uci.ErrExited) && ctx.Err() == nil {
+			slog.WarnContext(ctx, "uci engine unavailable, using the built-in engine", "error", err)
+			name = engineBuiltin
+			span.SetAttributes(attribute.String("engine.name", name))
+			cands, err = s.searchBuiltin(ctx, pos, multiPV, depth)
+		}
+	} else {
+		cands, err = s.searchBuiltin(ctx, pos, multiPV, depth)
+	}
+	engineSearchDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+	if len(cands) > 0 {
+		span.SetAttributes(
+			attribute.String("engine.move", cands[0].SAN),
+			attribute.Int("engine.score", cands[0].Score),
+		)
+	}
+
+	return cands, nil
+}
+
+func (s *Server) searchBuiltin(ctx context.Context, pos *chess.Position, multiPV, depth int) ([]candidate, error) {
+	if depth <= 0 {
+		depth = s.cfg.EngineDepth
+	}
+	result, err := engine.Search(ctx, pos, engine.Options{
+		Depth:    depth,
+		MoveTime: s.cfg.EngineMoveTime,
+		MultiPV:  multiPV,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cands := make([]candidate, len(result.Lines))
+	for i, line := range result.Lines {
+		pv := make([]string, 0, len(line.PV))
+		p := pos
+		for _, m := range line.PV {
+			pv = append(pv, chess.AlgebraicNotation{}.Encode(p, m))
+			p = p.Update(m)
+		}
+		cands[i] = candidate{
+			Move:  line.Move,
+			SAN:   chess.AlgebraicNotation{}.Encode(pos, line.Move),
+			Score: line.Score,
+			Depth: result.Depth,
+			PV:    pv,
+		}
+	}
+	return cands, nil
+}
+
+func (s *Server) searchUCI(ctx context.Context, pos *chess.Position, multiPV, depth int) ([]candidate, error) {
+	result, err := s.uci.Search(ctx, uci.Params{
+		FEN:      pos.String(),
+		Depth:    depth,
+		MoveTime: s.cfg.EngineMoveTime,
+		MultiPV:  multiPV,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var cands []candidate
+	for _, line := range result.Lines {
+		var pv []string
+		var first *chess.Move
+		p := pos
+		for _, text := range line.PV {
+			m := findMove(p, text)
+			if m == nil {
+				break
+			}
+			if first == nil {
+				first = m
+			}
+			pv = append(pv, chess.AlgebraicNotation{}.Encode(p, m))
+			p = p.Update(m)
+		}
+		if first == nil {
+			continue
+		}
+		cands = append(cands, candidate{
+			Move:  first,
+			SAN:   pv[0],
+			Score: line.Score.Centipawns(),
+			Depth: line.Depth,
+			PV:    pv,
+		})
+	}
+
+	// Some engines report no lines for forced or very quick moves.
+	if len(cands) == 0 {
+		m := findMove(pos, result.BestMove)
+		if m == nil {
+			return nil, fmt.Errorf("engine returned an illegal move %q", result.BestMove)
+		}
+		san := chess.AlgebraicNotation{}.Encode(pos, m)
+		cands = append(cands, candidate{Move: m, SAN: san, PV: []string{san}})
+	}
+
+	return cands, nil
+}
+
+// findMove returns the legal move of pos written in UCI notation, or nil.
+func findMove(pos *chess.Position, text string) *chess.Move {
+	notation := chess.UCINotation{}
+	for _, m := range pos.ValidMoves() {
+		if notation.Encode(pos, m) == text {
+			return m
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/engine"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/polyglot"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/syzygy"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/uci"
+	_ "github.com/lib/pq"
+	"github.com/predictionguard/go-client"
+)
+
+// Config holds the settings needed to run the API.
+type Config struct {
+	Addr      string
+	DBConnStr string
+	LLMHost   string
+	LLMAPIKey string
+
+	// ShutdownTimeout bounds how long in-flight requests (which can
+	// chain several LLM calls) are given to finish on SIGTERM.
+	ShutdownTimeout time.Duration
+
+	// OTLPEndpoint is the OTLP/HTTP traces endpoint. Tracing is a
+	// no-op when it is empty.
+	OTLPEndpoint string
+
+	// LogLevel is one of debug, info, warn or error. Full LLM prompts
+	// are only logged at debug.
+	LogLevel string
+
+	// APIKeys is a comma separated list of key:subject[:quota] entries.
+	// JWTSecret verifies HS256 bearer tokens. With neither set the API
+	// does not require authentication.
+	APIKeys   string
+	JWTSecret string
+
+	// DailyQuota is the default number of LLM-backed calls allowed per
+	// subject per day. Zero means unlimited.
+	DailyQuota int
+
+	// Per-client rate limits, in requests per minute with a burst, for
+	// LLM-backed routes, for routes running engine searches and for
+	// everything else. A zero rate disables limiting for the class.
+	LLMRatePerMin     int
+	LLMRateBurst      int
+	EngineRatePerMin  int
+	EngineRateBurst   int
+	DefaultRatePerMin int
+	DefaultRateBurst  int
+
+	// JobWorkers is the number of jobs run at once. JobQueueLimit caps
+	// the jobs waiting for a worker and JobTimeout bounds each run.
+	JobWorkers    int
+	JobQueueLimit int
+	JobTimeout    time.Duration
+
+	// EngineDepth and EngineMoveTime bound engine searches, e.g. for a
+	// fallback move when the LLM cannot produce a legal one. A UCI engine
+	// is only bounded by time, as its depths are not comparable.
+	EngineDepth    int
+	EngineMoveTime time.Duration
+
+	// BlunderMargin is how many centipawns worse than the engine's best
+	// move an LLM move may be before it is rejected as a blunder, for
+	// requests that don't set a difficulty. Zero lets any legal move
+	// through.
+	BlunderMargin int
+
+	// UCIEngine is the path of a UCI engine binary, like Stockfish, to
+	// use instead of the built-in engine. UCIEngineOptions is a comma
+	// separated list of name=value engine options, e.g. Threads=2.
+	UCIEngine        string
+	UCIEngineOptions string
+
+	// OpeningBook is the path of a Polyglot .bin opening book to play the
+	// AI's first BookPlies plies of a game from. BookVariety ranges from
+	// 0, always the book's main move, to 1, moves picked in proportion to
+	// their weights.
+	OpeningBook string
+	BookPlies   int
+	BookVariety float64
+
+	// SyzygyPath lists the directories, separated like PATH, holding
+	// Syzygy endgame tables. Positions they cover are played from the
+	// tables instead of by the LLM.
+	SyzygyPath string
+}
+
+// loadConfig reads the API configuration from env vars.
+func loadConfig() Config {
+	cfg := Config{
+		Addr:      ":8080",
+		DBConnStr: os.Getenv("DB_CONN_STR"),
+		LLMHost:   "https://api.predictionguard.com",
+		LLMAPIKey: os.Getenv("PREDICTIONGUARD_API_KEY"),
+
+		ShutdownTimeout: 60 * time.Second,
+		OTLPEndpoint:    os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"),
+		LogLevel:        os.Getenv("LOG_LEVEL"),
+		APIKeys:         os.Getenv("API_KEYS"),
+		JWTSecret:       os.Getenv("JWT_SECRET"),
+		DailyQuota:      200,
+
+		LLMRatePerMin:     10,
+		LLMRateBurst:      3,
+		EngineRatePerMin:  30,
+		EngineRateBurst:   5,
+		DefaultRatePerMin: 600,
+		DefaultRateBurst:  20,
+
+		JobWorkers:    2,
+		JobQueueLimit: 100,
+		JobTimeout:    15 * time.Minute,
+
+		EngineDepth:    engine.DefaultDepth,
+		EngineMoveTime: engine.DefaultMoveTime,
+
+		UCIEngine:        os.Getenv("UCI_ENGINE"),
+		UCIEngineOptions: os.Getenv("UCI_ENGINE_OPTIONS"),
+
+		OpeningBook: os.Getenv("OPENING_BOOK"),
+		BookPlies:   12,
+		BookVariety: 0.5,
+
+		SyzygyPath: os.Getenv("SYZYGY_PATH"),
+	}
+	if addr := os.Getenv("API_ADDR"); addr != "" {
+		cfg.Addr = addr
+	}
+	if host := os.Getenv("PREDICTIONGUARD_HOST"); host != "" {
+		cfg.LLMHost = host
+	}
+	if d, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil {
+		cfg.ShutdownTimeout = d
+	}
+	envInt("DAILY_QUOTA", &cfg.DailyQuota)
+	envInt("RATE_LIMIT_LLM_PER_MIN", &cfg.LLMRatePerMin)
+	envInt("RATE_LIMIT_LLM_BURST", &cfg.LLMRateBurst)
+	envInt("RATE_LIMIT_ENGINE_PER_MIN", &cfg.EngineRatePerMin)
+	envInt("RATE_LIMIT_ENGINE_BURST", &cfg.EngineRateBurst)
+	envInt("RATE_LIMIT_DEFAULT_PER_MIN", &cfg.DefaultRatePerMin)
+	envInt("RATE_LIMIT_DEFAULT_BURST", &cfg.DefaultRateBurst)
+	envInt("JOB_WORKERS", &cfg.JobWorkers)
+	envInt("JOB_QUEUE_LIMIT", &cfg.JobQueueLimit)
+	if d, err := time.ParseDuration(os.Getenv("JOB_TIMEOUT")); err == nil {
+		cfg.JobTimeout = d
+	}
+	envInt("ENGINE_DEPTH", &cfg.EngineDepth)
+	envInt("BLUNDER_MARGIN", &cfg.BlunderMargin)
+	if d, err := time.ParseDuration(os.Getenv("ENGINE_MOVE_TIME")); err == nil {
+		cfg.EngineMoveTime = d
+	}
+	envInt("OPENING_BOOK_PLIES", &cfg.BookPlies)
+	if v, err := strconv.ParseFloat(os.Getenv("OPENING_BOOK_VARIETY"), 64); err == nil {
+		cfg.BookVariety = v
+	}
+	return cfg
+}
+
+// envInt overrides *v with the integer value of env var name, if set.
+func envInt(name string, v *int) {
+	if i, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		*v = i
+	}
+}
+
+// Server holds the long-lived dependencies shared by the handlers.
+type Server struct {
+	cfg Config
+	db  *sql.DB
+	llm *client.Client
+
+	apiKeys  map[[32]byte]Principal
+	limiters map[string]*rateLimiter
+	sessions *sessionStore
+	jobWake  chan struct{}
+	uci      *uci.Engine
+	book     *polyglot.Book
+	tb       *syzygy.Tablebase
+}
+
+// NewServer opens the DB pool, checks the connection and creates
+// the LLM client.
+func NewServer(cfg Config) (*Server, error) {
+
+	// Open a pooled DB handle to share across requests.
+	db, err := sql.Open("postgres", cfg.DBConnStr)
+	if err != nil {
+		return nil, fmt.Errorf("opening db: %w", err)
+	}
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+
+	// Check the connection.
+	var version string
+	if err := db.QueryRow("select version()").Scan(&version); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("checking db connection: %w", err)
+	}
+	slog.Info("checked db connection", "version", version)
+
+	apiKeys, err := parseAPIKeys(cfg.APIKeys, cfg.DailyQuota)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := Server{
+		cfg:      cfg,
+		db:       db,
+		llm:      client.New(llmLogger, cfg.LLMHost, cfg.LLMAPIKey),
+		apiKeys:  apiKeys,
+		sessions: newSessionStore(),
+		jobWake:  make(chan struct{}, 1),
+	}
+
+	s.limiters = make(map[string]*rateLimiter)
+	if cfg.LLMRatePerMin > 0 {
+		s.limiters[rateClassLLM] = newRateLimiter(cfg.LLMRatePerMin, cfg.LLMRateBurst)
+	}
+	if cfg.EngineRatePerMin > 0 {
+		s.limiters[rateClassEngine] = newRateLimiter(cfg.EngineRatePerMin, cfg.EngineRateBurst)
+	}
+	if cfg.DefaultRatePerMin > 0 {
+		s.limiters[rateClassDefault] = newRateLimiter(cfg.DefaultRatePerMin, cfg.DefaultRateBurst)
+	}
+
+	// Create the API's own tables.
+	if err := s.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Index the ECO openings ahead of the first move.
+	go ecoBook()
+
+	// Load the opening book, if any.
+	if cfg.OpeningBook != "" {
+		if s.book, err = polyglot.Open(cfg.OpeningBook); err != nil {
+			db.Close()
+			return nil, err
+		}
+		slog.Info("loaded opening book", "path", cfg.OpeningBook, "entries", s.book.Len())
+	}
+
+	// Find the endgame tables, if any.
+	if cfg.SyzygyPath != "" {
+		if s.tb, err = syzygy.Open(cfg.SyzygyPath); err != nil {
+			db.Close()
+			return nil, err
+		}
+		slog.Info("found endgame tables", "path", cfg.SyzygyPath, "tables", s.tb.Len(), "max_pieces", s.tb.MaxPieces())
+	}
+
+	// Start the external engine, if any.
+	if cfg.UCIEngine != "" {
+		if s.uci, err = startUCIEngine(cfg); err != nil {
+			db.Close()
+			return nil, err
+		}
+		slog.Info("started uci engine", "name", s.uci.Name, "path", cfg.UCIEngine)
+	}
+	if !s.authEnabled() {
+		slog.Warn("no API_KEYS or JWT_SECRET configured, authentication is disabled")
+	}
+
+	return &s, nil
+}
+
+// Close releases the resources held by the server.
+func (s *Server) Close() error {
+	if s.uci != nil {
+		s.uci.Close()
+	}
+	if s.tb != nil {
+		s.tb.Close()
+	}
+	return s.db.Close()
+}
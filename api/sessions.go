@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/gorilla/mux"
+	"github.com/notnil/chess"
+)
+
+// sessionIdleTimeout is how long a game session without watchers or
+// moves is kept in memory.
+const sessionIdleTimeout = 2 * time.Hour
+
+// subscriber receives the events of a game session. Players hold the
+// seat of their color; spectators have no color.
+type subscriber struct {
+	events chan GameEvent
+	color  chess.Color
+}
+
+// errSeatTaken is returned when joining a seat that has a player.
+var errSeatTaken = errors.New("seat is already taken")
+
+// gameSession is a game shared by its WebSocket clients.
+type gameSession struct {
+	id         string
+	mode       string
+	commentary bool
+
+	mu         sync.Mutex
+	seats      map[chess.Color]*subscriber
+	game       *chess.Game
+	busy       bool
+	subs       map[*subscriber]struct{}
+	lastActive time.Time
+}
+
+// snapshot returns a copy of the game for reading or playing on.
+func (gs *gameSession) snapshot() *chess.Game {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.game.Clone()
+}
+
+// begin claims the session for a move or help request, failing if one
+// is already running.
+func (gs *gameSession) begin() bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.busy {
+		return false
+	}
+	gs.busy = true
+	gs.lastActive = time.Now()
+	return true
+}
+
+// end releases the session claimed with begin.
+func (gs *gameSession) end() {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.busy = false
+}
+
+// setGame replaces the session's game.
+func (gs *gameSession) setGame(game *chess.Game) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.game = game
+	gs.lastActive = time.Now()
+}
+
+// seatFor returns the seat a client asking for role should take, or
+// NoColor for spectators.
+func (gs *gameSession) seatFor(role string) (chess.Color, error) {
+	switch role {
+	case "spectator":
+		return chess.NoColor, nil
+	case "white":
+		return chess.White, nil
+	case "black":
+		if gs.mode == chessapi.ModeAI {
+			return chess.NoColor, errors.New("the LLM plays black in this game")
+		}
+		return chess.Black, nil
+	case "", "player":
+		if gs.mode == chessapi.ModeAI {
+			return chess.White, nil
+		}
+
+		// Take the first free seat.
+		gs.mu.Lock()
+		defer gs.mu.Unlock()
+		for _, c := range []chess.Color{chess.White, chess.Black} {
+			if gs.seats[c] == nil {
+				return c, nil
+			}
+		}
+		return chess.NoColor, errSeatTaken
+	}
+	return chess.NoColor, fmt.Errorf("unknown role %q", role)
+}
+
+// subscribe adds a client to the session, seating it at color unless
+// it is a spectator.
+func (gs *gameSession) subscribe(color chess.Color) (*subscriber, error) {
+	sub := subscriber{
+		// Room for a streamed commentary's deltas before a slow client
+		// is dropped.
+		events: make(chan GameEvent, 128),
+		color:  color,
+	}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if color != chess.NoColor {
+		if gs.seats[color] != nil {
+			return nil, errSeatTaken
+		}
+		gs.seats[color] = &sub
+	}
+	gs.subs[&sub] = struct{}{}
+	gs.lastActive = time.Now()
+	return &sub, nil
+}
+
+// unsubscribe removes a client from the session, freeing its seat.
+func (gs *gameSession) unsubscribe(sub *subscriber) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.drop(sub)
+}
+
+// drop removes sub from the session. gs.mu must be held.
+func (gs *gameSession) drop(sub *subscriber) {
+	if gs.seats[sub.color] == sub {
+		delete(gs.seats, sub.color)
+	}
+	if _, ok := gs.subs[sub]; ok {
+		delete(gs.subs, sub)
+		close(sub.events)
+	}
+}
+
+// broadcast sends ev to every client. Clients too slow to keep up are
+// dropped rather than blocking the game.
+func (gs *gameSession) broadcast(ev GameEvent) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for sub := range gs.subs {
+		select {
+		case sub.events <- ev:
+		default:
+			gs.drop(sub)
+		}
+	}
+}
+
+// response describes the session for the REST endpoints.
+func (gs *gameSession) response() GameSessionResponse {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	resp := GameSessionResponse{
+		ID:         gs.id,
+		Mode:       gs.mode,
+		Commentary: gs.commentary,
+		Game:       strings.TrimPrefix(gs.game.String(), "\n"),
+		State:      gameState(gs.game),
+		Seats:      []string{},
+		Watchers:   len(gs.subs),
+	}
+	for _, c := range []chess.Color{chess.White, chess.Black} {
+		if gs.seats[c] != nil {
+			resp.Seats = append(resp.Seats, strings.ToLower(c.Name()))
+		}
+	}
+	return resp
+}
+
+// sessionStore holds the game sessions in memory.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*gameSession
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{sessions: make(map[string]*gameSession)}
+}
+
+// create starts a session for game, dropping sessions that have been
+// idle for too long.
+func (st *sessionStore) create(game *chess.Game, mode string, commentary bool) *gameSession {
+	gs := gameSession{
+		id:         newID(),
+		mode:       mode,
+		commentary: commentary,
+		game:       game,
+		seats:      make(map[chess.Color]*subscriber),
+		subs:       make(map[*subscriber]struct{}),
+		lastActive: time.Now(),
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for id, old := range st.sessions {
+		old.mu.Lock()
+		idle := len(old.subs) == 0 && !old.busy && time.Since(old.lastActive) > sessionIdleTimeout
+		old.mu.Unlock()
+		if idle {
+			delete(st.sessions, id)
+		}
+	}
+	st.sessions[gs.id] = &gs
+	return &gs
+}
+
+// get returns the session with the given ID.
+func (st *sessionStore) get(id string) (*gameSession, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	gs, ok := st.sessions[id]
+	return gs, ok
+}
+
+// closeAll disconnects every client, e.g. on shutdown.
+func (st *sessionStore) closeAll() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, gs := range st.sessions {
+		gs.mu.Lock()
+		for sub := range gs.subs {
+			gs.drop(sub)
+		}
+		gs.mu.Unlock()
+	}
+}
+
+// CreateGame starts a game session.
+func (s *Server) CreateGame(w http.ResponseWriter, r *http.Request) {
+
+	// Parse the body into a value of CreateGameRequest.
+	var req CreateGameRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse the game, if any.
+	game := chess.NewGame()
+	if strings.TrimSpace(req.Game) != "" {
+		pgn, err := chess.PGN(bytes.NewReader([]byte(req.Game)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		game = chess.NewGame(pgn)
+	}
+
+	// Check the mode.
+	switch req.Mode {
+	case "":
+		req.Mode = chessapi.ModeAI
+	case chessapi.ModeAI, chessapi.ModeHuman:
+	default:
+		http.Error(w, fmt.Sprintf("unknown mode %q", req.Mode), http.StatusBadRequest)
+		return
+	}
+
+	// Prep the response.
+	resp := s.sessions.create(game, req.Mode, req.Commentary).response()
+
+	// Return the response.
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetGame returns a game session.
+func (s *Server) GetGame(w http.ResponseWriter, r *http.Request) {
+	gs, ok := s.sessions.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "game not found", http.StatusNotFound)
+		return
+	}
+
+	// Return the response.
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(gs.response())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// stateEvent returns an event of type typ carrying the current game.
+// For moves, color is the side that moved.
+func stateEvent(typ string, move string, color chess.Color, game *chess.Game) GameEvent {
+	state := gameState(game)
+	ev := GameEvent{
+		Type:  typ,
+		Move:  move,
+		Game:  strings.TrimPrefix(game.String(), "\n"),
+		State: &state,
+	}
+	if color != chess.NoColor {
+		ev.Color = strings.ToLower(color.Name())
+	}
+	return ev
+}
+
+// outcomeEvent returns the check, checkmate or game over event after
+// the last move, if any.
+func outcomeEvent(game *chess.Game) (GameEvent, bool) {
+	switch {
+	case game.Method() == chess.Checkmate:
+		return GameEvent{Type: chessapi.EventCheckmate, Message: string(game.Outcome())}, true
+	case game.Outcome() != chess.NoOutcome:
+		return GameEvent{Type: chessapi.EventGameOver, Message: string(game.Outcome()) + " by " + game.Method().String()}, true
+	}
+	moves := game.Moves()
+	if len(moves) > 0 && moves[len(moves)-1].HasTag(chess.Check) {
+		return GameEvent{Type: chessapi.EventCheck, Message: game.Position().Turn().Name() + " is in check"}, true
+	}
+	return GameEvent{}, false
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/notnil/chess"
+)
+
+const (
+	socketWriteWait  = 10 * time.Second
+	socketPongWait   = 60 * time.Second
+	socketPingPeriod = socketPongWait * 9 / 10
+
+	// socketCommandTimeout bounds a move (parse plus up to 10 LLM
+	// attempts) or help pipeline run from a WebSocket command.
+	socketCommandTimeout = 3 * time.Minute
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// GameSocket streams the events of a game session over a WebSocket.
+// Players can send GameCommand messages. The role query parameter picks
+// a seat: white or black (in human games), player for the first free
+// seat (the default), or spectator to only receive events.
+func (s *Server) GameSocket(w http.ResponseWriter, r *http.Request) {
+	gs, ok := s.sessions.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "game not found", http.StatusNotFound)
+		return
+	}
+
+	// Take a seat before upgrading so errors are plain HTTP responses.
+	color, err := gs.seatFor(r.URL.Query().Get("role"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	sub, err := gs.subscribe(color)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	defer gs.unsubscribe(sub)
+	spectator := color == chess.NoColor
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client.
+		return
+	}
+	defer conn.Close()
+
+	p, _ := principalFromContext(r.Context())
+	ctx := context.WithoutCancel(r.Context())
+	slog.InfoContext(ctx, "game socket connected", "game", gs.id, "color", color.Name())
+
+	// Read commands until the client goes away.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.SetReadLimit(4096)
+		conn.SetReadDeadline(time.Now().Add(socketPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(socketPongWait))
+		})
+		for {
+			var cmd GameCommand
+			if err := conn.ReadJSON(&cmd); err != nil {
+				return
+			}
+			if spectator {
+				s.sendTo(gs, sub, GameEvent{Type: chessapi.EventError, Error: "spectators cannot send commands"})
+				continue
+			}
+			go s.runGameCommand(ctx, gs, sub, p, cmd)
+		}
+	}()
+
+	// Write events and keep the connection alive.
+	ping := time.NewTicker(socketPingPeriod)
+	defer ping.Stop()
+
+	s.sendTo(gs, sub, stateEvent(chessapi.EventState, "", chess.NoColor, gs.snapshot()))
+	for {
+		select {
+		case ev, ok := <-sub.events:
+			conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+				return
+			}
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// sendTo sends ev to a single client of the session.
+func (s *Server) sendTo(gs *gameSession, sub *subscriber, ev GameEvent) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if _, ok := gs.subs[sub]; !ok {
+		return
+	}
+	select {
+	case sub.events <- ev:
+	default:
+	}
+}
+
+// runGameCommand runs a player's command, broadcasting the resulting
+// events to every client of the session.
+func (s *Server) runGameCommand(ctx context.Context, gs *gameSession, sub *subscriber, p Principal, cmd GameCommand) {
+	ctx, cancel := context.WithTimeout(ctx, socketCommandTimeout)
+	defer cancel()
+
+	fail := func(err error) {
+		s.sendTo(gs, sub, GameEvent{Type: chessapi.EventError, Error: err.Error()})
+	}
+
+	route := map[string]string{
+		chessapi.CommandMove: "ParseMove",
+		chessapi.CommandHelp: "GenHelp",
+	}[cmd.Type]
+	if route == "" {
+		fail(fmt.Errorf("unknown command %q", cmd.Type))
+		return
+	}
+
+	if !gs.begin() {
+		fail(errors.New("the game is busy, wait for the current move to finish"))
+		return
+	}
+	defer gs.end()
+
+	if err := s.chargeQuota(ctx, p, route, 1); err != nil {
+		fail(err)
+		return
+	}
+
+	switch cmd.Type {
+	case chessapi.CommandMove:
+		s.playSessionMove(ctx, gs, sub, strings.TrimSpace(cmd.Move), fail)
+	case chessapi.CommandHelp:
+		game := gs.snapshot()
+		resp, err := s.genHelp(ctx, game, strings.TrimPrefix(game.String(), "\n"), helpObserver{})
+		if err != nil {
+			fail(err)
+			return
+		}
+		gs.broadcast(GameEvent{
+			Type:          chessapi.EventCoaching,
+			Message:       resp.Message,
+			ReferenceInfo: resp.ReferenceInfo,
+		})
+	}
+}
+
+// playSessionMove applies a player's move. In games against the LLM,
+// the LLM then replies.
+func (s *Server) playSessionMove(ctx context.Context, gs *gameSession, sub *subscriber, text string, fail func(error)) {
+	game := gs.snapshot()
+	if game.Outcome() != chess.NoOutcome {
+		fail(errors.New("the game is over"))
+		return
+	}
+	color := game.Position().Turn()
+	if color != sub.color {
+		fail(errors.New("it is not your turn"))
+		return
+	}
+
+	move, err := s.applyUserMove(ctx, game, text)
+	if err != nil {
+		fail(err)
+		return
+	}
+	s.publishMove(ctx, gs, chessapi.EventUserMove, move, "", color, game)
+	if gs.mode != chessapi.ModeAI || game.Outcome() != chess.NoOutcome {
+		return
+	}
+
+	gs.broadcast(GameEvent{Type: chessapi.EventAIThinking})
+	move, source, err := s.applyAIMove(ctx, game, s.cfg.BlunderMargin)
+	if err != nil {
+		gs.broadcast(GameEvent{Type: chessapi.EventError, Error: "LLaMA 3 got stumped and gave up: " + err.Error()})
+		return
+	}
+	s.publishMove(ctx, gs, chessapi.EventAIMove, move, source, chess.Black, game)
+}
+
+// publishMove stores the game after a move and broadcasts the move, any
+// check or result and, if enabled, the LLM's commentary. Source is set
+// for moves made by the API.
+func (s *Server) publishMove(ctx context.Context, gs *gameSession, typ string, move string, source string, color chess.Color, game *chess.Game) {
+	gs.setGame(game.Clone())
+	ev := stateEvent(typ, move, color, game)
+	ev.Source = source
+	gs.broadcast(ev)
+	if ev, ok := outcomeEvent(game); ok {
+		gs.broadcast(ev)
+	}
+	if !gs.commentary {
+		return
+	}
+
+	// Comment in the background so the game doesn't wait on it.
+	pgn := strings.TrimPrefix(game.String(), "\n")
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
+
+		side := strings.ToLower(color.Name())
+		comment, err := s.generateCommentaryWithLLM(ctx, pgn, move, side, func(token string) {
+			gs.broadcast(GameEvent{
+				Type:    chessapi.EventCommentaryDelta,
+				Move:    move,
+				Color:   side,
+				Message: token,
+			})
+		})
+		if err != nil {
+			slog.WarnContext(ctx, "commentary failed", "game", gs.id, "error", err)
+			return
+		}
+		gs.broadcast(GameEvent{
+			Type:    chessapi.EventCommentary,
+			Move:    move,
+			Color:   side,
+			Message: comment,
+		})
+	}()
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/gorilla/websocket"
+)
+
+// sessionServer serves the API for game session tests. Natural language
+// moves are parsed by llm.
+func sessionServer(t *testing.T, s *Server, llm func(system, user string) (string, error)) *httptest.Server {
+	t.Helper()
+	s.sessions = newSessionStore()
+	s.llm = fakeLLM(t, llm)
+	srv := httptest.NewServer(NewRouter(s))
+	t.Cleanup(srv.Close)
+	t.Cleanup(s.sessions.closeAll)
+	return srv
+}
+
+// createGame starts a session through the API and returns its ID.
+func createGame(t *testing.T, srv *httptest.Server, key string, req CreateGameRequest) string {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	r, _ := http.NewRequest(http.MethodPost, srv.URL+"/games", bytes.NewReader(body))
+	if key != "" {
+		r.Header.Set("X-API-Key", key)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var game GameSessionResponse
+	if resp.StatusCode != http.StatusCreated || json.NewDecoder(resp.Body).Decode(&game) != nil || game.ID == "" {
+		t.Fatalf("creating a game: status %d, %+v", resp.StatusCode, game)
+	}
+	return game.ID
+}
+
+// gameClient is a test client of a game session's WebSocket.
+type gameClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+// dial connects to a session with the given role, returning the HTTP
+// status when the connection is refused.
+func dial(t *testing.T, srv *httptest.Server, id, role, key string) (*gameClient, int) {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/games/" + id + "/ws?role=" + role
+	header := http.Header{}
+	if key != "" {
+		header.Set("X-API-Key", key)
+	}
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		if resp == nil {
+			t.Fatal(err)
+		}
+		return nil, resp.StatusCode
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &gameClient{t: t, conn: conn}
+	if ev := c.next(); ev.Type != chessapi.EventState {
+		t.Fatalf("first event %+v, want the state", ev)
+	}
+	return c, http.StatusSwitchingProtocols
+}
+
+// send sends a command.
+func (c *gameClient) send(typ, move string) {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(GameCommand{Type: typ, Move: move}); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// next returns the next event, failing the test if none comes soon.
+func (c *gameClient) next() GameEvent {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var ev GameEvent
+	if err := c.conn.ReadJSON(&ev); err != nil {
+		c.t.Fatalf("waiting for an event: %v", err)
+	}
+	return ev
+}
+
+// TestGameSocket drives a human game with two players and a spectator.
+func TestGameSocket(t *testing.T) {
+	parsing := make(chan struct{})
+	release := make(chan struct{})
+	srv := sessionServer(t, &Server{}, func(system, user string) (string, error) {
+		close(parsing)
+		<-release
+		return "d5", nil
+	})
+	var released bool
+	t.Cleanup(func() {
+		if !released {
+			close(release)
+		}
+	})
+	id := createGame(t, srv, "", CreateGameRequest{Mode: chessapi.ModeHuman})
+
+	// Players take the free seats in turn; a third is turned away.
+	white, _ := dial(t, srv, id, "player", "")
+	black, _ := dial(t, srv, id, "player", "")
+	if _, status := dial(t, srv, id, "player", ""); status != http.StatusConflict {
+		t.Errorf("third player: status %d, want %d", status, http.StatusConflict)
+	}
+	if _, status := dial(t, srv, id, "white", ""); status != http.StatusConflict {
+		t.Errorf("second white player: status %d, want %d", status, http.StatusConflict)
+	}
+	watcher, _ := dial(t, srv, id, "spectator", "")
+
+	// A move reaches everyone.
+	white.send(chessapi.CommandMove, "e2e4")
+	for name, c := range map[string]*gameClient{"white": white, "black": black, "spectator": watcher} {
+		if ev := c.next(); ev.Type != chessapi.EventUserMove || ev.Move != "e4" || ev.Color != "white" {
+			t.Errorf("%s got %+v, want white's e4", name, ev)
+		}
+	}
+
+	// Players only move in turn, and spectators not at all. The next
+	// events checked below show nobody else heard of these.
+	white.send(chessapi.CommandMove, "d2d4")
+	if ev := white.next(); ev.Type != chessapi.EventError || !strings.Contains(ev.Error, "not your turn") {
+		t.Errorf("white moving twice got %+v", ev)
+	}
+	watcher.send(chessapi.CommandMove, "d7d5")
+	if ev := watcher.next(); ev.Type != chessapi.EventError || !strings.Contains(ev.Error, "spectators") {
+		t.Errorf("spectator moving got %+v", ev)
+	}
+
+	// While black's move is being parsed, the game is busy.
+	black.send(chessapi.CommandMove, "pawn to d5")
+	<-parsing
+	white.send(chessapi.CommandMove, "d2d4")
+	if ev := white.next(); ev.Type != chessapi.EventError || !strings.Contains(ev.Error, "busy") {
+		t.Errorf("moving while busy got %+v", ev)
+	}
+	close(release)
+	released = true
+	for name, c := range map[string]*gameClient{"white": white, "black": black, "spectator": watcher} {
+		if ev := c.next(); ev.Type != chessapi.EventUserMove || ev.Move != "d5" || ev.Color != "black" {
+			t.Errorf("%s got %+v, want black's d5", name, ev)
+		}
+	}
+
+	// Unknown games are not found.
+	if _, status := dial(t, srv, "nope", "player", ""); status != http.StatusNotFound {
+		t.Errorf("unknown game: status %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+// TestGameSocketQuota checks that each command is charged to the
+// caller. Without a database to charge, commands fail and the game
+// stays as it was; with one, from TEST_DB_CONN_STR, moves stop at the
+// quota.
+func TestGameSocketQuota(t *testing.T) {
+	conn := os.Getenv("TEST_DB_CONN_STR")
+	quota := 1
+	if conn == "" {
+		conn = "postgres://chess@127.0.0.1:1/chess?sslmode=disable&connect_timeout=1"
+	}
+	db, err := sql.Open("postgres", conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	subject := "test-" + newID()
+	apiKeys, err := parseAPIKeys("key:"+subject, quota)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{db: db, apiKeys: apiKeys}
+	srv := sessionServer(t, s, func(system, user string) (string, error) {
+		return "", nil
+	})
+	id := createGame(t, srv, "key", CreateGameRequest{Mode: chessapi.ModeHuman})
+	if _, status := dial(t, srv, id, "white", ""); status != http.StatusUnauthorized {
+		t.Errorf("no credentials: status %d, want %d", status, http.StatusUnauthorized)
+	}
+	white, _ := dial(t, srv, id, "white", "key")
+	black, _ := dial(t, srv, id, "black", "key")
+
+	if os.Getenv("TEST_DB_CONN_STR") == "" {
+		white.send(chessapi.CommandMove, "e2e4")
+		if ev := white.next(); ev.Type != chessapi.EventError {
+			t.Errorf("move without a database to charge got %+v, want an error", ev)
+		}
+		if gs, _ := s.sessions.get(id); len(gs.snapshot().Moves()) != 0 {
+			t.Error("the move was played without being charged")
+		}
+		return
+	}
+
+	if err := s.migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM api_usage WHERE subject = $1", subject) })
+
+	white.send(chessapi.CommandMove, "e2e4")
+	if ev := black.next(); ev.Type != chessapi.EventUserMove {
+		t.Fatalf("first move got %+v", ev)
+	}
+	white.next()
+	black.send(chessapi.CommandMove, "e7e5")
+	if ev := black.next(); ev.Type != chessapi.EventError || !strings.Contains(ev.Error, errQuotaExceeded.Error()) {
+		t.Errorf("move over the quota got %+v, want %q", ev, errQuotaExceeded)
+	}
+	var used int
+	db.QueryRow("SELECT coalesce(sum(count), 0) FROM api_usage WHERE subject = $1 AND route = 'ParseMove'", subject).Scan(&used)
+	if used != quota {
+		t.Errorf("charged %d moves, want %d", used, quota)
+	}
+}
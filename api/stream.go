@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/notnil/chess"
+)
+
+// sseWriter writes server-sent events, flushing after each one.
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// newSSEWriter starts an event stream on w.
+func newSSEWriter(w http.ResponseWriter) (*sseWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("streaming is not supported")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &sseWriter{w: w, flusher: flusher}, nil
+}
+
+// send writes v as the JSON data of an event named event.
+func (sw *sseWriter) send(event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(sw.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	sw.flusher.Flush()
+	return nil
+}
+
+// GenHelpStream generates help messages for a game, streaming each stage
+// of the pipeline and then the answer as server-sent events.
+func (s *Server) GenHelpStream(w http.ResponseWriter, r *http.Request) {
+
+	// Parse the body into a value of GenHelpRequest.
+	var req GenHelpRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse the game.
+	pgnReader := bytes.NewReader([]byte(req.Game))
+	pgn, err := chess.PGN(pgnReader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	game := chess.NewGame(pgn)
+
+	// Errors from here on are sent as events since the status is out.
+	sw, err := newSSEWriter(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	streamHelp(r.Context(), sw, func(obs helpObserver) (GenHelpResponse, error) {
+		return s.genHelp(r.Context(), game, req.Game, obs)
+	})
+}
+
+// streamHelp runs a help pipeline, sending a progress event as it enters
+// each stage, the sources it retrieves and the answer token by token,
+// then a done event with the full response or an error event.
+func streamHelp(ctx context.Context, sw *sseWriter, run func(obs helpObserver) (GenHelpResponse, error)) {
+	send := func(event string, ev HelpStreamEvent) {
+		if err := sw.send(event, ev); err != nil {
+			slog.DebugContext(ctx, "help stream write failed", "event", event, "error", err)
+		}
+	}
+
+	// Run the help pipeline, forwarding its progress.
+	obs := helpObserver{
+		stage: func(stage string) {
+			send(chessapi.HelpEventProgress, HelpStreamEvent{Stage: stage})
+		},
+		sources: func(chunks VectorizedChunks) {
+			sources := make([]HelpSource, len(chunks))
+			for i, chunk := range chunks {
+				sources[i] = HelpSource{ID: chunk.Id, Chunk: chunk.Chunk, Distance: chunk.Distance}
+			}
+			send(chessapi.HelpEventSources, HelpStreamEvent{Sources: sources})
+		},
+		token: func(token string) {
+			send(chessapi.HelpEventToken, HelpStreamEvent{Token: token})
+		},
+	}
+	resp, err := run(obs)
+	if err != nil {
+		send(chessapi.HelpEventError, HelpStreamEvent{Error: err.Error()})
+		return
+	}
+
+	// Finish with the full response.
+	send(chessapi.HelpEventDone, HelpStreamEvent{
+		Message:       resp.Message,
+		ReferenceInfo: resp.ReferenceInfo,
+		Tactics:       resp.Tactics,
+		Tablebase:     resp.Tablebase,
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+)
+
+// sseEvent is an event read back from a stream.
+type sseEvent struct {
+	name string
+	data HelpStreamEvent
+}
+
+// readEvents splits a recorded event stream into its events.
+func readEvents(t *testing.T, body string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		name, data, ok := strings.Cut(block, "\n")
+		if !ok || !strings.HasPrefix(name, "event: ") || !strings.HasPrefix(data, "data: ") {
+			t.Fatalf("malformed event %q", block)
+		}
+		var ev sseEvent
+		ev.name = strings.TrimPrefix(name, "event: ")
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &ev.data); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestStreamHelp(t *testing.T) {
+	pipeline := func(fail error) func(obs helpObserver) (GenHelpResponse, error) {
+		return func(obs helpObserver) (GenHelpResponse, error) {
+			obs.enter(chessapi.HelpStageRender)
+			obs.enter(chessapi.HelpStageRetrieve)
+			if fail != nil {
+				return GenHelpResponse{}, fail
+			}
+			obs.sources(VectorizedChunks{{Id: 7, Chunk: "Knights before bishops.", Distance: 0.25}})
+			obs.enter(chessapi.HelpStageAnswer)
+			obs.token("Develop ")
+			obs.token("a knight.")
+			return GenHelpResponse{Message: "Develop a knight.", ReferenceInfo: "Knights before bishops."}, nil
+		}
+	}
+	tests := []struct {
+		name string
+		fail error
+		want []sseEvent
+	}{
+		{"answered", nil, []sseEvent{
+			{chessapi.HelpEventProgress, HelpStreamEvent{Stage: chessapi.HelpStageRender}},
+			{chessapi.HelpEventProgress, HelpStreamEvent{Stage: chessapi.HelpStageRetrieve}},
+			{chessapi.HelpEventSources, HelpStreamEvent{Sources: []HelpSource{{ID: 7, Chunk: "Knights before bishops.", Distance: 0.25}}}},
+			{chessapi.HelpEventProgress, HelpStreamEvent{Stage: chessapi.HelpStageAnswer}},
+			{chessapi.HelpEventToken, HelpStreamEvent{Token: "Develop "}},
+			{chessapi.HelpEventToken, HelpStreamEvent{Token: "a knight."}},
+			{chessapi.HelpEventDone, HelpStreamEvent{Message: "Develop a knight.", ReferenceInfo: "Knights before bishops."}},
+		}},
+		{"failed", errors.New("no reference info"), []sseEvent{
+			{chessapi.HelpEventProgress, HelpStreamEvent{Stage: chessapi.HelpStageRender}},
+			{chessapi.HelpEventProgress, HelpStreamEvent{Stage: chessapi.HelpStageRetrieve}},
+			{chessapi.HelpEventError, HelpStreamEvent{Error: "no reference info"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			sw, err := newSSEWriter(rec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			streamHelp(context.Background(), sw, pipeline(tt.fail))
+
+			if got := rec.Header().Get("Content-Type"); got != "text/event-stream" {
+				t.Errorf("content type %q", got)
+			}
+			got := readEvents(t, rec.Body.String())
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events %+v, want %d", len(got), got, len(tt.want))
+			}
+			for i := range got {
+				g, _ := json.Marshal(got[i].data)
+				w, _ := json.Marshal(tt.want[i].data)
+				if got[i].name != tt.want[i].name || string(g) != string(w) {
+					t.Errorf("event %d = %s %s, want %s %s", i, got[i].name, g, tt.want[i].name, w)
+				}
+			}
+		})
+	}
+}
+
+// TestGenHelpStream checks the handler: a bad request is a plain 400, and
+// a pipeline failure ends the stream with an error event after the
+// progress of the stages it got through.
+func TestGenHelpStream(t *testing.T) {
+	s := &Server{llm: fakeLLM(t, func(system, user string) (string, error) {
+		return "", errors.New("llm unavailable")
+	})}
+
+	rec := httptest.NewRecorder()
+	s.GenHelpStream(rec, httptest.NewRequest(http.MethodPost, "/help/stream", strings.NewReader(`{"game":`)))
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") == "text/event-stream" {
+		t.Errorf("bad request: status %d, content type %q, want a plain 400", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	rec = httptest.NewRecorder()
+	s.GenHelpStream(rec, httptest.NewRequest(http.MethodPost, "/help/stream", strings.NewReader(`{"game":"1. e4 *"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	events := readEvents(t, rec.Body.String())
+	last := events[len(events)-1]
+	if last.name != chessapi.HelpEventError || last.data.Error == "" {
+		t.Errorf("last event %+v, want an error", last)
+	}
+	stages := []string{chessapi.HelpStageRender, chessapi.HelpStageAnalyze, chessapi.HelpStageDescribe}
+	if len(events) < 2 || len(events)-1 > len(stages) {
+		t.Fatalf("got %d events, want progress through at most the %d stages before the LLM", len(events), len(stages))
+	}
+	for i, ev := range events[:len(events)-1] {
+		if ev.name != chessapi.HelpEventProgress || ev.data.Stage != stages[i] {
+			t.Errorf("event %d = %s %+v, want progress %s", i, ev.name, ev.data, stages[i])
+		}
+	}
+}
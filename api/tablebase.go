@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+	"github.com/dwhitena/go-genai-workshop-build/api/internal/syzygy"
+	"github.com/notnil/chess"
+)
+
+// applyTablebaseMove plays the tablebase's move if the endgame tables
+// cover the position of game. It returns an empty move otherwise, and
+// when the tables fail to give a move, so the LLM moves instead.
+func (s *Server) applyTablebaseMove(ctx context.Context, game *chess.Game) (string, error) {
+	pos := game.Position()
+	if s.tb == nil || !s.tb.Covers(pos) {
+		return "", nil
+	}
+
+	best, err := s.tb.BestMove(pos)
+	if err != nil {
+		if !errors.Is(err, syzygy.ErrNotFound) {
+			slog.WarnContext(ctx, "tablebase probe failed", "error", err)
+		}
+		return "", nil
+	}
+	move := chess.AlgebraicNotation{}.Encode(pos, best.Move)
+	if err := game.Move(best.Move); err != nil {
+		return "", err
+	}
+	slog.InfoContext(ctx, "tablebase move", "move", move, "result", best.WDL, "dtz", best.DTZ)
+
+	return move, nil
+}
+
+// probeTablebase returns the theoretical result of pos, or nil if the
+// endgame tables don't cover it. The distance and best move are left
+// out when there are no distance tables for the position.
+func (s *Server) probeTablebase(ctx context.Context, pos *chess.Position) *chessapi.Tablebase {
+	if s.tb == nil || !s.tb.Covers(pos) {
+		return nil
+	}
+
+	wdl, err := s.tb.ProbeWDL(pos)
+	if err != nil {
+		if !errors.Is(err, syzygy.ErrNotFound) {
+			slog.WarnContext(ctx, "tablebase probe failed", "error", err)
+		}
+		return nil
+	}
+	result := chessapi.Tablebase{Result: wdl.String()}
+
+	dtz, err := s.tb.ProbeDTZ(pos)
+	if err == nil {
+		var best syzygy.Move
+		if best, err = s.tb.BestMove(pos); err == nil {
+			result.DTZ = dtz
+			result.BestMove = chess.AlgebraicNotation{}.Encode(pos, best.Move)
+		}
+	}
+	if err != nil && !errors.Is(err, syzygy.ErrNotFound) {
+		slog.WarnContext(ctx, "tablebase probe failed", "error", err)
+	}
+
+	return &result
+}
+
+// tablebaseFact states the theoretical result of a position in a
+// sentence, for the side to move turn.
+func tablebaseFact(turn chess.Color, tb *chessapi.Tablebase) string {
+	var fact string
+	switch tb.Result {
+	case syzygy.Win.String():
+		fact = fmt.Sprintf("The endgame tablebase shows %s to move wins with perfect play.", turn.Name())
+	case syzygy.CursedWin.String():
+		fact = fmt.Sprintf("The endgame tablebase shows %s to move can force a win, but not before the 50-move rule makes it a draw.", turn.Name())
+	case syzygy.BlessedLoss.String():
+		fact = fmt.Sprintf("The endgame tablebase shows %s to move would lose, but the 50-move rule saves a draw.", turn.Name())
+	case syzygy.Loss.String():
+		fact = fmt.Sprintf("The endgame tablebase shows %s to move loses with perfect play.", turn.Name())
+	default:
+		fact = "The endgame tablebase shows the position is a draw with perfect play."
+	}
+
+	switch {
+	case tb.BestMove == "":
+	case tb.Result == syzygy.Win.String() || tb.Result == syzygy.Loss.String():
+		fact += fmt.Sprintf(" The best move is %s, with the next capture or pawn move %d plies away.", tb.BestMove, max(tb.DTZ, -tb.DTZ))
+	default:
+		fact += fmt.Sprintf(" The best move is %s.", tb.BestMove)
+	}
+	return fact
+}
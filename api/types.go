@@ -0,0 +1,33 @@
+package main
+
+import "github.com/dwhitena/go-genai-workshop-build/api/chessapi"
+
+// The wire types live in chessapi so the Go client shares them.
+type (
+	ParseMoveRequest  = chessapi.ParseMoveRequest
+	ParseMoveResponse = chessapi.ParseMoveResponse
+	MakeMoveRequest   = chessapi.MakeMoveRequest
+	MakeMoveResponse  = chessapi.MakeMoveResponse
+	GenHelpRequest    = chessapi.GenHelpRequest
+	GenHelpResponse   = chessapi.GenHelpResponse
+	HelpStreamEvent   = chessapi.HelpStreamEvent
+	HelpSource        = chessapi.HelpSource
+	CheckResult       = chessapi.CheckResult
+	HealthResponse    = chessapi.HealthResponse
+	UsageResponse     = chessapi.UsageResponse
+	GameStateRequest  = chessapi.GameStateRequest
+	GameStateResponse = chessapi.GameStateResponse
+	EvaluateRequest   = chessapi.EvaluateRequest
+	EvaluateResponse  = chessapi.EvaluateResponse
+	HintRequest       = chessapi.HintRequest
+	HintResponse      = chessapi.HintResponse
+	MoveQuality       = chessapi.MoveQuality
+
+	CreateGameRequest   = chessapi.CreateGameRequest
+	GameSessionResponse = chessapi.GameSessionResponse
+	GameEvent           = chessapi.GameEvent
+	GameCommand         = chessapi.GameCommand
+
+	CreateJobRequest = chessapi.CreateJobRequest
+	JobResponse      = chessapi.JobResponse
+)
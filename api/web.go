@@ -0,0 +1,24 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+	"path"
+
+	"github.com/gorilla/mux"
+)
+
+// webFiles holds the web UI, so the API ships as a single binary.
+//
+//go:embed web
+var webFiles embed.FS
+
+// WebUI serves the web UI page.
+func (s *Server) WebUI(w http.ResponseWriter, r *http.Request) {
+	http.ServeFileFS(w, r, webFiles, "web/index.html")
+}
+
+// WebAsset serves the scripts and styles of the web UI.
+func (s *Server) WebAsset(w http.ResponseWriter, r *http.Request) {
+	http.ServeFileFS(w, r, webFiles, path.Join("web", mux.Vars(r)["file"]))
+}
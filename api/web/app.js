@@ -0,0 +1,352 @@
+// Web UI for the chess API. The server is the source of truth for the
+// rules: /state returns the position and legal moves, /parse applies
+// moves (board clicks are sent in UCI notation) and rates them against
+// the engine's best, /move asks the LLM to reply and /help/stream
+// streams the coach's advice.
+(() => {
+  "use strict";
+
+  const pieces = {
+    K: "♔", Q: "♕", R: "♖", B: "♗", N: "♘", P: "♙",
+    k: "♚", q: "♛", r: "♜", b: "♝", n: "♞", p: "♟",
+  };
+  const files = "abcdefgh";
+
+  const el = (id) => document.getElementById(id);
+  const boardEl = el("board");
+
+  let game = localStorage.getItem("chess.game") || "*";
+  let gameId = localStorage.getItem("chess.gameId") || "";
+  let state = null;
+  let selected = null;
+  let busy = false;
+
+  el("api-key").value = localStorage.getItem("chess.apiKey") || "";
+  el("api-key").addEventListener("change", (e) => {
+    localStorage.setItem("chess.apiKey", e.target.value.trim());
+  });
+
+  // post posts body to path, throwing the error text of a failed request.
+  async function post(path, body, accept) {
+    const headers = { "Content-Type": "application/json", Accept: accept };
+    const key = localStorage.getItem("chess.apiKey");
+    if (key) {
+      headers.Authorization = "Bearer " + key;
+    }
+    const resp = await fetch(path, {
+      method: "POST",
+      headers,
+      body: JSON.stringify(body),
+    });
+    if (!resp.ok) {
+      throw new Error((await resp.text()).trim() || resp.statusText);
+    }
+    return resp;
+  }
+
+  // api posts body to path and returns the decoded JSON response.
+  async function api(path, body) {
+    const resp = await post(path, body, "application/json");
+    return resp.json();
+  }
+
+  // stream posts body to path and calls onEvent(name, data) for each
+  // server-sent event in the response.
+  async function stream(path, body, onEvent) {
+    const resp = await post(path, body, "text/event-stream");
+    const reader = resp.body.getReader();
+    const decoder = new TextDecoder();
+    let buf = "";
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) {
+        return;
+      }
+      buf += decoder.decode(value, { stream: true });
+      let end;
+      while ((end = buf.indexOf("\n\n")) >= 0) {
+        let name = "message";
+        let data = "";
+        for (const line of buf.slice(0, end).split("\n")) {
+          if (line.startsWith("event:")) {
+            name = line.slice(6).trim();
+          } else if (line.startsWith("data:")) {
+            data += line.slice(5);
+          }
+        }
+        buf = buf.slice(end + 2);
+        onEvent(name, JSON.parse(data));
+      }
+    }
+  }
+
+  // movetext drops any PGN tag pairs, which is what the API expects.
+  function movetext(pgn) {
+    return pgn.split("\n\n").pop().trim() || "*";
+  }
+
+  function setGame(pgn) {
+    game = movetext(pgn);
+    localStorage.setItem("chess.game", game);
+  }
+
+  function setGameId(id) {
+    gameId = id;
+    localStorage.setItem("chess.gameId", gameId);
+  }
+
+  // showQuality shows how the coach rated the move just played.
+  function showQuality(q) {
+    const panel = el("quality");
+    if (!q) {
+      panel.textContent = "The coach could not rate that move.";
+      panel.classList.add("muted");
+      return;
+    }
+    let text;
+    switch (q.classification) {
+      case "best":
+        text = `${q.move} is the best move.`;
+        break;
+      case "good":
+        text = `${q.move} is good, ${q.best_move} was a little better.`;
+        break;
+      default: {
+        const article = q.classification === "inaccuracy" ? "an" : "a";
+        text = `${q.move} is ${article} ${q.classification}, ${q.best_move} was better.`;
+        if (q.explanation) text += " " + q.explanation;
+      }
+    }
+    panel.textContent = text;
+    panel.classList.remove("muted");
+  }
+
+  function setBusy(b) {
+    busy = b;
+    document.body.classList.toggle("busy", b);
+    document.querySelectorAll("button, input").forEach((n) => {
+      if (n.id !== "api-key") {
+        n.disabled = b;
+      }
+    });
+  }
+
+  function showError(err) {
+    el("error").textContent = err ? err.message || String(err) : "";
+  }
+
+  async function refresh() {
+    state = await api("/state", { game });
+    selected = null;
+    render();
+  }
+
+  // squaresFromFEN maps square names (e.g. "e4") to FEN piece letters.
+  function squaresFromFEN(fen) {
+    const out = {};
+    fen.split(" ")[0].split("/").forEach((row, i) => {
+      const rank = 8 - i;
+      let file = 0;
+      for (const c of row) {
+        if (/\d/.test(c)) {
+          file += Number(c);
+        } else {
+          out[files[file] + rank] = c;
+          file++;
+        }
+      }
+    });
+    return out;
+  }
+
+  function render() {
+    const squares = squaresFromFEN(state.fen);
+    const last = state.last_move || "";
+    const targets = new Set(
+      selected
+        ? state.legal_moves.filter((m) => m.startsWith(selected)).map((m) => m.slice(2, 4))
+        : []
+    );
+    const kingInCheck = state.check ? (state.turn === "White" ? "K" : "k") : null;
+
+    boardEl.replaceChildren();
+    for (let rank = 8; rank >= 1; rank--) {
+      for (let f = 0; f < 8; f++) {
+        const name = files[f] + rank;
+        const sq = document.createElement("div");
+        sq.className = "square " + ((f + rank) % 2 === 0 ? "light" : "dark");
+        if (last.slice(0, 2) === name || last.slice(2, 4) === name) sq.classList.add("last");
+        if (selected === name) sq.classList.add("selected");
+        if (targets.has(name)) sq.classList.add("target");
+        if (kingInCheck && squares[name] === kingInCheck) sq.classList.add("check");
+        sq.textContent = pieces[squares[name]] || "";
+        sq.dataset.square = name;
+        if (rank === 1) sq.appendChild(coord("file", files[f]));
+        if (f === 0) sq.appendChild(coord("rank", rank));
+        boardEl.appendChild(sq);
+      }
+    }
+
+    const moves = el("moves");
+    moves.replaceChildren();
+    for (let i = 0; i < state.moves.length; i += 2) {
+      const li = document.createElement("li");
+      li.textContent = state.moves[i] + (state.moves[i + 1] ? " " + state.moves[i + 1] : "");
+      moves.appendChild(li);
+    }
+    moves.scrollTop = moves.scrollHeight;
+
+    const opening = state.opening ? ` (${state.opening.eco} ${state.opening.name})` : "";
+    el("status").textContent = "Game status: " + statusText() + opening;
+  }
+
+  function coord(kind, text) {
+    const span = document.createElement("span");
+    span.className = "coord " + kind;
+    span.textContent = text;
+    return span;
+  }
+
+  function statusText() {
+    switch (state.outcome) {
+      case "1-0":
+        return "You won! You are smarter than a LLaMA.";
+      case "0-1":
+        return "LLaMA 3 won!";
+      case "1/2-1/2":
+        return "Draw. Are you a LLaMA?";
+    }
+    const check = state.check ? " Check!" : "";
+    return state.turn === "White"
+      ? "In progress. Your move." + check
+      : "In progress. LLaMA 3 to move." + check;
+  }
+
+  boardEl.addEventListener("click", (e) => {
+    const sq = e.target.closest(".square");
+    if (!sq || busy || !state || state.outcome !== "*" || state.turn !== "White") {
+      return;
+    }
+    const name = sq.dataset.square;
+    if (selected) {
+      const move = state.legal_moves.find((m) => m.startsWith(selected + name));
+      if (move) {
+        // Always promote to a queen from the board; type the move to underpromote.
+        playMove(move.length === 5 ? selected + name + "q" : move);
+        return;
+      }
+    }
+    selected = state.legal_moves.some((m) => m.startsWith(name)) ? name : null;
+    render();
+  });
+
+  async function playMove(text) {
+    showError(null);
+    setBusy(true);
+    try {
+      const coach = el("coach").checked;
+      const resp = await api("/parse", { game, move: text, coach, game_id: gameId });
+      setGame(resp.game_updated);
+      setGameId(resp.game_id);
+      if (coach) showQuality(resp.quality);
+      await refresh();
+      if (state.outcome === "*") {
+        await aiMove();
+      }
+    } catch (err) {
+      showError(err);
+      selected = null;
+      if (state) render();
+    } finally {
+      setBusy(false);
+    }
+  }
+
+  async function aiMove() {
+    const panel = el("ai-move");
+    panel.textContent = "LLaMA 3 is thinking…";
+    try {
+      const resp = await api("/move", { game });
+      setGame(resp.game_updated);
+      const movers = {
+        engine: "LLaMA 3 got stumped, so the engine",
+        book: "LLaMA 3, from the opening book,",
+        tablebase: "LLaMA 3, from the endgame tablebase,",
+      };
+      panel.textContent = (movers[resp.source] || "LLaMA 3") + " played " + resp.move;
+      panel.classList.remove("muted");
+      await refresh();
+    } catch (err) {
+      panel.textContent = "LLaMA 3 got stumped and gave up.";
+      throw err;
+    }
+  }
+
+  el("move-form").addEventListener("submit", (e) => {
+    e.preventDefault();
+    const text = el("move-text").value.trim();
+    if (text) {
+      el("move-text").value = "";
+      playMove(text);
+    }
+  });
+
+  el("get-help").addEventListener("click", async () => {
+    showError(null);
+    setBusy(true);
+    el("help").textContent = "The coach is looking at your game…";
+    el("help").classList.add("muted");
+    el("help-source").hidden = true;
+    const stages = {
+      render_board: "Drawing the board…",
+      analyze_position: "Analysing the position…",
+      describe_game: "The coach is studying your game…",
+      retrieve: "Looking through the reference books…",
+      answer: "The coach is writing…",
+    };
+    let answer = "";
+    try {
+      await stream("/help/stream", { game }, (name, data) => {
+        switch (name) {
+          case "progress":
+            el("help").textContent = stages[data.stage] || el("help").textContent;
+            break;
+          case "token":
+            answer += data.token;
+            el("help").textContent = answer;
+            el("help").classList.remove("muted");
+            break;
+          case "done":
+            el("help").textContent = data.message;
+            el("help").classList.remove("muted");
+            el("help-reference").textContent = data.reference_info || "";
+            el("help-source").hidden = !data.reference_info;
+            break;
+          case "error":
+            throw new Error(data.error);
+        }
+      });
+    } catch (err) {
+      el("help").textContent = "The coach could not help this time.";
+      showError(err);
+    } finally {
+      setBusy(false);
+    }
+  });
+
+  el("new-game").addEventListener("click", () => {
+    setGame("*");
+    setGameId("");
+    el("quality").textContent = "Tick the box to have your moves rated.";
+    el("quality").classList.add("muted");
+    el("ai-move").textContent = "LLaMA 3 is waiting for your move.";
+    el("ai-move").classList.add("muted");
+    refresh().catch(showError);
+  });
+
+  el("copy-pgn").addEventListener("click", () => {
+    navigator.clipboard.writeText(game).catch(showError);
+  });
+
+  refresh().catch(showError);
+})();
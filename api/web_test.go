@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWebUI(t *testing.T) {
+	srv := httptest.NewServer(NewRouter(&Server{}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		file        string // the file served, if any
+	}{
+		{"/app", http.StatusOK, "text/html", "web/index.html"},
+		{"/app/app.js", http.StatusOK, "javascript", "web/app.js"},
+		{"/app/style.css", http.StatusOK, "text/css", "web/style.css"},
+		{"/app/missing.js", http.StatusNotFound, "", ""},
+		{"/app/..%2Fweb.go", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(srv.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body strings.Builder
+		_, err = io.Copy(&body, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("GET %s: status %d, want %d", tt.path, resp.StatusCode, tt.status)
+			continue
+		}
+		if !strings.Contains(resp.Header.Get("Content-Type"), tt.contentType) {
+			t.Errorf("GET %s: content type %q, want %s", tt.path, resp.Header.Get("Content-Type"), tt.contentType)
+		}
+		if tt.file == "" {
+			continue
+		}
+		want, err := os.ReadFile(tt.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body.String() != string(want) {
+			t.Errorf("GET %s: body is not %s", tt.path, tt.file)
+		}
+	}
+}